@@ -0,0 +1,325 @@
+// Package client - типизированный Go-клиент для API товаров.
+//
+//	c := client.New("http://localhost:8080")
+//	c.APIKey = os.Getenv("GOODS_API_KEY")
+//	good, err := c.CreateGood(ctx, 1, "Молоко")
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Good - товар.
+type Good struct {
+	ID          int      `json:"id"`
+	ProjectID   int      `json:"project_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Priority    int      `json:"priority"`
+	Removed     bool     `json:"removed"`
+	CreatedAt   string   `json:"created_at"`
+	Tags        []string `json:"tags"`
+}
+
+// Project - проект.
+type Project struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// RetryPolicy - повтор запросов при 429, 502-504 и сетевых ошибках.
+// Задержка растет экспоненциально от MinBackoff до MaxBackoff со случайным разбросом;
+// Retry-After из ответа имеет приоритет.
+type RetryPolicy struct {
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// RetryPatch - повторять PATCH при 502-504 и сетевых ошибках, как GET, PUT и DELETE.
+	// По умолчанию выключено: /good/update поднимает приоритет при каждом применении,
+	// и повтор запроса, который сервер уже выполнил, поднял бы его дважды.
+	RetryPatch bool
+}
+
+// DefaultRetryPolicy - политика повторов для New.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	MinBackoff: 200 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+}
+
+// Client - клиент API. Поля можно менять после New, но не во время запросов.
+type Client struct {
+	BaseURL string
+	// APIKey отправляется в X-API-Key, Token - в Authorization: Bearer.
+	APIKey     string
+	Token      string
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+}
+
+// New - клиент с http.DefaultClient и DefaultRetryPolicy.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		Retry:      DefaultRetryPolicy,
+	}
+}
+
+// ListGoods - все товары, доступные клиенту.
+func (c *Client) ListGoods(ctx context.Context) ([]Good, error) {
+	var response struct {
+		Goods []Good `json:"goods"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/good/get", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Goods, nil
+}
+
+// ListProjects - все проекты, доступные клиенту.
+func (c *Client) ListProjects(ctx context.Context) ([]Project, error) {
+	var response struct {
+		Projects []Project `json:"projects"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/good/get", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Projects, nil
+}
+
+// GetGood - товар проекта по ID. У API нет отдельного маршрута для одного товара,
+// поэтому товар ищется в общем списке. Если его нет, возвращается ErrNotFound.
+func (c *Client) GetGood(ctx context.Context, projectID, id int) (*Good, error) {
+	goods, err := c.ListGoods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, good := range goods {
+		if good.ID == id && good.ProjectID == projectID {
+			return &good, nil
+		}
+	}
+	return nil, &Error{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("good %d not found in project %d", id, projectID)}
+}
+
+// CreateGood - создает товар в проекте.
+func (c *Client) CreateGood(ctx context.Context, projectID int, name string) (*Good, error) {
+	request := map[string]string{
+		"projectId": strconv.Itoa(projectID),
+		"name":      name,
+	}
+	var good Good
+	if err := c.do(ctx, http.MethodPost, "/good/create", request, &good); err != nil {
+		return nil, err
+	}
+	return &good, nil
+}
+
+// UpdateGood - меняет название и описание товара; сервер увеличивает приоритет.
+// API возвращает только измененные поля, поэтому Removed и CreatedAt в ответе пустые.
+func (c *Client) UpdateGood(ctx context.Context, projectID, id int, name, description string) (*Good, error) {
+	request := map[string]string{
+		"id":          strconv.Itoa(id),
+		"projectId":   strconv.Itoa(projectID),
+		"name":        name,
+		"description": description,
+	}
+	var response struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Priority    int    `json:"Priority"`
+	}
+	if err := c.do(ctx, http.MethodPatch, "/good/update", request, &response); err != nil {
+		return nil, err
+	}
+	return &Good{
+		ID:          id,
+		ProjectID:   projectID,
+		Name:        response.Name,
+		Description: response.Description,
+		Priority:    response.Priority,
+	}, nil
+}
+
+// RemoveGood - удаляет товар.
+func (c *Client) RemoveGood(ctx context.Context, projectID, id int) error {
+	request := map[string]string{
+		"id":        strconv.Itoa(id),
+		"projectId": strconv.Itoa(projectID),
+	}
+	return c.do(ctx, http.MethodDelete, "/good/remove", request, nil)
+}
+
+// CreateProject - создает проект; нужен admin во всех проектах.
+func (c *Client) CreateProject(ctx context.Context, name string) (*Project, error) {
+	var project Project
+	if err := c.do(ctx, http.MethodPost, "/project/create", map[string]string{"name": name}, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// UpdateProject - переименовывает проект.
+func (c *Client) UpdateProject(ctx context.Context, projectID int, name string) (*Project, error) {
+	request := map[string]string{
+		"projectId": strconv.Itoa(projectID),
+		"name":      name,
+	}
+	var project Project
+	if err := c.do(ctx, http.MethodPatch, "/project/update", request, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// RemoveProject - удаляет пустой проект. Если в нем есть товары, возвращает ошибку с ErrConflict.
+func (c *Client) RemoveProject(ctx context.Context, projectID int) error {
+	request := map[string]string{"projectId": strconv.Itoa(projectID)}
+	return c.do(ctx, http.MethodDelete, "/project/remove", request, nil)
+}
+
+// do - выполняет JSON-запрос и декодирует ответ в out (если out не nil).
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	contentType := ""
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+	resp, err := c.doRequest(ctx, method, path, contentType, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: error decoding %s %s response: %v", method, path, err)
+	}
+	return nil
+}
+
+// doRequest - выполняет запрос с повторами. Возвращает успешный ответ,
+// тело которого должен закрыть вызывающий, или ошибку.
+func (c *Client) doRequest(ctx context.Context, method, path, contentType string, payload []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, contentType, payload)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var apiErr *Error
+		if err == nil {
+			apiErr = readError(resp)
+		}
+		if attempt >= c.Retry.MaxRetries || !c.Retry.retryable(method, err, apiErr) {
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			return nil, err
+		}
+
+		delay := c.backoff(attempt)
+		if apiErr != nil && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path, contentType string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.authorize(req)
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// retryable - 429 повторяется всегда: сервер точно не обработал запрос. После 502-504
+// и сетевых ошибок запрос мог быть выполнен, поэтому повторяются только идемпотентные
+// методы; POST - никогда, PATCH - если включен RetryPatch.
+func (p RetryPolicy) retryable(method string, err error, apiErr *Error) bool {
+	if apiErr != nil {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return true
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return p.idempotent(method)
+		}
+		return false
+	}
+	if err == nil {
+		return false
+	}
+	if urlErr, ok := err.(*url.Error); ok && (urlErr.Err == context.Canceled || urlErr.Err == context.DeadlineExceeded) {
+		return false
+	}
+	return p.idempotent(method)
+}
+
+func (p RetryPolicy) idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	case http.MethodPatch:
+		return p.RetryPatch
+	}
+	return false
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.Retry.MinBackoff << uint(attempt)
+	if delay <= 0 || delay > c.Retry.MaxBackoff {
+		delay = c.Retry.MaxBackoff
+	}
+	// Разброс от половины до полной задержки, чтобы клиенты не повторяли запросы синхронно
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
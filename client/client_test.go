@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxRetries: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// flakyServer - отвечает status первые failures запросов, затем body с 200.
+func flakyServer(t *testing.T, failures int32, status int, body string) (*Client, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	c := New(server.URL)
+	c.Retry = fastRetry
+	return c, &calls
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	c, calls := flakyServer(t, 2, http.StatusServiceUnavailable, `{"goods":[{"id":1,"project_id":2,"name":"a"}]}`)
+	goods, err := c.ListGoods(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goods) != 1 || goods[0].Name != "a" || *calls != 3 {
+		t.Errorf("ListGoods = %+v after %d calls", goods, *calls)
+	}
+}
+
+func TestDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	c, calls := flakyServer(t, 1, http.StatusBadGateway, `{}`)
+	if _, err := c.UpdateGood(context.Background(), 1, 2, "name", ""); err == nil || *calls != 1 {
+		t.Errorf("PATCH after 502: err = %v, %d calls, want an error after 1 call", err, *calls)
+	}
+	c, calls = flakyServer(t, 1, http.StatusGatewayTimeout, `{}`)
+	if _, err := c.CreateGood(context.Background(), 1, "name"); err == nil || *calls != 1 {
+		t.Errorf("POST after 504: err = %v, %d calls, want an error after 1 call", err, *calls)
+	}
+
+	c, calls = flakyServer(t, 1, http.StatusBadGateway, `{"name":"name","Priority":3}`)
+	c.Retry.RetryPatch = true
+	good, err := c.UpdateGood(context.Background(), 1, 2, "name", "")
+	if err != nil || good.Priority != 3 || *calls != 2 {
+		t.Errorf("PATCH with RetryPatch = %+v, %v after %d calls", good, err, *calls)
+	}
+}
+
+func TestRetriesRateLimitedRequestsAfterRetryAfter(t *testing.T) {
+	var calls int32
+	var first time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		if waited := time.Since(first); waited < time.Second {
+			t.Errorf("retried after %v, Retry-After is 1s", waited)
+		}
+		w.Write([]byte(`{"id":5,"project_id":1,"name":"a"}`))
+	}))
+	defer server.Close()
+	c := New(server.URL)
+	c.Retry = fastRetry
+
+	// 429 означает, что запрос не выполнен, поэтому повторяется даже POST
+	good, err := c.CreateGood(context.Background(), 1, "a")
+	if err != nil || good.ID != 5 || calls != 2 {
+		t.Errorf("CreateGood = %+v, %v after %d calls", good, err, calls)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	cases := []struct {
+		status int
+		target error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusTooManyRequests, ErrRateLimited},
+	}
+	for _, c := range cases {
+		client, calls := flakyServer(t, 100, c.status, `{}`)
+		client.Retry.MaxRetries = 0
+		err := client.RemoveProject(context.Background(), 1)
+		var apiErr *Error
+		if !errors.Is(err, c.target) || !errors.As(err, &apiErr) || apiErr.StatusCode != c.status {
+			t.Errorf("status %d: err = %v, want %v", c.status, err, c.target)
+		}
+		if apiErr != nil && apiErr.Message != http.StatusText(c.status) {
+			t.Errorf("status %d: message = %q", c.status, apiErr.Message)
+		}
+		if *calls != 1 {
+			t.Errorf("status %d: %d calls", c.status, *calls)
+		}
+	}
+	client, _ := flakyServer(t, 100, http.StatusBadRequest, `{}`)
+	if err := client.RemoveGood(context.Background(), 1, 2); errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || err == nil {
+		t.Errorf("400: err = %v", err)
+	}
+}
+
+func TestGiveUpAfterMaxRetries(t *testing.T) {
+	c, calls := flakyServer(t, 100, http.StatusServiceUnavailable, `{}`)
+	if _, err := c.ListProjects(context.Background()); err == nil || *calls != int32(fastRetry.MaxRetries)+1 {
+		t.Errorf("err = %v after %d calls, want an error after %d", err, *calls, fastRetry.MaxRetries+1)
+	}
+}
+
+func TestRetryStopsWhenContextIsCancelled(t *testing.T) {
+	c, _ := flakyServer(t, 100, http.StatusServiceUnavailable, `{}`)
+	c.Retry = RetryPolicy{MaxRetries: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListGoods(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryableNetworkErrors(t *testing.T) {
+	netErr := &url.Error{Op: "Get", URL: "http://x", Err: errors.New("connection reset by peer")}
+	cancelled := &url.Error{Op: "Get", URL: "http://x", Err: context.Canceled}
+	policy := DefaultRetryPolicy
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if !policy.retryable(method, netErr, nil) {
+			t.Errorf("%s is not retried after a network error", method)
+		}
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPatch} {
+		if policy.retryable(method, netErr, nil) {
+			t.Errorf("%s is retried after a network error", method)
+		}
+	}
+	if policy.retryable(http.MethodGet, cancelled, nil) {
+		t.Error("cancelled request is retried")
+	}
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	c := New("http://localhost")
+	c.Retry = RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 0; attempt < 40; attempt++ {
+		full := c.Retry.MinBackoff << uint(attempt)
+		if full <= 0 || full > c.Retry.MaxBackoff {
+			full = c.Retry.MaxBackoff
+		}
+		if delay := c.backoff(attempt); delay < full/2 || delay > full {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, delay, full/2, full)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ImportOptions - параметры импорта. Format - "csv" (по умолчанию) или "ndjson",
+// Mapping - сопоставление колонок вида "name:title,description:desc".
+type ImportOptions struct {
+	Format  string
+	Mapping string
+	DryRun  bool
+}
+
+// ImportReport - результат импорта или пробного прогона.
+type ImportReport struct {
+	ProjectID int  `json:"project_id"`
+	DryRun    bool `json:"dry_run"`
+	Total     int  `json:"total"`
+	Valid     int  `json:"valid"`
+	Imported  int  `json:"imported"`
+	Errors    []struct {
+		Line    int    `json:"line"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Duplicates []struct {
+		Line     int    `json:"line"`
+		Name     string `json:"name"`
+		Existing bool   `json:"existing"`
+	} `json:"duplicates"`
+}
+
+// ImportGoods - загружает файл с товарами в проект. Файл читается в память целиком,
+// чтобы запрос можно было повторить.
+func (c *Client) ImportGoods(ctx context.Context, projectID int, file io.Reader, opts ImportOptions) (*ImportReport, error) {
+	payload, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	format := opts.Format
+	if format == "" {
+		format = "csv"
+	}
+	query := url.Values{}
+	query.Set("project_id", strconv.Itoa(projectID))
+	query.Set("format", format)
+	if opts.Mapping != "" {
+		query.Set("map", opts.Mapping)
+	}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	contentType := "text/csv"
+	if format == "ndjson" {
+		contentType = "application/x-ndjson"
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodPost, "/good/import?"+query.Encode(), contentType, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var report ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("client: error decoding import report: %v", err)
+	}
+	return &report, nil
+}
+
+// ExportOptions - фильтры выгрузки товаров. Нулевые значения означают "без фильтра".
+type ExportOptions struct {
+	Format    string
+	ProjectID int
+	From      time.Time
+	To        time.Time
+}
+
+// ExportGoods - выгружает товары в w в формате CSV или NDJSON, не загружая их в память.
+func (c *Client) ExportGoods(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	query := url.Values{}
+	if opts.Format != "" {
+		query.Set("format", opts.Format)
+	}
+	if opts.ProjectID != 0 {
+		query.Set("project_id", strconv.Itoa(opts.ProjectID))
+	}
+	if !opts.From.IsZero() {
+		query.Set("from", opts.From.Format(time.RFC3339))
+	}
+	if !opts.To.IsZero() {
+		query.Set("to", opts.To.Format(time.RFC3339))
+	}
+	return c.download(ctx, "/good/export?"+query.Encode(), w)
+}
+
+// ExportProjects - выгружает проекты в w в формате CSV (format "") или NDJSON.
+func (c *Client) ExportProjects(ctx context.Context, w io.Writer, format string) error {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	return c.download(ctx, "/project/export?"+query.Encode(), w)
+}
+
+func (c *Client) download(ctx context.Context, path string, w io.Writer) error {
+	resp, err := c.doRequest(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
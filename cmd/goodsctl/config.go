@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile - адрес API и учетные данные для него.
+type Profile struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+}
+
+// Config - файл настроек goodsctl со списком профилей.
+type Config struct {
+	Current  string              `yaml:"current"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// configPath - $GOODSCTL_CONFIG или ~/.config/goodsctl/config.yaml.
+func configPath() (string, error) {
+	if path := os.Getenv("GOODSCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goodsctl", "config.yaml"), nil
+}
+
+// loadConfig - читает настройки; отсутствующий файл - это пустые настройки.
+func loadConfig() (*Config, error) {
+	config := &Config{Profiles: map[string]*Profile{}}
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]*Profile{}
+	}
+	return config, nil
+}
+
+// save - записывает настройки; файл содержит ключи, поэтому доступен только владельцу.
+func (c *Config) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// profile - профиль с именем name, выбранный флагом -profile, используется как есть.
+// Без флага берется текущий профиль, и переменные окружения GOODS_URL, GOODS_API_KEY
+// и GOODS_TOKEN имеют приоритет над ним.
+func (c *Config) profile(name string) (Profile, error) {
+	var profile Profile
+	if name != "" {
+		p, ok := c.Profiles[name]
+		if !ok {
+			return profile, fmt.Errorf("profile %q not found", name)
+		}
+		profile = *p
+		if profile.URL == "" {
+			profile.URL = "http://localhost:8080"
+		}
+		return profile, nil
+	}
+	if c.Current != "" {
+		p, ok := c.Profiles[c.Current]
+		if !ok {
+			return profile, fmt.Errorf("profile %q not found", c.Current)
+		}
+		profile = *p
+	}
+	if v := os.Getenv("GOODS_URL"); v != "" {
+		profile.URL = v
+	}
+	if v := os.Getenv("GOODS_API_KEY"); v != "" {
+		profile.APIKey = v
+	}
+	if v := os.Getenv("GOODS_TOKEN"); v != "" {
+		profile.Token = v
+	}
+	if profile.URL == "" {
+		profile.URL = "http://localhost:8080"
+	}
+	return profile, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gotest/client"
+)
+
+const usage = `goodsctl - управление товарами и проектами через API.
+
+Использование:
+  goodsctl [-profile NAME] [-o table|json|yaml] КОМАНДА [флаги]
+
+Команды:
+  goods list [-project ID]
+  goods create -project ID -name NAME
+  goods update -project ID -id ID -name NAME [-description TEXT]
+  goods remove -project ID -id ID
+  projects list
+  projects create -name NAME
+  projects update -project ID -name NAME
+  projects remove -project ID
+  import -project ID [-format csv|ndjson] [-map field:column,...] [-dry-run] FILE|-
+  export goods|projects [-format csv|ndjson] [-project ID] [-from DATE] [-to DATE] [-out FILE]
+  config set NAME -url URL [-api-key KEY] [-token TOKEN]
+  config use NAME
+  config list
+
+Профиль, выбранный -profile, используется как есть. Без -profile переменные
+окружения GOODS_URL, GOODS_API_KEY и GOODS_TOKEN имеют приоритет над текущим профилем.
+`
+
+// app - общие для всех команд клиент и формат вывода.
+type app struct {
+	ctx    context.Context
+	client *client.Client
+	config *Config
+	output string
+	stdout io.Writer
+}
+
+func main() {
+	profileName := flag.String("profile", "", "профиль из файла настроек (по умолчанию текущий)")
+	output := flag.String("o", outputTable, "формат вывода: table, json или yaml")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	profile, err := config.profile(*profileName)
+	if err != nil {
+		fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	a := &app{ctx: ctx, client: newClient(profile), config: config, output: *output, stdout: os.Stdout}
+
+	args := flag.Args()
+	switch args[0] {
+	case "goods":
+		err = a.goods(args[1:])
+	case "projects":
+		err = a.projects(args[1:])
+	case "import":
+		err = a.importGoods(args[1:])
+	case "export":
+		err = a.export(args[1:])
+	case "config":
+		err = a.configure(args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// newClient - клиент API с адресом и учетными данными профиля.
+func newClient(profile Profile) *client.Client {
+	c := client.New(profile.URL)
+	c.APIKey = profile.APIKey
+	c.Token = profile.Token
+	return c
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "goodsctl:", err)
+	os.Exit(1)
+}
+
+// subcommand - первый аргумент и FlagSet для остальных.
+func subcommand(args []string, name string) (string, *flag.FlagSet) {
+	if len(args) == 0 {
+		return "", flag.NewFlagSet(name, flag.ExitOnError)
+	}
+	return args[0], flag.NewFlagSet(name+" "+args[0], flag.ExitOnError)
+}
+
+// required - проверяет, что обязательные флаги заданы.
+func required(fs *flag.FlagSet, names ...string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range names {
+		if !set[name] {
+			return fmt.Errorf("%s: flag -%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+func (a *app) goods(args []string) error {
+	command, fs := subcommand(args, "goods")
+	projectID := fs.Int("project", 0, "ID проекта")
+	id := fs.Int("id", 0, "ID товара")
+	name := fs.String("name", "", "название")
+	description := fs.String("description", "", "описание")
+	if len(args) > 0 {
+		fs.Parse(args[1:])
+	}
+
+	switch command {
+	case "list":
+		goods, err := a.client.ListGoods(a.ctx)
+		if err != nil {
+			return err
+		}
+		if *projectID != 0 {
+			filtered := []client.Good{}
+			for _, good := range goods {
+				if good.ProjectID == *projectID {
+					filtered = append(filtered, good)
+				}
+			}
+			goods = filtered
+		}
+		sort.Slice(goods, func(i, j int) bool { return goods[i].ID < goods[j].ID })
+		return render(a.stdout, a.output, goods, goodsTable(goods...))
+	case "create":
+		if err := required(fs, "project", "name"); err != nil {
+			return err
+		}
+		good, err := a.client.CreateGood(a.ctx, *projectID, *name)
+		if err != nil {
+			return err
+		}
+		return render(a.stdout, a.output, good, goodsTable(*good))
+	case "update":
+		if err := required(fs, "project", "id", "name"); err != nil {
+			return err
+		}
+		good, err := a.client.UpdateGood(a.ctx, *projectID, *id, *name, *description)
+		if err != nil {
+			return err
+		}
+		return render(a.stdout, a.output, good, goodsTable(*good))
+	case "remove":
+		if err := required(fs, "project", "id"); err != nil {
+			return err
+		}
+		if err := a.client.RemoveGood(a.ctx, *projectID, *id); err != nil {
+			return err
+		}
+		result := map[string]interface{}{"id": *id, "project_id": *projectID, "removed": true}
+		return render(a.stdout, a.output, result, messageTable(fmt.Sprintf("good %d removed", *id)))
+	}
+	return fmt.Errorf("unknown goods command %q", command)
+}
+
+func (a *app) projects(args []string) error {
+	command, fs := subcommand(args, "projects")
+	projectID := fs.Int("project", 0, "ID проекта")
+	name := fs.String("name", "", "название")
+	if len(args) > 0 {
+		fs.Parse(args[1:])
+	}
+
+	switch command {
+	case "list":
+		projects, err := a.client.ListProjects(a.ctx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+		return render(a.stdout, a.output, projects, projectsTable(projects...))
+	case "create":
+		if err := required(fs, "name"); err != nil {
+			return err
+		}
+		project, err := a.client.CreateProject(a.ctx, *name)
+		if err != nil {
+			return err
+		}
+		return render(a.stdout, a.output, project, projectsTable(*project))
+	case "update":
+		if err := required(fs, "project", "name"); err != nil {
+			return err
+		}
+		project, err := a.client.UpdateProject(a.ctx, *projectID, *name)
+		if err != nil {
+			return err
+		}
+		return render(a.stdout, a.output, project, projectsTable(*project))
+	case "remove":
+		if err := required(fs, "project"); err != nil {
+			return err
+		}
+		if err := a.client.RemoveProject(a.ctx, *projectID); err != nil {
+			return err
+		}
+		result := map[string]interface{}{"project_id": *projectID, "removed": true}
+		return render(a.stdout, a.output, result, messageTable(fmt.Sprintf("project %d removed", *projectID)))
+	}
+	return fmt.Errorf("unknown projects command %q", command)
+}
+
+func (a *app) importGoods(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	projectID := fs.Int("project", 0, "ID проекта")
+	format := fs.String("format", "", "csv или ndjson (по умолчанию - по расширению файла)")
+	mapping := fs.String("map", "", "сопоставление колонок, например name:title,description:desc")
+	dryRun := fs.Bool("dry-run", false, "только проверить файл")
+	fs.Parse(args)
+	if err := required(fs, "project"); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import: expected one FILE argument")
+	}
+
+	path := fs.Arg(0)
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+		if *format == "" {
+			*format = strings.TrimPrefix(filepath.Ext(path), ".")
+			if *format == "jsonl" {
+				*format = "ndjson"
+			}
+		}
+	}
+
+	report, err := a.client.ImportGoods(a.ctx, *projectID, input, client.ImportOptions{
+		Format:  *format,
+		Mapping: *mapping,
+		DryRun:  *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+	if err := render(a.stdout, a.output, report, importTable(report)); err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("import: %d rows failed validation", len(report.Errors))
+	}
+	return nil
+}
+
+func (a *app) export(args []string) error {
+	what, fs := subcommand(args, "export")
+	format := fs.String("format", "csv", "csv или ndjson")
+	projectID := fs.Int("project", 0, "ID проекта")
+	from := fs.String("from", "", "начало периода (RFC3339 или YYYY-MM-DD)")
+	to := fs.String("to", "", "конец периода, не включая (RFC3339 или YYYY-MM-DD)")
+	out := fs.String("out", "-", "файл для выгрузки, - для stdout")
+	if len(args) > 0 {
+		fs.Parse(args[1:])
+	}
+
+	var w io.Writer = a.stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch what {
+	case "goods":
+		opts := client.ExportOptions{Format: *format, ProjectID: *projectID}
+		var err error
+		if opts.From, err = parseDate(*from); err != nil {
+			return fmt.Errorf("export: invalid -from: %v", err)
+		}
+		if opts.To, err = parseDate(*to); err != nil {
+			return fmt.Errorf("export: invalid -to: %v", err)
+		}
+		return a.client.ExportGoods(a.ctx, w, opts)
+	case "projects":
+		return a.client.ExportProjects(a.ctx, w, *format)
+	}
+	return fmt.Errorf("export: expected goods or projects, got %q", what)
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func (a *app) configure(args []string) error {
+	command, fs := subcommand(args, "config")
+	url := fs.String("url", "", "адрес API")
+	apiKey := fs.String("api-key", "", "API-ключ")
+	token := fs.String("token", "", "JWT")
+	var name string
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name = args[1]
+		fs.Parse(args[2:])
+	} else if len(args) > 0 {
+		fs.Parse(args[1:])
+	}
+
+	switch command {
+	case "set":
+		if name == "" {
+			return fmt.Errorf("config set: profile name is required")
+		}
+		profile, ok := a.config.Profiles[name]
+		if !ok {
+			profile = &Profile{}
+			a.config.Profiles[name] = profile
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "url":
+				profile.URL = *url
+			case "api-key":
+				profile.APIKey = *apiKey
+			case "token":
+				profile.Token = *token
+			}
+		})
+		if a.config.Current == "" {
+			a.config.Current = name
+		}
+		return a.config.save()
+	case "use":
+		if _, ok := a.config.Profiles[name]; !ok {
+			return fmt.Errorf("config use: profile %q not found", name)
+		}
+		a.config.Current = name
+		return a.config.save()
+	case "list":
+		var names []string
+		for name := range a.config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t := table{header: []string{"CURRENT", "NAME", "URL", "AUTH"}}
+		profiles := []map[string]interface{}{}
+		for _, name := range names {
+			profile := a.config.Profiles[name]
+			current := ""
+			if name == a.config.Current {
+				current = "*"
+			}
+			auth := "none"
+			if profile.APIKey != "" {
+				auth = "api key"
+			} else if profile.Token != "" {
+				auth = "token"
+			}
+			t.rows = append(t.rows, []string{current, name, profile.URL, auth})
+			// Ключи и токены не печатаем
+			profiles = append(profiles, map[string]interface{}{"name": name, "url": profile.URL, "auth": auth, "current": current == "*"})
+		}
+		return render(a.stdout, a.output, profiles, t)
+	}
+	return fmt.Errorf("unknown config command %q", command)
+}
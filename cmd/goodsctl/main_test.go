@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gotest/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+// useConfigDir - файл настроек во временном каталоге теста.
+func useConfigDir(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "goodsctl", "config.yaml")
+	t.Setenv("GOODSCTL_CONFIG", path)
+	for _, name := range []string{"GOODS_URL", "GOODS_API_KEY", "GOODS_TOKEN"} {
+		t.Setenv(name, "")
+	}
+	return path
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	useConfigDir(t)
+	config := &Config{
+		Current: "local",
+		Profiles: map[string]*Profile{
+			"local": {URL: "http://local", APIKey: "local-key"},
+			"prod":  {URL: "http://prod", Token: "prod-token"},
+			"bare":  {},
+		},
+	}
+	tests := []struct {
+		name    string
+		flag    string
+		env     map[string]string
+		current string
+		want    Profile
+		wantErr bool
+	}{
+		{name: "current profile", current: "local", want: Profile{URL: "http://local", APIKey: "local-key"}},
+		{name: "env over current", current: "local", env: map[string]string{"GOODS_URL": "http://env", "GOODS_TOKEN": "env-token"},
+			want: Profile{URL: "http://env", APIKey: "local-key", Token: "env-token"}},
+		{name: "flag over env", flag: "prod", current: "local", env: map[string]string{"GOODS_URL": "http://env", "GOODS_API_KEY": "env-key"},
+			want: Profile{URL: "http://prod", Token: "prod-token"}},
+		{name: "flag without url", flag: "bare", want: Profile{URL: "http://localhost:8080"}},
+		{name: "env without profiles", env: map[string]string{"GOODS_API_KEY": "env-key"}, want: Profile{URL: "http://localhost:8080", APIKey: "env-key"}},
+		{name: "unknown flag profile", flag: "missing", current: "local", wantErr: true},
+		{name: "unknown current profile", current: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config.Current = tt.current
+			got, err := config.profile(tt.flag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("profile = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientSendsProfileCredentials(t *testing.T) {
+	var key string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("X-API-Key")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"goods":[],"projects":[]}`))
+	}))
+	defer server.Close()
+
+	if _, err := newClient(Profile{URL: server.URL, APIKey: "profile-key"}).ListProjects(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if key != "profile-key" {
+		t.Errorf("X-API-Key = %q, want profile-key", key)
+	}
+}
+
+func TestConfigSetAndUseRoundTrip(t *testing.T) {
+	path := useConfigDir(t)
+	run := func(args ...string) (*app, error) {
+		config, err := loadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := &app{config: config, output: outputTable, stdout: &bytes.Buffer{}}
+		return a, a.configure(args)
+	}
+
+	if _, err := run("set", "local", "-url", "http://local", "-api-key", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("set", "prod", "-url", "http://prod", "-token", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	// Повторный set меняет только переданные флаги
+	if _, err := run("set", "local", "-url", "http://local:9090"); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{Current: "local", Profiles: map[string]*Profile{
+		"local": {URL: "http://local:9090", APIKey: "k1"},
+		"prod":  {URL: "http://prod", Token: "t1"},
+	}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if _, err := run("use", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := loadConfig(); config.Current != "prod" {
+		t.Errorf("current = %q after use prod", config.Current)
+	}
+	if _, err := run("use", "missing"); err == nil {
+		t.Error("use of a missing profile succeeded")
+	}
+	if _, err := run("set"); err == nil {
+		t.Error("set without a name succeeded")
+	}
+
+	a, err := run("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := a.stdout.(*bytes.Buffer).String()
+	if strings.Contains(out, "k1") || strings.Contains(out, "t1") {
+		t.Errorf("config list prints credentials:\n%s", out)
+	}
+	current := false
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "*" {
+			current = fields[1] == "prod"
+		}
+	}
+	if !current {
+		t.Errorf("config list does not mark prod as current:\n%s", out)
+	}
+}
+
+func TestRenderFormats(t *testing.T) {
+	goods := []client.Good{{ID: 1, ProjectID: 2, Name: "apple", Description: "red", Priority: 3, CreatedAt: "2024-01-02T03:04:05Z"}}
+	tests := []struct {
+		format string
+		check  func(t *testing.T, out string)
+	}{
+		{outputTable, func(t *testing.T, out string) {
+			want := "ID  PROJECT  NAME   DESCRIPTION  PRIORITY  REMOVED  CREATED\n" +
+				"1   2        apple  red          3         false    2024-01-02T03:04:05Z\n"
+			if out != want {
+				t.Errorf("table:\n%s\nwant:\n%s", out, want)
+			}
+		}},
+		{outputJSON, func(t *testing.T, out string) {
+			var got []map[string]interface{}
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0]["name"] != "apple" || got[0]["project_id"] != 2.0 {
+				t.Errorf("json = %v", got)
+			}
+			if !strings.Contains(out, "\n  {") {
+				t.Errorf("json is not indented:\n%s", out)
+			}
+		}},
+		{outputYAML, func(t *testing.T, out string) {
+			var got []map[string]interface{}
+			if err := yaml.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatal(err)
+			}
+			// Ключи как в API, а не имена полей Go
+			if len(got) != 1 || got[0]["name"] != "apple" || got[0]["project_id"] != 2 {
+				t.Errorf("yaml = %v", got)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := render(&buf, tt.format, goods, goodsTable(goods...)); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, buf.String())
+		})
+	}
+	if err := render(&bytes.Buffer{}, "xml", goods, goodsTable(goods...)); err == nil {
+		t.Error("unknown format rendered")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gotest/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Форматы вывода.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// table - табличное представление значения.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// render - печатает value в выбранном формате; для table используется t.
+func render(w io.Writer, format string, value interface{}, t table) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case outputYAML:
+		// Через JSON, чтобы ключи совпадали с API, а не с именами полей Go
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(generic); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func goodsTable(goods ...client.Good) table {
+	t := table{header: []string{"ID", "PROJECT", "NAME", "DESCRIPTION", "PRIORITY", "REMOVED", "CREATED"}}
+	for _, good := range goods {
+		t.rows = append(t.rows, []string{
+			strconv.Itoa(good.ID),
+			strconv.Itoa(good.ProjectID),
+			good.Name,
+			good.Description,
+			strconv.Itoa(good.Priority),
+			strconv.FormatBool(good.Removed),
+			good.CreatedAt,
+		})
+	}
+	return t
+}
+
+func projectsTable(projects ...client.Project) table {
+	t := table{header: []string{"ID", "NAME", "CREATED"}}
+	for _, project := range projects {
+		t.rows = append(t.rows, []string{strconv.Itoa(project.ID), project.Name, project.CreatedAt})
+	}
+	return t
+}
+
+func importTable(report *client.ImportReport) table {
+	t := table{header: []string{"LINE", "PROBLEM"}}
+	for _, e := range report.Errors {
+		t.rows = append(t.rows, []string{strconv.Itoa(e.Line), e.Message})
+	}
+	for _, d := range report.Duplicates {
+		problem := "duplicate of line in file: " + d.Name
+		if d.Existing {
+			problem = "already exists in project: " + d.Name
+		}
+		t.rows = append(t.rows, []string{strconv.Itoa(d.Line), problem})
+	}
+	summary := fmt.Sprintf("total %d, valid %d, imported %d", report.Total, report.Valid, report.Imported)
+	if report.DryRun {
+		summary += " (dry run)"
+	}
+	t.rows = append(t.rows, []string{"", summary})
+	return t
+}
+
+func messageTable(message string) table {
+	return table{header: []string{"RESULT"}, rows: [][]string{{message}}}
+}
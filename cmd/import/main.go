@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	gotest "gotest/internal"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	dbHost     = os.Getenv("POSTGRES_HOST")
+	dbPort     = os.Getenv("POSTGRES_PORT")
+	dbUser     = os.Getenv("POSTGRES_USER")
+	dbName     = os.Getenv("POSTGRES_DB")
+	dbPassword = os.Getenv("POSTGRES_PASSWORD")
+	redisHost  = os.Getenv("REDIS_HOST")
+	redisPort  = os.Getenv("REDIS_PORT")
+)
+
+// Импорт товаров из файла:
+//
+//	go run ./cmd/import -project 1 -map name:title -dry-run goods.csv
+func main() {
+	projectID := flag.Int("project", 0, "ID проекта, в который импортируются товары")
+	tenantID := flag.Int("tenant", gotest.DefaultTenantID, "ID организации, которой принадлежит проект")
+	format := flag.String("format", "", "формат файла: csv или ndjson (по умолчанию - по расширению)")
+	columns := flag.String("map", "", "сопоставление колонок, например name:title,description:desc")
+	dryRun := flag.Bool("dry-run", false, "только проверить файл, ничего не записывая")
+	flag.Parse()
+
+	if *projectID == 0 || flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import -project ID [-tenant ID] [-format csv|ndjson] [-map field:column,...] [-dry-run] FILE|-")
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+		if *format == "jsonl" {
+			*format = gotest.FormatNDJSON
+		}
+	}
+	mapping, err := gotest.ParseColumnMapping(*columns)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	if redisPort == "" {
+		redisPort = "6379"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gotest.DefaultStartupTimeout)
+	root, err := gotest.InitDB(ctx, dbHost, dbPort, dbUser, dbPassword, dbName, net.JoinHostPort(redisHost, redisPort), "")
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer root.Close()
+	// Проект ищется и товары пишутся только в пределах организации
+	db := root.ForTenant(*tenantID)
+
+	exists, err := db.CheckIfProjectExists(*projectID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !exists {
+		log.Fatalf("project %d not found", *projectID)
+	}
+
+	report, err := gotest.RunImport(db, *projectID, input, *format, mapping, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
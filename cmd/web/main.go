@@ -1,11 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"flag"
 	gotest "gotest/internal"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -18,43 +22,175 @@ var (
 	dbName     = os.Getenv("POSTGRES_DB")
 	dbPassword = os.Getenv("POSTGRES_PASSWORD")
 	redisHost  = os.Getenv("REDIS_HOST")
+	redisPort  = os.Getenv("REDIS_PORT")
+	adminKey   = os.Getenv("API_ADMIN_KEY")
+	jwtSecret  = os.Getenv("JWT_SECRET")
+	jwksFile   = os.Getenv("JWT_JWKS_FILE")
+	jwtIssuer  = os.Getenv("JWT_ISSUER")
+	jwtAud     = os.Getenv("JWT_AUDIENCE")
+	rateLimits = os.Getenv("RATE_LIMITS")
+	grpcPort   = os.Getenv("GRPC_PORT")
+	searchCfg  = os.Getenv("SEARCH_CONFIG")
+	workers    = os.Getenv("WORKERS")
+	replicas   = os.Getenv("POSTGRES_REPLICAS")
+	replicaLag = os.Getenv("REPLICA_MAX_LAG")
+	maxOpen    = os.Getenv("POSTGRES_MAX_OPEN_CONNS")
+	maxIdle    = os.Getenv("POSTGRES_MAX_IDLE_CONNS")
+	connLife   = os.Getenv("POSTGRES_CONN_MAX_LIFETIME")
+	connIdle   = os.Getenv("POSTGRES_CONN_MAX_IDLE_TIME")
+	// startupTimeout - сколько ждать Postgres и Redis при запуске, например 2m
+	startupTimeout = os.Getenv("STARTUP_TIMEOUT")
 )
 
+// defaultRateLimits - лимиты, если RATE_LIMITS не задан. Обновление товара перестраивает
+// весь кеш, поэтому /good/update ограничен сильнее остальных.
+const defaultRateLimits = "default=20:40,/good/update=2:10,/good/import=0.2:2"
+
 func main() {
-	// Подключение к Redis
-	var err error
-	for i := 0; i < 10; i++ {
-		err = gotest.ConnectToRedis()
-		if err == nil {
-			log.Println("Error connecting to redis: ", err)
-			break
+	dev := flag.Bool("dev", false, "перечитывать шаблоны и статику из ./ui на каждый запрос")
+	flag.Parse()
+
+	// Подключение к базе данных и Redis: при запуске через docker-compose они
+	// могут подняться позже приложения, поэтому ждем их не дольше STARTUP_TIMEOUT
+	timeout := gotest.DefaultStartupTimeout
+	if startupTimeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(startupTimeout); err != nil {
+			panic(err)
 		}
-		fmt.Printf("Ошибка при соединении с сервером Redis: %v. Повторная попытка через 1 секунду\n", err)
-		time.Sleep(1 * time.Second)
 	}
+	if redisPort == "" {
+		redisPort = "6379"
+	}
+	redisAddr := net.JoinHostPort(redisHost, redisPort)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	db, err := gotest.InitDB(ctx, dbHost, dbPort, dbUser, dbPassword, dbName, redisAddr, "")
+	cancel()
 	if err != nil {
-		fmt.Println("Невозможно подключиться к серверу Redis:", err)
-		return
+		log.Fatal(err)
 	}
-	addr := redisHost + ":6379"
-	// Подключение к базе данных
-	db, err := gotest.InitDB(dbHost, dbPort, dbUser, dbPassword, dbName, addr, "")
+	pool, err := gotest.ParsePoolConfig(maxOpen, maxIdle, connLife, connIdle)
 	if err != nil {
 		panic(err)
 	}
+	db.ConfigurePool(pool)
+	// Реплики для чтения: строки подключения через запятую
+	if replicas != "" {
+		maxLag := 5 * time.Second
+		if replicaLag != "" {
+			if maxLag, err = time.ParseDuration(replicaLag); err != nil {
+				panic(err)
+			}
+		}
+		if err := db.ConnectReplicas(strings.Split(replicas, ","), maxLag); err != nil {
+			panic(err)
+		}
+	}
 	handler := gotest.NewHandler(db)
+	handler.SetAdminKey(adminKey)
+	if *dev {
+		templates, err := gotest.LoadTemplates(os.DirFS("ui"), true)
+		if err != nil {
+			panic(err)
+		}
+		handler.SetTemplates(templates)
+	}
+	if jwtSecret != "" || jwksFile != "" {
+		verifier, err := gotest.NewJWTVerifier(jwtSecret, jwksFile, jwtIssuer, jwtAud)
+		if err != nil {
+			panic(err)
+		}
+		handler.SetJWTVerifier(verifier)
+	}
 	err = db.CreateProjectsTable()
 	err = db.CreateGoodsTable()
 	err = db.CreateIndex()
 	if err != nil {
 		panic(err)
 	}
-	// Регистрируем хендлеры
-	http.HandleFunc("/", handler.Main)
-	http.HandleFunc("/good/get", handler.GET)
-	http.HandleFunc("/good/create", handler.POST)
-	http.HandleFunc("/good/update", handler.PATCH)
-	http.HandleFunc("/good/remove", handler.DELETE)
+	if err := db.CreateOrganisationsTable(); err != nil {
+		panic(err)
+	}
+	if err := db.CreateAPIKeysTable(); err != nil {
+		panic(err)
+	}
+	if err := db.CreateTagsTable(); err != nil {
+		panic(err)
+	}
+	if err := db.CreateFieldsTable(); err != nil {
+		panic(err)
+	}
+	if err := db.CreateGoodsVersionsTable(); err != nil {
+		panic(err)
+	}
+	if err := db.CreateSearchIndex(searchCfg); err != nil {
+		panic(err)
+	}
+	if err := db.CreateWebhooksTable(); err != nil {
+		panic(err)
+	}
+	if err := db.CreateOutboxTable(); err != nil {
+		panic(err)
+	}
+	// Политики RLS - после всех таблиц, которые они защищают
+	if err := db.EnableTenantIsolation(); err != nil {
+		panic(err)
+	}
+	if rateLimits == "" {
+		rateLimits = defaultRateLimits
+	}
+	limits, err := gotest.ParseRateLimits(rateLimits)
+	if err != nil {
+		panic(err)
+	}
+	limiter := gotest.NewRateLimiter(db.Redis(), limits)
+
+	// Регистрируем хендлеры. Лимит считается по проверенному ключу, поэтому стоит
+	// после RequireScope; неудачные попытки входа ограничиваются по IP до него
+	for _, route := range handler.Routes() {
+		next := limiter.Limit(route.Path, route.Handler)
+		if route.Scope != "" {
+			next = limiter.LimitAuthFailures(route.Path, handler.RequireScope(route.Scope, next))
+		}
+		http.HandleFunc(route.Path, next)
+	}
+	// gRPC на отдельном порту, с теми же учетными данными и правами
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	listener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		panic(err)
+	}
+	grpcServer := gotest.NewGRPCServer(handler, limiter)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			panic(err)
+		}
+	}()
+	log.Println("Started gRPC - localhost:" + grpcPort)
+
+	// События изменений товаров расходятся из outbox: в Redis и в очередь вебхуков
+	go gotest.NewOutboxRelay(db).Run(context.Background())
+
+	// Вебхуки отправляются в фоне; диспетчер работает с доставками всех организаций
+	go gotest.NewWebhookDispatcher(db).Run(context.Background())
+
+	// Фоновые задачи; WORKERS=0 - задачи выполняет отдельный cmd/worker
+	concurrency := 4
+	if workers != "" {
+		if concurrency, err = strconv.Atoi(workers); err != nil {
+			panic(err)
+		}
+	}
+	if concurrency > 0 {
+		pool := gotest.NewWorkerPool(db.Jobs())
+		pool.Concurrency = concurrency
+		gotest.RegisterJobHandlers(pool, db)
+		go pool.Run(context.Background())
+		log.Printf("Started %d job workers", concurrency)
+	}
+
 	log.Println("Started - http://localhost:8080/")
 	// Запускаем сервер
 	if err := http.ListenAndServe(":8080", nil); err != nil {
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	gotest "gotest/internal"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	dbHost     = os.Getenv("POSTGRES_HOST")
+	dbPort     = os.Getenv("POSTGRES_PORT")
+	dbUser     = os.Getenv("POSTGRES_USER")
+	dbName     = os.Getenv("POSTGRES_DB")
+	dbPassword = os.Getenv("POSTGRES_PASSWORD")
+	redisHost  = os.Getenv("REDIS_HOST")
+	redisPort  = os.Getenv("REDIS_PORT")
+	maxOpen    = os.Getenv("POSTGRES_MAX_OPEN_CONNS")
+	maxIdle    = os.Getenv("POSTGRES_MAX_IDLE_CONNS")
+	connLife   = os.Getenv("POSTGRES_CONN_MAX_LIFETIME")
+	connIdle   = os.Getenv("POSTGRES_CONN_MAX_IDLE_TIME")
+)
+
+// Воркер фоновых задач без HTTP-сервера, для запуска рядом с cmd/web (WORKERS=0):
+//
+//	go run ./cmd/worker -concurrency 8
+//
+// По SIGINT или SIGTERM дожидается текущих задач и завершается.
+func main() {
+	concurrency := flag.Int("concurrency", 4, "число одновременно выполняемых задач")
+	startupTimeout := flag.Duration("startup-timeout", gotest.DefaultStartupTimeout, "сколько ждать Postgres и Redis при запуске")
+	flag.Parse()
+
+	if redisPort == "" {
+		redisPort = "6379"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *startupTimeout)
+	db, err := gotest.InitDB(ctx, dbHost, dbPort, dbUser, dbPassword, dbName, net.JoinHostPort(redisHost, redisPort), "")
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	config, err := gotest.ParsePoolConfig(maxOpen, maxIdle, connLife, connIdle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.ConfigurePool(config)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pool := gotest.NewWorkerPool(db.Jobs())
+	pool.Concurrency = *concurrency
+	gotest.RegisterJobHandlers(pool, db)
+	log.Printf("Started %d job workers", *concurrency)
+	pool.Run(ctx)
+	log.Println("Job workers stopped")
+}
@@ -0,0 +1,357 @@
+// apikeys.go
+package gotest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix - префикс выдаваемых ключей, чтобы их было легко узнать в логах и конфигах.
+const apiKeyPrefix = "gtk_"
+
+// APIKey - API-ключ проекта. В базе хранится только SHA-256 хеш ключа.
+type APIKey struct {
+	ID        int      `json:"id"`
+	ProjectID int      `json:"project_id"`
+	Name      string   `json:"name"`
+	Prefix    string   `json:"prefix"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt string   `json:"created_at"`
+	RevokedAt *string  `json:"revoked_at"`
+	// TenantID - организация проекта ключа.
+	TenantID int `json:"-"`
+	// Key - сам ключ, возвращается только один раз при выпуске.
+	Key string `json:"key,omitempty"`
+}
+
+// CreateAPIKeysTable - метод для создания таблицы api_keys.
+func (s *SingletonDB) CreateAPIKeysTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		project_id INTEGER NOT NULL REFERENCES projects(id),
+		name TEXT NOT NULL DEFAULT '',
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		revoked_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_project_id_index ON api_keys (project_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы api_keys: %v", err)
+	}
+	log.Println("Таблица api_keys успешно создана")
+	return nil
+}
+
+// CreateAPIKey - сохраняет хеш нового ключа.
+func (s *SingletonDB) CreateAPIKey(projectID int, name string, scopes []string, prefix, keyHash string) (*APIKey, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO api_keys (project_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING id, project_id, name, prefix, scopes, created_at, revoked_at"
+	key, err := scanAPIKey(tx.QueryRow(query, projectID, name, prefix, keyHash, pq.Array(scopes)))
+	if err != nil {
+		return nil, fmt.Errorf("error inserting api key: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash - действующий (не отозванный) ключ по хешу вместе с организацией
+// его проекта; nil, если такого нет. Организация до проверки ключа неизвестна,
+// поэтому запрос выполняется без RLS.
+func (s *SingletonDB) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	query := "SELECT k.id, k.project_id, k.name, k.prefix, k.scopes, k.created_at, k.revoked_at, p.tenant_id FROM api_keys k JOIN projects p ON p.id = k.project_id WHERE k.key_hash = $1 AND k.revoked_at IS NULL"
+	var key APIKey
+	var revokedAt sql.NullString
+	err := s.db.QueryRow(query, keyHash).Scan(&key.ID, &key.ProjectID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt, &revokedAt, &key.TenantID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching api key: %v", err)
+	}
+	return &key, nil
+}
+
+// ListAPIKeys - все ключи проекта, включая отозванные.
+func (s *SingletonDB) ListAPIKeys(projectID int) ([]APIKey, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, project_id, name, prefix, scopes, created_at, revoked_at FROM api_keys WHERE project_id = $1 ORDER BY id", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey - отзывает ключ проекта. Возвращает false, если ключ не найден или уже отозван.
+func (s *SingletonDB) RevokeAPIKey(projectID int, id int) (bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND project_id = $2 AND revoked_at IS NULL", id, projectID)
+	if err != nil {
+		return false, fmt.Errorf("error revoking api key: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return n > 0, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var revokedAt sql.NullString
+	err := row.Scan(&key.ID, &key.ProjectID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.String
+	}
+	return &key, nil
+}
+
+// hashAPIKey - ключи случайные и длинные, поэтому достаточно SHA-256 без соли.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIKey - новый случайный ключ вида gtk_<43 символа base64url>.
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// SetAdminKey - задает мастер-ключ с правом admin во всех проектах.
+// Нужен, чтобы выпустить первые ключи проектов. Пустая строка отключает его.
+func (h *Handler) SetAdminKey(key string) {
+	h.adminKeyHash = ""
+	if key != "" {
+		h.adminKeyHash = hashAPIKey(key)
+	}
+}
+
+func (h *Handler) authenticateAPIKey(key, tenant string) (*Principal, error) {
+	keyHash := hashAPIKey(key)
+	if h.adminKeyHash != "" && subtle.ConstantTimeCompare([]byte(keyHash), []byte(h.adminKeyHash)) == 1 {
+		tenantID, err := h.parseTenantID(tenant)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{
+			Actor:      "apikey:admin",
+			TenantID:   tenantID,
+			grants:     map[int][]string{allProjects: {ScopeAdmin}},
+			superAdmin: true,
+		}, nil
+	}
+	apiKey, err := h.db.GetAPIKeyByHash(keyHash)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, errUnauthenticated
+	}
+	return &Principal{
+		Actor:    fmt.Sprintf("apikey:%d", apiKey.ID),
+		TenantID: apiKey.TenantID,
+		grants:   map[int][]string{apiKey.ProjectID: apiKey.Scopes},
+	}, nil
+}
+
+// CreateAPIKey - POST /apikey/create {"projectId": "1", "name": "ci", "scopes": ["read", "write"]}
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		ProjectID string   `json:"projectId"`
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(request.Scopes) == 0 {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !ValidScope(scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope %q", scope), http.StatusBadRequest)
+			return
+		}
+	}
+	exists, err := h.store(r.Context()).CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	apiKey, err := h.store(r.Context()).CreateAPIKey(projectID, request.Name, request.Scopes, key[:len(apiKeyPrefix)+6], hashAPIKey(key))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "apikey.create", projectID, apiKey.ID)
+	apiKey.Key = key
+	responseJSON, err := json.Marshal(apiKey)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseJSON)
+}
+
+// ListAPIKeys - GET /apikey/list?project_id=1
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, err := strconv.Atoi(r.URL.Query().Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	keys, err := h.store(r.Context()).ListAPIKeys(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	responseJSON, err := json.Marshal(keys)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
+
+// RevokeAPIKey - DELETE /apikey/revoke {"id": "3", "projectId": "1"}
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Id        string `json:"id"`
+		ProjectID string `json:"projectId"`
+		Revoked   bool   `json:"revoked"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(request.Id)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	revoked, err := h.store(r.Context()).RevokeAPIKey(projectID, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !revoked {
+		http.NotFound(w, r)
+		return
+	}
+	audit(r.Context(), "apikey.revoke", projectID, id)
+	request.Revoked = true
+	responseJSON, err := json.Marshal(request)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,233 @@
+// auth.go
+package gotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Права доступа. admin включает write, write включает read.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var scopeLevels = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// ValidScope - проверяет, что scope один из известных.
+func ValidScope(scope string) bool {
+	_, ok := scopeLevels[scope]
+	return ok
+}
+
+// allProjects - ключ в Principal.grants, означающий доступ ко всем проектам.
+const allProjects = 0
+
+// errUnauthenticated - в запросе нет учетных данных или они неверны.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// Principal - тот, от чьего имени выполняется запрос.
+type Principal struct {
+	// Actor - идентификатор для аудита, например "apikey:12".
+	Actor string
+	// TenantID - организация, от имени которой выполняются запросы к базе.
+	TenantID int
+	// grants - права по проектам; ключ allProjects действует на все проекты организации.
+	grants map[int][]string
+	// superAdmin - мастер-ключ: управляет организациями и выбирает организацию заголовком.
+	superAdmin bool
+}
+
+// Allows - есть ли у субъекта право scope в проекте projectID.
+func (p *Principal) Allows(projectID int, scope string) bool {
+	for _, id := range []int{projectID, allProjects} {
+		for _, granted := range p.grants[id] {
+			if scopeLevels[granted] >= scopeLevels[scope] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowsAny - есть ли право scope хотя бы в одном проекте.
+func (p *Principal) AllowsAny(scope string) bool {
+	for id := range p.grants {
+		if p.Allows(id, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAll - есть ли право scope во всех projectIDs. Если проекты не указаны,
+// для чтения достаточно права хотя бы в одном проекте: выдачу ограничивает
+// хендлер (см. ProjectIDs). Изменение без проекта в запросе (например, создание
+// проекта) требует права scope во всех проектах.
+func (p *Principal) allowsAll(projectIDs []int, scope string) bool {
+	if len(projectIDs) == 0 {
+		if scope == ScopeRead {
+			return p.AllowsAny(scope)
+		}
+		return p.Allows(allProjects, scope)
+	}
+	allowed := true
+	for _, id := range projectIDs {
+		allowed = allowed && p.Allows(id, scope)
+	}
+	return allowed
+}
+
+// ProjectIDs - проекты, в которых есть право scope; nil означает "все проекты".
+func (p *Principal) ProjectIDs(scope string) []int {
+	if p.Allows(allProjects, scope) {
+		return nil
+	}
+	ids := []int{}
+	for id := range p.grants {
+		if p.Allows(id, scope) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext - субъект, сохраненный middleware RequireScope.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// authenticate - определяет субъекта по заголовкам запроса.
+func (h *Handler) authenticate(r *http.Request) (*Principal, error) {
+	return h.authenticateCredentials(r.Header.Get("X-API-Key"), r.Header.Get("Authorization"), r.Header.Get("X-Tenant-ID"))
+}
+
+// authenticateCredentials - определяет субъекта по API-ключу или значению Authorization.
+// tenant - организация, которую выбирает мастер-ключ; для остальных ключей и токенов
+// организация известна из них самих. Общая часть для HTTP и gRPC.
+func (h *Handler) authenticateCredentials(apiKey, authorization, tenant string) (*Principal, error) {
+	if apiKey != "" {
+		return h.authenticateAPIKey(apiKey, tenant)
+	}
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return h.authenticateJWT(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	return nil, errUnauthenticated
+}
+
+// RequireScope - middleware, пропускающее запрос только при наличии права scope
+// в проекте, указанном в запросе (project_id в query или projectId в JSON-теле).
+func (h *Handler) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := h.authenticate(r)
+		if err == errUnauthenticated {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		projectIDs, err := requestProjectIDs(r)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if !principal.allowsAll(projectIDs, scope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		if r.Method == http.MethodGet {
+			ctx = withReadOnly(ctx)
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// maxPeekBody - сколько байт тела читаем, чтобы найти projectId.
+const maxPeekBody = 1 << 20
+
+// requestProjectIDs - все ID проектов, упомянутые в запросе: project_id в query
+// и projectId в JSON-теле. Тело читается так же, как его прочитает хендлер,
+// и возвращается в запрос, чтобы хендлер смог прочитать его заново.
+func requestProjectIDs(r *http.Request) ([]int, error) {
+	var ids []int
+	if p := r.URL.Query().Get("project_id"); p != "" {
+		id, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if r.Body == nil || r.Method == http.MethodGet {
+		return ids, nil
+	}
+	// Декодер читает из тела ровно первое JSON-значение - так же, как хендлер.
+	// Прочитанное возвращаем в начало тела, остальное хендлер дочитает сам.
+	var peeked bytes.Buffer
+	decoder := json.NewDecoder(io.TeeReader(io.LimitReader(r.Body, maxPeekBody), &peeked))
+	var payload struct {
+		ProjectID json.RawMessage `json:"projectId"`
+	}
+	err := decoder.Decode(&payload)
+	r.Body = readCloser{io.MultiReader(&peeked, r.Body), r.Body}
+	if err != nil {
+		if peeked.Len() >= maxPeekBody {
+			return nil, errors.New("request body too large")
+		}
+		// Не JSON (например, файл импорта) - хендлер разберет тело сам
+		return ids, nil
+	}
+	// projectId приходит и строкой, и числом
+	raw := strings.Trim(string(payload.ProjectID), `"`)
+	if raw == "" || raw == "null" {
+		return ids, nil
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, id), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// audit - пишет в лог, кто и что изменил.
+func audit(ctx context.Context, action string, projectID, id int) {
+	actor := "anonymous"
+	if p := PrincipalFromContext(ctx); p != nil {
+		actor = p.Actor
+	}
+	log.Printf("audit: actor=%s action=%s project=%d id=%d", actor, action, projectID, id)
+}
+
+// visibleProject - может ли субъект из контекста читать проект.
+// Без субъекта (маршрут без авторизации) ограничений нет.
+func visibleProject(ctx context.Context, projectID int) bool {
+	p := PrincipalFromContext(ctx)
+	return p == nil || p.Allows(projectID, ScopeRead)
+}
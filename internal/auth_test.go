@@ -0,0 +1,63 @@
+package gotest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// keyStore - один API-ключ с правами в проекте 1.
+type keyStore struct {
+	DBHandler
+	key APIKey
+}
+
+func (s *keyStore) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	if keyHash != hashAPIKey("project-key") {
+		return nil, nil
+	}
+	return &s.key, nil
+}
+
+func TestRequireScopeChecksRequestProject(t *testing.T) {
+	h := NewHandler(&keyStore{key: APIKey{ID: 7, TenantID: 1, ProjectID: 1, Scopes: []string{ScopeWrite}}})
+	cases := []struct {
+		method, scope, body string
+		want                int
+	}{
+		{http.MethodPatch, ScopeWrite, `{"projectId":"1","id":"5","name":"x"}`, http.StatusOK},
+		{http.MethodPatch, ScopeWrite, `{"projectId":2,"id":"5","name":"x"}`, http.StatusForbidden},
+		// Без projectId ключ проекта 1 не может менять товары: проект выбрал бы хендлер
+		{http.MethodPatch, ScopeWrite, `{"id":"5","name":"x"}`, http.StatusForbidden},
+		{http.MethodPost, ScopeAdmin, `{"name":"new project"}`, http.StatusForbidden},
+		{http.MethodGet, ScopeRead, ``, http.StatusOK},
+	}
+	for _, c := range cases {
+		next := func(w http.ResponseWriter, r *http.Request) {}
+		req := httptest.NewRequest(c.method, "/good/update", strings.NewReader(c.body))
+		req.Header.Set("X-API-Key", "project-key")
+		rec := httptest.NewRecorder()
+		h.RequireScope(c.scope, next)(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %s %s: status %d, want %d", c.method, c.scope, c.body, rec.Code, c.want)
+		}
+	}
+}
+
+func TestAllowsAllWithoutProject(t *testing.T) {
+	project := &Principal{grants: map[int][]string{1: {ScopeAdmin}}}
+	global := &Principal{grants: map[int][]string{allProjects: {ScopeWrite}}}
+	if !project.allowsAll(nil, ScopeRead) {
+		t.Error("project key cannot read without a project")
+	}
+	if project.allowsAll(nil, ScopeWrite) {
+		t.Error("project key can write without naming a project")
+	}
+	if !global.allowsAll(nil, ScopeWrite) || global.allowsAll(nil, ScopeAdmin) {
+		t.Error("global write key: wrong access without a project")
+	}
+	if !project.allowsAll([]int{1}, ScopeWrite) || project.allowsAll([]int{1, 2}, ScopeWrite) {
+		t.Error("project key: wrong access with projects")
+	}
+}
@@ -0,0 +1,441 @@
+package gotest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testGoodsStore - проверки, которые должна проходить любая реализация GoodsStore.
+// newDB возвращает хранилище, данные которого не видны другим вызовам newDB:
+// каждая проверка начинает с чистого листа.
+func testGoodsStore(t *testing.T, newDB func(t *testing.T) GoodsStore) {
+	t.Run("Goods", func(t *testing.T) {
+		db := newDB(t)
+		project := mustCreateProject(t, db, "goods")
+
+		created, err := db.CreateGoods(project.ID, "Кружка", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID == 0 || created.ProjectID != project.ID || created.Name != "Кружка" || created.Removed {
+			t.Fatalf("CreateGoods = %+v", created)
+		}
+		if created.Priority != 1 || created.CreatedAt == "" {
+			t.Errorf("new good has priority %d, created_at %q", created.Priority, created.CreatedAt)
+		}
+
+		got, err := db.GetGood(project.ID, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.ID != created.ID || got.Name != created.Name || got.Priority != created.Priority {
+			t.Fatalf("GetGood = %+v, want %+v", got, created)
+		}
+		goods, err := db.GetGoods()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !containsGood(goods, created.ID) {
+			t.Errorf("GetGoods does not list good %d", created.ID)
+		}
+
+		updated, err := db.UpdateGoods(project.ID, created.ID, "Чашка", "фарфор", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.ID != created.ID || updated.Name != "Чашка" || updated.Description != "фарфор" {
+			t.Errorf("UpdateGoods = %+v", updated)
+		}
+		if got, err := db.GetGood(project.ID, created.ID); err != nil || got == nil || got.Name != "Чашка" {
+			t.Errorf("GetGood after update = %+v, %v", got, err)
+		}
+
+		if err := db.DeleteGoods(project.ID, created.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := db.GetGood(project.ID, created.ID); err != nil || got != nil {
+			t.Errorf("GetGood after delete = %+v, %v", got, err)
+		}
+		if exists, err := db.CheckIfGoodExists(created.ID, project.ID); err != nil || exists {
+			t.Errorf("CheckIfGoodExists after delete = %v, %v", exists, err)
+		}
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		db := newDB(t)
+		project := mustCreateProject(t, db, "priority")
+		good := mustCreateGood(t, db, project.ID, "good")
+
+		// Изменение товара поднимает приоритет на 1, UpdateGoodPriority задает его
+		updated, err := db.UpdateGoods(project.ID, good.ID, good.Name, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Priority != good.Priority+1 {
+			t.Errorf("priority after update = %d, want %d", updated.Priority, good.Priority+1)
+		}
+		updated, err = db.UpdateGoodPriority(project.ID, good.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Priority != 10 || updated.Name != good.Name {
+			t.Errorf("UpdateGoodPriority = %+v", updated)
+		}
+		updated, err = db.UpdateGoods(project.ID, good.ID, good.Name, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Priority != 11 {
+			t.Errorf("priority after update = %d, want 11", updated.Priority)
+		}
+		if got, err := db.GetGood(project.ID, good.ID); err != nil || got == nil || got.Priority != 11 {
+			t.Errorf("GetGood = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		db := newDB(t)
+		project := mustCreateProject(t, db, "exists")
+		other := mustCreateProject(t, db, "other")
+		good := mustCreateGood(t, db, project.ID, "good")
+
+		if exists, err := db.CheckIfProjectExists(project.ID); err != nil || !exists {
+			t.Errorf("CheckIfProjectExists(%d) = %v, %v", project.ID, exists, err)
+		}
+		if exists, err := db.CheckIfGoodExists(good.ID, project.ID); err != nil || !exists {
+			t.Errorf("CheckIfGoodExists(%d, %d) = %v, %v", good.ID, project.ID, exists, err)
+		}
+		// Товар принадлежит проекту: по чужому проекту его нет
+		if exists, err := db.CheckIfGoodExists(good.ID, other.ID); err != nil || exists {
+			t.Errorf("CheckIfGoodExists(%d, %d) = %v, %v", good.ID, other.ID, exists, err)
+		}
+		if got, err := db.GetGood(other.ID, good.ID); err != nil || got != nil {
+			t.Errorf("GetGood(%d, %d) = %+v, %v", other.ID, good.ID, got, err)
+		}
+		if err := db.DeleteGoods(other.ID, good.ID); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := db.CheckIfGoodExists(good.ID, project.ID); err != nil || !exists {
+			t.Errorf("DeleteGoods in another project removed good %d", good.ID)
+		}
+
+		projects, err := db.GetProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !containsProject(projects, project.ID) || !containsProject(projects, other.ID) {
+			t.Errorf("GetProjects = %+v", projects)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		db := newDB(t)
+		project := mustCreateProject(t, db, "not found")
+		const missing = 1 << 30
+
+		if exists, err := db.CheckIfProjectExists(missing); err != nil || exists {
+			t.Errorf("CheckIfProjectExists(missing) = %v, %v", exists, err)
+		}
+		if exists, err := db.CheckIfGoodExists(missing, project.ID); err != nil || exists {
+			t.Errorf("CheckIfGoodExists(missing) = %v, %v", exists, err)
+		}
+		if got, err := db.GetProject(missing); err != nil || got != nil {
+			t.Errorf("GetProject(missing) = %+v, %v", got, err)
+		}
+		if got, err := db.GetGood(project.ID, missing); err != nil || got != nil {
+			t.Errorf("GetGood(missing) = %+v, %v", got, err)
+		}
+		if got, err := db.UpdateGoods(project.ID, missing, "x", "", nil); err == nil || got != nil {
+			t.Errorf("UpdateGoods(missing) = %+v, %v", got, err)
+		}
+		if got, err := db.UpdateGoodPriority(project.ID, missing, 5); err == nil || got != nil {
+			t.Errorf("UpdateGoodPriority(missing) = %+v, %v", got, err)
+		}
+		if got, err := db.UpdateProject(missing, "x"); err == nil || got != nil {
+			t.Errorf("UpdateProject(missing) = %+v, %v", got, err)
+		}
+		if got, err := db.CreateGoods(missing, "x", nil); err == nil || got != nil {
+			t.Errorf("CreateGoods in missing project = %+v, %v", got, err)
+		}
+		// Удаление отсутствующего - не ошибка
+		if err := db.DeleteGoods(project.ID, missing); err != nil {
+			t.Errorf("DeleteGoods(missing) = %v", err)
+		}
+		if err := db.DeleteProject(missing); err != nil {
+			t.Errorf("DeleteProject(missing) = %v", err)
+		}
+	})
+
+	t.Run("Projects", func(t *testing.T) {
+		db := newDB(t)
+		project := mustCreateProject(t, db, "before")
+		if project.ID == 0 || project.Name != "before" || project.CreatedAt == "" {
+			t.Fatalf("CreateProject = %+v", project)
+		}
+		updated, err := db.UpdateProject(project.ID, "after")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.ID != project.ID || updated.Name != "after" {
+			t.Errorf("UpdateProject = %+v", updated)
+		}
+		if got, err := db.GetProject(project.ID); err != nil || got == nil || got.Name != "after" {
+			t.Errorf("GetProject = %+v, %v", got, err)
+		}
+
+		good := mustCreateGood(t, db, project.ID, "good")
+		if err := db.DeleteProject(project.ID); !errors.Is(err, ErrProjectHasGoods) {
+			t.Errorf("DeleteProject with goods = %v, want %v", err, ErrProjectHasGoods)
+		}
+		if err := db.DeleteGoods(project.ID, good.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteProject(project.ID); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := db.CheckIfProjectExists(project.ID); err != nil || exists {
+			t.Errorf("CheckIfProjectExists after delete = %v, %v", exists, err)
+		}
+	})
+
+	t.Run("Batches", func(t *testing.T) {
+		db := newDB(t)
+		first := mustCreateProject(t, db, "first")
+		second := mustCreateProject(t, db, "second")
+		other := mustCreateProject(t, db, "other")
+		a := mustCreateGood(t, db, second.ID, "a")
+		b := mustCreateGood(t, db, first.ID, "b")
+		mustCreateGood(t, db, other.ID, "c")
+
+		goods, err := db.GetGoodsByProjects([]int{first.ID, second.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(goods) != 2 || goods[0].ID != a.ID || goods[1].ID != b.ID {
+			t.Errorf("GetGoodsByProjects = %+v, want goods %d and %d in id order", goods, a.ID, b.ID)
+		}
+		if goods, err := db.GetGoodsByProjects(nil); err != nil || len(goods) != 0 {
+			t.Errorf("GetGoodsByProjects(nil) = %+v, %v", goods, err)
+		}
+
+		missing := other.ID + 1000
+		projects, err := db.GetProjectsByIDs([]int{second.ID, missing, first.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(projects) != 2 || projects[0].ID != first.ID || projects[1].ID != second.ID || projects[1].Name != "second" {
+			t.Errorf("GetProjectsByIDs = %+v", projects)
+		}
+	})
+
+	t.Run("ConcurrentWrites", func(t *testing.T) {
+		db := newDB(t)
+		project := mustCreateProject(t, db, "concurrent")
+		good := mustCreateGood(t, db, project.ID, "shared")
+		const writers = 16
+
+		// Одновременные изменения одного товара не теряют повышений приоритета,
+		// а одновременные вставки получают разные ID
+		ids := make([]int, writers)
+		errs := make([]error, 2*writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = db.UpdateGoods(project.ID, good.ID, "shared", "", nil)
+			}(i)
+			go func(i int) {
+				defer wg.Done()
+				created, err := db.CreateGoods(project.ID, "new", nil)
+				if err == nil {
+					ids[i] = created.ID
+				}
+				errs[writers+i] = err
+			}(i)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := db.GetGood(project.ID, good.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetGood = %+v, %v", got, err)
+		}
+		if got.Priority != good.Priority+writers {
+			t.Errorf("priority after %d concurrent updates = %d, want %d", writers, got.Priority, good.Priority+writers)
+		}
+		seen := map[int]bool{good.ID: true}
+		for _, id := range ids {
+			if seen[id] {
+				t.Errorf("id %d assigned twice", id)
+			}
+			seen[id] = true
+			if exists, err := db.CheckIfGoodExists(id, project.ID); err != nil || !exists {
+				t.Errorf("CheckIfGoodExists(%d) = %v, %v", id, exists, err)
+			}
+		}
+	})
+}
+
+func mustCreateProject(t *testing.T, db GoodsStore, name string) *Project {
+	t.Helper()
+	project, err := db.CreateProject(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+func mustCreateGood(t *testing.T, db GoodsStore, projectID int, name string) *Good {
+	t.Helper()
+	good, err := db.CreateGoods(projectID, name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return good
+}
+
+func containsGood(goods []Good, id int) bool {
+	for _, good := range goods {
+		if good.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsProject(projects []Project, id int) bool {
+	for _, project := range projects {
+		if project.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMemoryDBConformance(t *testing.T) {
+	testGoodsStore(t, func(t *testing.T) GoodsStore { return newMemoryDB() })
+}
+
+// openTestDB - SingletonDB на настоящих Postgres и Redis с созданными таблицами,
+// адреса берутся из тех же переменных окружения, что у cmd/web. Без POSTGRES_HOST
+// тест пропускается, а если POSTGRES_HOST задан, но база недоступна, - падает.
+func openTestDB(t *testing.T) DBHandler {
+	t.Helper()
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_HOST is not set")
+	}
+	port := os.Getenv("POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	redisHost, redisPort := os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")
+	if redisHost == "" {
+		redisHost = "localhost"
+	}
+	if redisPort == "" {
+		redisPort = "6379"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	db, err := InitDB(ctx, host, port, os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DB"), net.JoinHostPort(redisHost, redisPort), "")
+	if err != nil {
+		t.Fatalf("POSTGRES_HOST=%s is set but the database is unreachable: %v", host, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Таблицы - в том же порядке, что при запуске cmd/web
+	for _, create := range []func() error{
+		db.CreateProjectsTable,
+		db.CreateGoodsTable,
+		db.CreateIndex,
+		db.CreateOrganisationsTable,
+		db.CreateAPIKeysTable,
+		db.CreateTagsTable,
+		db.CreateFieldsTable,
+		db.CreateGoodsVersionsTable,
+		func() error { return db.CreateSearchIndex("") },
+		db.CreateWebhooksTable,
+		db.CreateOutboxTable,
+		db.EnableTenantIsolation,
+	} {
+		if err := create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// TestSingletonDBConformance - проверки на настоящих Postgres и Redis (см. openTestDB).
+// Каждая проверка работает в новой организации.
+func TestSingletonDBConformance(t *testing.T) {
+	db := openTestDB(t)
+	testGoodsStore(t, func(t *testing.T) GoodsStore {
+		org, err := db.CreateOrganisation("conformance " + t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db.ForTenant(org.ID)
+	})
+
+	t.Run("TenantIsolation", func(t *testing.T) {
+		testTenantIsolation(t, db)
+	})
+}
+
+// testTenantIsolation - политики RLS и ключи кэша не дают организации увидеть
+// или изменить проекты и товары другой.
+func testTenantIsolation(t *testing.T, root DBHandler) {
+	var stores [2]DBHandler
+	for i := range stores {
+		org, err := root.CreateOrganisation("isolation " + t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = root.ForTenant(org.ID)
+	}
+	owner, other := stores[0], stores[1]
+	project := mustCreateProject(t, owner, "owned")
+	good := mustCreateGood(t, owner, project.ID, "owned good")
+
+	// Список товаров владельца попадает в кэш раньше, чем его читает другая организация
+	if goods, err := owner.GetGoods(); err != nil || len(goods) != 1 {
+		t.Fatalf("owner GetGoods = %v, %v", goods, err)
+	}
+	if goods, err := other.GetGoods(); err != nil || len(goods) != 0 {
+		t.Errorf("other GetGoods = %v, %v; want none", goods, err)
+	}
+	if projects, err := other.GetProjects(); err != nil || len(projects) != 0 {
+		t.Errorf("other GetProjects = %v, %v; want none", projects, err)
+	}
+	if goods, err := other.GetGoodsByProjects([]int{project.ID}); err != nil || len(goods) != 0 {
+		t.Errorf("other GetGoodsByProjects = %v, %v; want none", goods, err)
+	}
+	if got, err := other.GetGood(project.ID, good.ID); err != nil || got != nil {
+		t.Errorf("other GetGood = %v, %v; want nil", got, err)
+	}
+	if exists, err := other.CheckIfProjectExists(project.ID); err != nil || exists {
+		t.Errorf("other CheckIfProjectExists = %v, %v; want false", exists, err)
+	}
+
+	// Запись в чужой проект отклоняется, и товар владельца не меняется
+	if _, err := other.CreateGoods(project.ID, "intruder", nil); err == nil {
+		t.Error("other organisation created a good in a foreign project")
+	}
+	if updated, err := other.UpdateGoods(project.ID, good.ID, "changed", "", nil); err == nil && updated != nil {
+		t.Errorf("other organisation updated a foreign good: %+v", updated)
+	}
+	other.DeleteGoods(project.ID, good.ID)
+	got, err := owner.GetGood(project.ID, good.ID)
+	if err != nil || got == nil || got.Name != "owned good" {
+		t.Errorf("owner good after foreign writes = %+v, %v", got, err)
+	}
+}
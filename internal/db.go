@@ -2,6 +2,7 @@
 package gotest
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -9,22 +10,86 @@ import (
 	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/lib/pq"
 )
 
+// GoodsStore - чтение и изменение товаров и проектов: то, что должна уметь
+// любая реализация хранилища (см. testGoodsStore).
+type GoodsStore interface {
+	GetGoods() ([]Good, error)
+	GetProjects() ([]Project, error)
+	GetGoodsByProjects(projectIDs []int) ([]Good, error)
+	GetProjectsByIDs(ids []int) ([]Project, error)
+	GetGood(projectID int, id int) (*Good, error)
+	GetProject(id int) (*Project, error)
+	CheckIfProjectExists(id int) (bool, error)
+	CheckIfGoodExists(id int, projectID int) (bool, error)
+	CreateGoods(projectId int, name string, fields GoodFields) (*Good, error)
+	UpdateGoods(projectID int, id int, name string, description string, fields GoodFields) (*Good, error)
+	UpdateGoodPriority(projectID int, id int, priority int) (*Good, error)
+	DeleteGoods(projectID int, id int) error
+	CreateProject(name string) (*Project, error)
+	UpdateProject(id int, name string) (*Project, error)
+	DeleteProject(id int) error
+}
+
 // DBHandler - интерфейс для работы с базой данных.
 type DBHandler interface {
+	GoodsStore
 	Connect() error
 	Close()
 	CreateProjectsTable() error // Добавляем метод для создания таблицы projects
 	CreateGoodsTable() error
 	CreateIndex() error // Добавляем метод для создания таблицы projects
-	GetGoods() ([]Good, error)
-	GetProjects() ([]Project, error)
-	CheckIfProjectExists(id int) (bool, error)
-	CheckIfGoodExists(id int, projectID int) (bool, error)
-	CreateGoods(projectId int, name string) (*Good, error)
-	UpdateGoods(projectID int, id int, name string, description string) (*Good, error)
-	DeleteGoods(projectID int, id int) error
+	CreateSearchIndex(config string) error
+	SearchGoods(filter SearchFilter) (*SearchResult, error)
+	ImportGoods(projectID int, rows []ImportRow, dryRun bool) (*ImportReport, error)
+	ExportGoods(ctx context.Context, filter ExportFilter, fn func(Good) error) error
+	ExportProjects(ctx context.Context, fn func(Project) error) error
+	CreateAPIKeysTable() error
+	CreateAPIKey(projectID int, name string, scopes []string, prefix, keyHash string) (*APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*APIKey, error)
+	ListAPIKeys(projectID int) ([]APIKey, error)
+	RevokeAPIKey(projectID int, id int) (bool, error)
+	CreateTagsTable() error
+	CreateFieldsTable() error
+	GetFieldSchema(projectID int) ([]FieldDef, error)
+	SetFieldSchema(projectID int, defs []FieldDef) error
+	CreateGoodsVersionsTable() error
+	GoodHistory(projectID int, id int) ([]GoodVersion, error)
+	RevertGood(projectID int, id int, version int) (*Good, error)
+	CreateTag(projectID int, name string) (*Tag, error)
+	ListTags(projectID int) ([]Tag, error)
+	UpdateTag(projectID int, id int, name string) (*Tag, error)
+	DeleteTag(projectID int, id int) (bool, error)
+	TagGood(projectID int, id int, tag string) (*Good, error)
+	UntagGood(projectID int, id int, tag string) (*Good, error)
+	SubscribeGoodEvents(ctx context.Context) (<-chan GoodEvent, error)
+	GoodEventsSince(lastID int64) ([]GoodEvent, error)
+	Jobs() *JobQueue
+	Redis() *redis.Client
+	RebuildGoodsCache() error
+	ReindexSearch() error
+	CreateOrganisationsTable() error
+	EnableTenantIsolation() error
+	CreateOrganisation(name string) (*Organisation, error)
+	ListOrganisations() ([]Organisation, error)
+	CheckIfOrganisationExists(id int) (bool, error)
+	ForTenant(tenantID int) DBHandler
+	ConfigurePool(config PoolConfig)
+	ConnectReplicas(dsns []string, maxLag time.Duration) error
+	ReadFromReplicas() DBHandler
+	CreateWebhooksTable() error
+	CreateOutboxTable() error
+	RelayOutbox(limit int) (int, error)
+	PurgeOutbox(age time.Duration) (int64, error)
+	CreateWebhook(projectID int, endpoint string, events []string, secret string) (*Webhook, error)
+	ListWebhooks(projectID int) ([]Webhook, error)
+	DeleteWebhook(projectID int, id int) (bool, error)
+	ListWebhookDeliveries(projectID int, webhookID int, status string, limit int) ([]WebhookDelivery, error)
+	RedeliverWebhook(projectID int, id int64) (*WebhookDelivery, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]DueDelivery, error)
+	RecordWebhookAttempt(id int64, attempt WebhookAttempt) error
 }
 
 // SingletonDB - структура, реализующая интерфейс DBHandler.
@@ -36,10 +101,36 @@ type SingletonDB struct {
 	dbUser      string
 	dbPass      string
 	dbName      string
+	// searchConfig - конфигурация полнотекстового поиска, с которой построена колонка goods.search
+	searchConfig string
+	// tenantID - организация, от имени которой выполняются запросы (см. ForTenant);
+	// 0 - подключение без организации, для создания таблиц и проверки ключей.
+	tenantID int
+	// jobs - очередь фоновых задач на том же клиенте Redis
+	jobs *JobQueue
+	// replicas - реплики для чтения (см. ConnectReplicas); nil, если их нет.
+	replicas *replicaSet
+	// readReplicas - чтение, допускающее отставание, идет на реплики (см. ReadFromReplicas).
+	readReplicas bool
+	// pool - настройки пула соединений, retry - повтор транзакций записи товаров.
+	pool  PoolConfig
+	retry RetryPolicy
 }
 
 // Connect - метод для подключения к базе данных.
 func (s *SingletonDB) Connect() error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	if err := s.db.Ping(); err != nil {
+		return fmt.Errorf("Ошибка при проверке соединения с базой данных: %v", err)
+	}
+	log.Println("Соединение с базой данных успешно установлено")
+	return nil
+}
+
+// open - создает пул соединений с базой данных, не подключаясь к ней.
+func (s *SingletonDB) open() error {
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		s.dbHost, s.dbPort, s.dbUser, s.dbPass, s.dbName)
 
@@ -47,19 +138,26 @@ func (s *SingletonDB) Connect() error {
 	if err != nil {
 		return fmt.Errorf("Ошибка при подключении к базе данных: %v", err)
 	}
-
-	err = db.Ping()
-	if err != nil {
-		return fmt.Errorf("Ошибка при проверке соединения с базой данных: %v", err)
-	}
-
+	s.pool.apply(db)
 	s.db = db
-	log.Println("Соединение с базой данных успешно установлено")
 	return nil
 }
 
+// Jobs - очередь фоновых задач.
+func (s *SingletonDB) Jobs() *JobQueue {
+	return s.jobs
+}
+
+// Redis - клиент Redis, созданный InitDB; общий для кэша, очереди задач и лимитов.
+func (s *SingletonDB) Redis() *redis.Client {
+	return s.redisClient
+}
+
 // Close - метод для закрытия соединения с базой данных.
 func (s *SingletonDB) Close() {
+	if s.replicas != nil {
+		s.replicas.close()
+	}
 	if s.db != nil {
 		s.db.Close()
 		log.Println("Соединение с базой данных закрыто")
@@ -104,9 +202,15 @@ func (s *SingletonDB) CreateGoodsTable() error {
 }
 
 func (s *SingletonDB) CheckIfProjectExists(id int) (bool, error) {
+	tx, err := s.readTx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
 	query := "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1)"
 	var exists bool
-	err := s.db.QueryRow(query, id).Scan(&exists)
+	err = tx.QueryRow(query, id).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -126,14 +230,18 @@ func (s *SingletonDB) CreateIndex() error {
 	return nil
 }
 
-// InitDB - функция для инициализации подключения к базе данных.
-func InitDB(dbHost, dbPort, dbUser, dbPass, dbName, redisAddr, redisPass string) (DBHandler, error) {
+// InitDB - функция для инициализации подключения к базе данных. Дожидается Postgres
+// и Redis (см. WaitForDependencies), пока не истечет ctx, и работает дальше
+// с теми же клиентами.
+func InitDB(ctx context.Context, dbHost, dbPort, dbUser, dbPass, dbName, redisAddr, redisPass string) (DBHandler, error) {
 	db := &SingletonDB{
 		dbHost: dbHost,
 		dbPort: dbPort,
 		dbUser: dbUser,
 		dbPass: dbPass,
 		dbName: dbName,
+		pool:   DefaultPoolConfig,
+		retry:  DefaultRetryPolicy,
 	}
 
 	// Инициализация клиента Redis
@@ -142,18 +250,30 @@ func InitDB(dbHost, dbPort, dbUser, dbPass, dbName, redisAddr, redisPass string)
 		Password: redisPass,
 		DB:       0,
 	})
+	db.jobs = NewJobQueue(db.redisClient)
 
-	if err := db.Connect(); err != nil {
+	if err := db.open(); err != nil {
+		return nil, err
+	}
+	if err := WaitForDependencies(ctx, db.Dependencies()...); err != nil {
+		db.Close()
+		db.redisClient.Close()
 		return nil, err
 	}
-	log.Println("Подключение c redis завершено")
+	log.Println("Подключение к базе данных и redis завершено")
 
 	return db, nil
 }
 func (s *SingletonDB) CheckIfGoodExists(id int, projectID int) (bool, error) {
+	tx, err := s.readTx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
 	query := "SELECT EXISTS(SELECT 1 FROM goods WHERE id=$1 AND project_id=$2)"
 	var exists bool
-	err := s.db.QueryRow(query, id, projectID).Scan(&exists)
+	err = tx.QueryRow(query, id, projectID).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -165,7 +285,7 @@ func (s *SingletonDB) GetGoods() ([]Good, error) {
 
 	// Проверяем наличие данных в кеше Redis
 	// Проверяем наличие данных в кеше Redis
-	goodsJSON, err := s.redisClient.Get("goods").Result()
+	goodsJSON, err := s.redisClient.Get(s.cacheKey("goods")).Result()
 
 	if err == redis.Nil {
 		// Если ключ отсутствует в кеше, получаем данные из базы данных
@@ -174,12 +294,18 @@ func (s *SingletonDB) GetGoods() ([]Good, error) {
 			return nil, err
 		}
 
+		// Прочитанное с реплики может отставать, в кеш его не кладем: кеш
+		// заполняет RebuildGoodsCache с primary
+		if s.usesReplicas() {
+			return goodsFromDB, nil
+		}
+
 		// Сохраняем данные в кеш Redis
 		goodsJSON, err := json.Marshal(goodsFromDB)
 		if err != nil {
 			return nil, err
 		}
-		err = s.redisClient.Set("goods", goodsJSON, 10*time.Minute).Err()
+		err = s.redisClient.Set(s.cacheKey("goods"), goodsJSON, 10*time.Minute).Err()
 		if err != nil {
 			return nil, err
 		}
@@ -201,7 +327,24 @@ func (s *SingletonDB) GetGoods() ([]Good, error) {
 }
 
 func (s *SingletonDB) fetchGoodsFromDB() ([]Good, error) {
-	rows, err := s.db.Query("SELECT id, project_id, name, description, priority, removed, created_at FROM goods")
+	return s.queryGoods("")
+}
+
+// GetGoodsByProjects - товары проектов projectIDs в порядке ID. Читает базу одним
+// запросом, минуя кеш всех товаров.
+func (s *SingletonDB) GetGoodsByProjects(projectIDs []int) ([]Good, error) {
+	return s.queryGoods(" WHERE project_id = ANY($1) ORDER BY id", pq.Array(projectIDs))
+}
+
+// queryGoods - товары, отобранные условием cond.
+func (s *SingletonDB) queryGoods(cond string, args ...interface{}) ([]Good, error) {
+	tx, err := s.readTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, project_id, name, description, priority, removed, created_at, fields, "+goodTagsColumn("goods")+" FROM goods"+cond, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -211,17 +354,33 @@ func (s *SingletonDB) fetchGoodsFromDB() ([]Good, error) {
 	for rows.Next() {
 		var good Good
 
-		err := rows.Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt)
+		err := rows.Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields, pq.Array(&good.Tags))
 		if err != nil {
 			return nil, err
 		}
 		goods = append(goods, good)
 	}
-	return goods, nil
+	return goods, rows.Err()
 }
 
 func (s *SingletonDB) GetProjects() ([]Project, error) {
-	rows, err := s.db.Query("SELECT id, name, created_at FROM projects")
+	return s.queryProjects("")
+}
+
+// GetProjectsByIDs - проекты с ID из ids в порядке ID; несуществующие ID пропускаются.
+func (s *SingletonDB) GetProjectsByIDs(ids []int) ([]Project, error) {
+	return s.queryProjects(" WHERE id = ANY($1) ORDER BY id", pq.Array(ids))
+}
+
+// queryProjects - проекты, отобранные условием cond.
+func (s *SingletonDB) queryProjects(cond string, args ...interface{}) ([]Project, error) {
+	tx, err := s.readTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, name, created_at FROM projects"+cond, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -236,29 +395,81 @@ func (s *SingletonDB) GetProjects() ([]Project, error) {
 		}
 		projects = append(projects, project)
 	}
-	return projects, nil
+	return projects, rows.Err()
 }
 
-func (s *SingletonDB) CreateGoods(projectID int, name string) (*Good, error) {
-	// Начинаем транзакцию
-	tx, err := s.db.Begin()
+// GetGood - товар проекта по ID; nil, если такого нет.
+func (s *SingletonDB) GetGood(projectID int, id int) (*Good, error) {
+	tx, err := s.readTx()
 	if err != nil {
-		return nil, fmt.Errorf("error beginning transaction: %v", err)
+		return nil, err
 	}
+	defer tx.Rollback()
+	return getGood(tx, projectID, id)
+}
 
-	query := "INSERT INTO goods (project_id, name) VALUES ($1, $2) RETURNING id, project_id, name, description, priority, removed, created_at"
+// getGood - товар в транзакции tx; nil, если такого нет.
+func getGood(tx *sql.Tx, projectID int, id int) (*Good, error) {
+	query := "SELECT id, project_id, name, description, priority, removed, created_at, fields, " + goodTagsColumn("goods") + " FROM goods WHERE id = $1 AND project_id = $2"
 	var good Good
-	err = tx.QueryRow(query, projectID, name).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt)
+	err := tx.QueryRow(query, id, projectID).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields, pq.Array(&good.Tags))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		// Если произошла ошибка при выполнении запроса, откатываем транзакцию и возвращаем ошибку
-		tx.Rollback()
-		return nil, fmt.Errorf("error inserting goods: %v", err)
+		return nil, err
 	}
+	return &good, nil
+}
 
-	// Коммитим транзакцию
-	if err := tx.Commit(); err != nil {
-		// Если произошла ошибка при коммите транзакции, возвращаем ошибку
-		return nil, fmt.Errorf("error committing transaction: %v", err)
+// GetProject - проект по ID; nil, если такого нет.
+func (s *SingletonDB) GetProject(id int) (*Project, error) {
+	tx, err := s.readTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var project Project
+	err = tx.QueryRow("SELECT id, name, created_at FROM projects WHERE id = $1", id).Scan(&project.ID, &project.Name, &project.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// CreateGoods - добавляет товар. fields проверяются по схеме полей проекта,
+// при несоответствии возвращается *FieldError.
+func (s *SingletonDB) CreateGoods(projectID int, name string, fields GoodFields) (*Good, error) {
+	if fields == nil {
+		fields = GoodFields{}
+	}
+	var good Good
+	err := s.retryTx(func(tx *sql.Tx) error {
+		defs, err := fieldSchema(tx, projectID)
+		if err != nil {
+			return err
+		}
+		if err := validateFields(defs, fields); err != nil {
+			return err
+		}
+
+		query := "INSERT INTO goods (project_id, name, fields) VALUES ($1, $2, $3) RETURNING id, project_id, name, description, priority, removed, created_at, fields"
+		good = Good{Tags: []string{}}
+		err = tx.QueryRow(query, projectID, name, fields).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields)
+		if err != nil {
+			return fmt.Errorf("error inserting goods: %w", err)
+		}
+		if err := recordGoodVersion(tx, good); err != nil {
+			return err
+		}
+		return recordGoodEvent(tx, GoodCreated, good)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Обновляем данные в Redis после успешного добавления товара
@@ -271,7 +482,34 @@ func (s *SingletonDB) CreateGoods(projectID int, name string) (*Good, error) {
 	return &good, nil
 }
 
+// cacheRebuildJob - тип задачи, перестраивающей кеш списка товаров организации.
+const cacheRebuildJob = "cache.rebuild"
+
+// updateGoodsCache - сбрасывает кеш списка товаров и ставит его перестройку в очередь,
+// чтобы запрос, изменивший товар, не ждал выборки всей таблицы. Пока задача
+// не выполнена, GetGoods читает товары из базы. Если очередь недоступна,
+// кеш перестраивается сразу.
 func (s *SingletonDB) updateGoodsCache() error {
+	if err := s.redisClient.Del(s.cacheKey("goods")).Err(); err != nil {
+		return fmt.Errorf("error invalidating goods cache: %v", err)
+	}
+	if s.jobs != nil {
+		_, err := s.jobs.Enqueue(JobRequest{
+			Type:      cacheRebuildJob,
+			TenantID:  s.tenantID,
+			Actor:     "system",
+			UniqueKey: fmt.Sprintf("%s:%d", cacheRebuildJob, s.tenantID),
+		})
+		if err == nil {
+			return nil
+		}
+		log.Println("Error enqueueing goods cache rebuild:", err)
+	}
+	return s.RebuildGoodsCache()
+}
+
+// RebuildGoodsCache - перестраивает кеш списка товаров организации.
+func (s *SingletonDB) RebuildGoodsCache() error {
 	// Получаем все товары из базы данных
 	goods, err := s.fetchGoodsFromDB()
 	if err != nil {
@@ -285,7 +523,7 @@ func (s *SingletonDB) updateGoodsCache() error {
 	}
 
 	// Обновляем данные в кеше Redis
-	err = s.redisClient.Set("goods", goodsJSON, 10*time.Minute).Err()
+	err = s.redisClient.Set(s.cacheKey("goods"), goodsJSON, 10*time.Minute).Err()
 	if err != nil {
 		return fmt.Errorf("error updating goods cache: %v", err)
 	}
@@ -293,70 +531,105 @@ func (s *SingletonDB) updateGoodsCache() error {
 	return nil
 }
 
-func (s *SingletonDB) UpdateGoods(projectID int, id int, name string, description string) (*Good, error) {
-	// Начинаем транзакцию
-	tx, err := s.db.Begin()
+// cacheGood - обновляет кеш товара после закоммиченного изменения. Откатывать
+// изменение из-за Redis нельзя, а событие о нем разошлет RelayOutbox,
+// поэтому ошибки только пишутся в лог.
+func (s *SingletonDB) cacheGood(good Good) {
+	goodJSON, err := json.Marshal(good)
 	if err != nil {
-		return nil, fmt.Errorf("error beginning transaction: %v", err)
+		log.Println("Error marshaling good:", err)
+		return
 	}
-
-	query := "UPDATE goods SET name = $1, description = $2, priority = priority + 1 WHERE id = $3 AND project_id = $4 RETURNING id, project_id, name, description, priority, removed, created_at"
-	var good Good
-	err = tx.QueryRow(query, name, description, id, projectID).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt)
-	if err != nil {
-		// Если произошла ошибка при выполнении запроса, откатываем транзакцию и возвращаем ошибку
-		tx.Rollback()
-		return nil, fmt.Errorf("error updating goods: %v", err)
+	if err := s.redisClient.Set(s.cacheKey("good:%d", good.ID), goodJSON, 10*time.Minute).Err(); err != nil {
+		log.Println("Error caching good:", err)
 	}
-
-	// Коммитим транзакцию
-	if err := tx.Commit(); err != nil {
-		// Если произошла ошибка при коммите транзакции, возвращаем ошибку
-		return nil, fmt.Errorf("error committing transaction: %v", err)
+	if err := s.updateGoodsCache(); err != nil {
+		fmt.Println("Error updating goods cache:", err)
 	}
+}
 
-	// Обновляем данные в Redis
-	updatedGoodJSON, err := json.Marshal(good)
-	if err != nil {
-		return nil, err
-	}
-	err = s.redisClient.Set(fmt.Sprintf("good:%d", good.ID), updatedGoodJSON, 10*time.Minute).Err()
+// UpdateGoods - изменяет товар. fields заменяют прежние значения полей целиком
+// и проверяются по схеме проекта; nil оставляет их без изменений.
+func (s *SingletonDB) UpdateGoods(projectID int, id int, name string, description string, fields GoodFields) (*Good, error) {
+	var good Good
+	err := s.retryTx(func(tx *sql.Tx) error {
+		var fieldsArg interface{}
+		if fields != nil {
+			defs, err := fieldSchema(tx, projectID)
+			if err != nil {
+				return err
+			}
+			if err := validateFields(defs, fields); err != nil {
+				return err
+			}
+			fieldsArg = fields
+		}
+
+		query := "UPDATE goods SET name = $1, description = $2, priority = priority + 1, fields = COALESCE($5::jsonb, fields) WHERE id = $3 AND project_id = $4 RETURNING id, project_id, name, description, priority, removed, created_at, fields, " + goodTagsColumn("goods")
+		err := tx.QueryRow(query, name, description, id, projectID, fieldsArg).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields, pq.Array(&good.Tags))
+		if err != nil {
+			return fmt.Errorf("error updating goods: %w", err)
+		}
+		if err := recordGoodVersion(tx, good); err != nil {
+			return err
+		}
+		return recordGoodEvent(tx, GoodUpdated, good)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.updateGoodsCache()
-	if err != nil {
-		fmt.Println("Error updating goods cache:", err)
-	}
+	s.cacheGood(good)
 	fmt.Println("Data updated successfully in goods table and Redis.")
 	return &good, nil
 }
 
-func (s *SingletonDB) DeleteGoods(projectID int, id int) error {
-	// Начинаем транзакцию
-	tx, err := s.db.Begin()
+// UpdateGoodPriority - задает приоритет товара.
+func (s *SingletonDB) UpdateGoodPriority(projectID int, id int, priority int) (*Good, error) {
+	var good Good
+	err := s.retryTx(func(tx *sql.Tx) error {
+		query := "UPDATE goods SET priority = $1 WHERE id = $2 AND project_id = $3 RETURNING id, project_id, name, description, priority, removed, created_at, fields, " + goodTagsColumn("goods")
+		err := tx.QueryRow(query, priority, id, projectID).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields, pq.Array(&good.Tags))
+		if err != nil {
+			return fmt.Errorf("error updating good priority: %w", err)
+		}
+		if err := recordGoodVersion(tx, good); err != nil {
+			return err
+		}
+		return recordGoodEvent(tx, GoodUpdated, good)
+	})
 	if err != nil {
-		return fmt.Errorf("error beginning transaction: %v", err)
+		return nil, err
 	}
 
-	query := "DELETE FROM goods WHERE project_id = $1 AND id = $2"
-	_, err = tx.Exec(query, projectID, id)
-	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("error deleting goods: %v", err)
-	}
+	s.cacheGood(good)
+	return &good, nil
+}
 
-	// Коммитим транзакцию
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %v", err)
+func (s *SingletonDB) DeleteGoods(projectID int, id int) error {
+	err := s.retryTx(func(tx *sql.Tx) error {
+		query := "DELETE FROM goods WHERE project_id = $1 AND id = $2"
+		result, err := tx.Exec(query, projectID, id)
+		if err != nil {
+			return fmt.Errorf("error deleting goods: %w", err)
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return nil
+		}
+		return recordGoodEvent(tx, GoodRemoved, Good{ID: id, ProjectID: projectID})
+	})
+	if err != nil {
+		return err
 	}
 
-	// Удаляем данные из Redis
-	key := fmt.Sprintf("good:%d", id)
+	// Удаляем данные из Redis. Изменение уже закоммичено, а событие о нем разошлет
+	// RelayOutbox, поэтому ошибка Redis только пишется в лог
+	key := s.cacheKey("good:%d", id)
 	err = s.redisClient.Del(key).Err()
 	if err != nil {
-		return fmt.Errorf("error deleting data from Redis: %v", err)
+		log.Println("Error deleting data from Redis:", err)
 	}
 	err = s.updateGoodsCache()
 	if err != nil {
@@ -0,0 +1,138 @@
+// events.go
+package gotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis"
+)
+
+// Типы событий изменения товаров.
+const (
+	GoodCreated = "created"
+	GoodUpdated = "updated"
+	GoodRemoved = "removed"
+)
+
+// goodsEventsChannel - канал Redis, через который события доходят до всех экземпляров
+// приложения. У каждой организации свой канал (см. cacheKey).
+const goodsEventsChannel = "goods:events"
+
+// goodsEventsLogKey - ключ Redis с журналом последних событий для досылки после переподключения.
+const goodsEventsLogKey = "goods:events:log"
+
+// goodsEventsLogSize - сколько последних событий хранится в журнале.
+const goodsEventsLogSize = 1000
+
+// GoodEvent - изменение товара. Для удаления в Good заполнены только ID и ProjectID.
+// ID - номер записи outbox: события приходят по возрастанию ID, общего для всех
+// экземпляров приложения, а повторно разосланное событие приходит с тем же ID.
+type GoodEvent struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+	Good Good   `json:"good"`
+}
+
+// publishGoodEvent - пишет событие в журнал и рассылает подписчикам организации.
+// Вызывается из RelayOutbox.
+func (s *SingletonDB) publishGoodEvent(event GoodEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling good event: %v", err)
+	}
+	// Журнал и публикация одной транзакцией: событие не попадет в канал, минуя журнал
+	_, err = s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(s.cacheKey(goodsEventsLogKey), redis.Z{Score: float64(event.ID), Member: payload})
+		pipe.ZRemRangeByRank(s.cacheKey(goodsEventsLogKey), 0, -goodsEventsLogSize-1)
+		pipe.Publish(s.cacheKey(goodsEventsChannel), payload)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing good event: %v", err)
+	}
+	return nil
+}
+
+// ErrEventsExpired - журнал уже не хранит всех событий после запрошенного ID:
+// часть из них вытеснена более новыми.
+var ErrEventsExpired = errors.New("good events expired from the log")
+
+// GoodEventsSince - события из журнала с ID больше lastID, по возрастанию ID.
+// Журнал хранит только последние goodsEventsLogSize событий; если события после
+// lastID могли из него выпасть, вместе с оставшимися событиями возвращается ErrEventsExpired.
+func (s *SingletonDB) GoodEventsSince(lastID int64) ([]GoodEvent, error) {
+	key := s.cacheKey(goodsEventsLogKey)
+	var size *redis.IntCmd
+	var oldest *redis.ZSliceCmd
+	var since *redis.StringSliceCmd
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		size = pipe.ZCard(key)
+		oldest = pipe.ZRangeWithScores(key, 0, 0)
+		since = pipe.ZRangeByScore(key, redis.ZRangeBy{
+			Min: "(" + strconv.FormatInt(lastID, 10),
+			Max: "+inf",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading good events: %v", err)
+	}
+	events := make([]GoodEvent, 0, len(since.Val()))
+	for _, payload := range since.Val() {
+		var event GoodEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Println("Error decoding good event:", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	// Журнал не обрезается, пока не заполнен. Заполненный журнал, начинающийся
+	// после lastID, мог потерять события между lastID и своим началом
+	if size.Val() >= goodsEventsLogSize && len(oldest.Val()) > 0 && int64(oldest.Val()[0].Score) > lastID+1 {
+		return events, ErrEventsExpired
+	}
+	return events, nil
+}
+
+// SubscribeGoodEvents - подписка на изменения товаров. Канал закрывается, когда отменяется ctx.
+func (s *SingletonDB) SubscribeGoodEvents(ctx context.Context) (<-chan GoodEvent, error) {
+	pubsub := s.redisClient.Subscribe(s.cacheKey(goodsEventsChannel))
+	// Дожидаемся подтверждения подписки, чтобы не потерять события сразу после вызова
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("error subscribing to good events: %v", err)
+	}
+
+	events := make(chan GoodEvent)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event GoodEvent
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					log.Println("Error decoding good event:", err)
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,342 @@
+// export.go
+package gotest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// exportBatchSize - сколько строк за раз забираем из курсора.
+const exportBatchSize = 500
+
+// ExportFilter - фильтр выгрузки товаров. Нулевые значения означают "без фильтра".
+type ExportFilter struct {
+	ProjectID int
+	// ProjectIDs - если не nil, выгружаются только эти проекты.
+	ProjectIDs []int
+	From       time.Time
+	To         time.Time
+}
+
+// ExportGoods - построчно передает товары в fn, читая их из серверного курсора,
+// так что память не зависит от размера таблицы. Чтение прерывается вместе с ctx.
+func (s *SingletonDB) ExportGoods(ctx context.Context, filter ExportFilter, fn func(Good) error) error {
+	var conditions []string
+	var args []interface{}
+	if filter.ProjectID != 0 {
+		args = append(args, filter.ProjectID)
+		conditions = append(conditions, fmt.Sprintf("project_id = $%d", len(args)))
+	}
+	if filter.ProjectIDs != nil {
+		args = append(args, pq.Array(filter.ProjectIDs))
+		conditions = append(conditions, fmt.Sprintf("project_id = ANY($%d)", len(args)))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	query := "SELECT id, project_id, name, description, priority, removed, created_at, fields, " + goodTagsColumn("goods") + " FROM goods"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id"
+
+	return s.streamCursor(ctx, query, args, func(rows *sql.Rows) error {
+		var good Good
+		err := rows.Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields, pq.Array(&good.Tags))
+		if err != nil {
+			return err
+		}
+		return fn(good)
+	})
+}
+
+// ExportProjects - построчно передает проекты в fn через серверный курсор.
+func (s *SingletonDB) ExportProjects(ctx context.Context, fn func(Project) error) error {
+	query := "SELECT id, name, created_at FROM projects ORDER BY id"
+	return s.streamCursor(ctx, query, nil, func(rows *sql.Rows) error {
+		var project Project
+		if err := rows.Scan(&project.ID, &project.Name, &project.CreatedAt); err != nil {
+			return err
+		}
+		return fn(project)
+	})
+}
+
+// streamCursor - открывает курсор на query в read-only транзакции и вызывает scan
+// для каждой строки, забирая их пачками по exportBatchSize.
+func (s *SingletonDB) streamCursor(ctx context.Context, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	tx, err := s.beginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	// Транзакция только читает, поэтому всегда откатываем ее
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DECLARE export_cursor NO SCROLL CURSOR FOR "+query, args...); err != nil {
+		return fmt.Errorf("error declaring cursor: %v", err)
+	}
+	fetch := fmt.Sprintf("FETCH %d FROM export_cursor", exportBatchSize)
+	for {
+		rows, err := tx.Query(fetch)
+		if err != nil {
+			return fmt.Errorf("error fetching from cursor: %v", err)
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error reading cursor: %v", err)
+		}
+		if n < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+var goodsCSVHeader = []string{"id", "project_id", "name", "description", "priority", "removed", "created_at", "tags", "fields"}
+
+// goodCSVRecord - строка CSV товара; теги через точку с запятой,
+// пользовательские поля - JSON-объектом.
+func goodCSVRecord(good Good) []string {
+	fields := good.Fields
+	if fields == nil {
+		fields = GoodFields{}
+	}
+	fieldsJSON, _ := json.Marshal(fields)
+	return []string{
+		strconv.Itoa(good.ID),
+		strconv.Itoa(good.ProjectID),
+		good.Name,
+		good.Description,
+		strconv.Itoa(good.Priority),
+		strconv.FormatBool(good.Removed),
+		good.CreatedAt,
+		strings.Join(good.Tags, ";"),
+		string(fieldsJSON),
+	}
+}
+
+var projectsCSVHeader = []string{"id", "name", "created_at"}
+
+func projectCSVRecord(project Project) []string {
+	return []string{strconv.Itoa(project.ID), project.Name, project.CreatedAt}
+}
+
+// exportWriter - пишет записи в CSV или NDJSON и периодически сбрасывает их клиенту.
+// Ничего не пишет до первой записи или flush, поэтому ошибку запроса к базе,
+// случившуюся раньше, еще можно вернуть клиенту кодом ответа.
+type exportWriter struct {
+	flusher http.Flusher
+	csv     *csv.Writer
+	json    *json.Encoder
+	header  []string
+	// onStart - вызывается один раз перед тем, как в ответ попадут первые данные.
+	onStart func()
+	started bool
+	count   int
+}
+
+// exportContentType - Content-Type выгрузки в формате format.
+func exportContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// setExportHeaders - заголовки ответа с выгрузкой name, например goods.csv.
+func setExportHeaders(w http.ResponseWriter, format, name string) {
+	w.Header().Set("Content-Type", exportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+}
+
+// newExportWriter - пишет в w; если w - http.Flusher, данные сбрасываются клиенту пачками.
+func newExportWriter(w io.Writer, format string, header []string) *exportWriter {
+	ew := &exportWriter{header: header}
+	ew.flusher, _ = w.(http.Flusher)
+	if format == FormatCSV {
+		ew.csv = csv.NewWriter(w)
+	} else {
+		ew.json = json.NewEncoder(w)
+	}
+	return ew
+}
+
+// newExportResponse - exportWriter для HTTP-ответа с выгрузкой name; заголовки
+// ответа отправляются вместе с первыми данными.
+func newExportResponse(w http.ResponseWriter, format, name string, header []string) *exportWriter {
+	ew := newExportWriter(w, format, header)
+	ew.onStart = func() { setExportHeaders(w, format, name) }
+	return ew
+}
+
+// start - отправляет заголовки ответа и строку заголовка CSV, если еще не отправлены.
+func (ew *exportWriter) start() {
+	if ew.started {
+		return
+	}
+	ew.started = true
+	if ew.onStart != nil {
+		ew.onStart()
+	}
+	if ew.csv != nil {
+		ew.csv.Write(ew.header)
+	}
+}
+
+func (ew *exportWriter) write(record []string, value interface{}) error {
+	ew.start()
+	var err error
+	if ew.csv != nil {
+		err = ew.csv.Write(record)
+	} else {
+		err = ew.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+	ew.count++
+	if ew.count%exportBatchSize == 0 {
+		ew.flush()
+	}
+	return nil
+}
+
+func (ew *exportWriter) flush() {
+	ew.start()
+	if ew.csv != nil {
+		ew.csv.Flush()
+	}
+	if ew.flusher != nil {
+		ew.flusher.Flush()
+	}
+}
+
+// parseExportTime - принимает RFC3339 или просто дату.
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func exportFormat(r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatCSV
+	}
+	return format, format == FormatCSV || format == FormatNDJSON
+}
+
+// Export - GET /good/export?format=csv|ndjson&project_id=&from=&to=&async=
+// С async=true выгрузка ставится в очередь и хендлер сразу отвечает 202 с задачей.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format, ok := exportFormat(r)
+	if !ok {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	var filter ExportFilter
+	var err error
+	if p := query.Get("project_id"); p != "" {
+		if filter.ProjectID, err = strconv.Atoi(p); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if filter.From, err = parseExportTime(query.Get("from")); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseExportTime(query.Get("to")); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		filter.ProjectIDs = principal.ProjectIDs(ScopeRead)
+	}
+
+	if async, _ := strconv.ParseBool(query.Get("async")); async {
+		// Выгрузка соберется в фоне, результат - по /job/result
+		h.enqueueJob(w, r, JobRequest{
+			Type:      exportGoodsJob,
+			ProjectID: filter.ProjectID,
+			Payload:   exportJobPayload{Format: format, Filter: filter},
+			Timeout:   10 * time.Minute,
+		})
+		return
+	}
+
+	ew := newExportResponse(w, format, "goods", goodsCSVHeader)
+	err = h.store(r.Context()).ExportGoods(r.Context(), filter, func(good Good) error {
+		return ew.write(goodCSVRecord(good), good)
+	})
+	finishExport(w, ew, "goods", err)
+}
+
+// finishExport - завершает выгрузку. Если до ошибки ничего не было отправлено,
+// клиент получает 500; иначе заголовки уже ушли, и остается только оборвать
+// выгрузку и записать ошибку в лог.
+func finishExport(w http.ResponseWriter, ew *exportWriter, name string, err error) {
+	if err != nil && !ew.started {
+		log.Printf("Error exporting %s: %v", name, err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	ew.flush()
+	if err != nil {
+		log.Printf("Error exporting %s: %v", name, err)
+	}
+}
+
+// ExportProjects - GET /project/export?format=csv|ndjson
+func (h *Handler) ExportProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format, ok := exportFormat(r)
+	if !ok {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	ew := newExportResponse(w, format, "projects", projectsCSVHeader)
+	err := h.store(r.Context()).ExportProjects(r.Context(), func(project Project) error {
+		if !visibleProject(r.Context(), project.ID) {
+			return nil
+		}
+		return ew.write(projectCSVRecord(project), project)
+	})
+	finishExport(w, ew, "projects", err)
+}
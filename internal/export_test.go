@@ -0,0 +1,135 @@
+package gotest
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newExportStore - проекты 1 и 2; у товара 3 в проекте 1 есть теги и поля.
+func newExportStore(t *testing.T) *memoryHandler {
+	db := newMemoryHandler()
+	first := mustCreateProject(t, db, "first")
+	second := mustCreateProject(t, db, "second")
+	good, err := db.CreateGoods(first.ID, `apple, "red"`, GoodFields{"color": "red", "weight": 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagGoods(db, map[int][]string{good.ID: {"fruit", "sale"}})
+	mustCreateGood(t, db, second.ID, "pear")
+	return db
+}
+
+func exportRequest(h *Handler, target string, principal *Principal) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if principal != nil {
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+	rec := httptest.NewRecorder()
+	h.Export(rec, r)
+	return rec
+}
+
+func TestExportGoodsCSV(t *testing.T) {
+	h := NewHandler(newExportStore(t))
+	rec := exportRequest(h, "/good/export", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="goods.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want header and 2 goods: %v", len(records), records)
+	}
+	if !reflect.DeepEqual(records[0], goodsCSVHeader) {
+		t.Errorf("header = %v", records[0])
+	}
+	row := make(map[string]string)
+	for i, column := range records[0] {
+		row[column] = records[1][i]
+	}
+	if row["id"] != "3" || row["project_id"] != "1" || row["name"] != `apple, "red"` || row["tags"] != "fruit;sale" {
+		t.Errorf("row = %v", row)
+	}
+	var fields GoodFields
+	if err := json.Unmarshal([]byte(row["fields"]), &fields); err != nil {
+		t.Fatalf("fields column %q: %v", row["fields"], err)
+	}
+	if !reflect.DeepEqual(fields, GoodFields{"color": "red", "weight": 1.5}) {
+		t.Errorf("fields = %v", fields)
+	}
+	if last := records[2][len(records[2])-1]; last != "{}" {
+		t.Errorf("fields of a good without fields = %q, want {}", last)
+	}
+}
+
+func TestExportGoodsNDJSON(t *testing.T) {
+	h := NewHandler(newExportStore(t))
+	rec := exportRequest(h, "/good/export?format=ndjson&project_id=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	var goods []Good
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var good Good
+		if err := json.Unmarshal(scanner.Bytes(), &good); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		goods = append(goods, good)
+	}
+	if len(goods) != 1 || goods[0].ID != 3 || goods[0].Fields["color"] != "red" || !reflect.DeepEqual(goods[0].Tags, []string{"fruit", "sale"}) {
+		t.Errorf("goods = %+v", goods)
+	}
+}
+
+func TestExportGoodsFilters(t *testing.T) {
+	h := NewHandler(newExportStore(t))
+	reader := &Principal{Actor: "reader", grants: map[int][]string{2: {ScopeRead}}}
+	tests := []struct {
+		target    string
+		principal *Principal
+		want      int
+		wantRows  int
+	}{
+		{"/good/export?project_id=2", nil, http.StatusOK, 1},
+		{"/good/export?project_id=9", nil, http.StatusOK, 0},
+		{"/good/export", reader, http.StatusOK, 1},
+		{"/good/export?from=2000-01-01", nil, http.StatusOK, 2},
+		{"/good/export?to=2000-01-01", nil, http.StatusOK, 0},
+		{"/good/export?format=xml", nil, http.StatusBadRequest, 0},
+		{"/good/export?project_id=x", nil, http.StatusBadRequest, 0},
+		{"/good/export?from=yesterday", nil, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		rec := exportRequest(h, tt.target, tt.principal)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.target, rec.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if got := len(lines) - 1; got != tt.wantRows {
+			t.Errorf("%s: %d rows, want %d", tt.target, got, tt.wantRows)
+		}
+	}
+}
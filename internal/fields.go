@@ -0,0 +1,432 @@
+// fields.go
+package gotest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Типы пользовательских полей товара.
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	FieldEnum    = "enum"
+)
+
+// fieldNamePattern - имя поля: латиница в нижнем регистре, цифры и подчеркивания.
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// FieldDef - описание пользовательского поля товаров проекта.
+type FieldDef struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Enum     []string `json:"enum,omitempty"`
+}
+
+// FieldError - значения пользовательских полей не соответствуют схеме проекта.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %q %s", e.Field, e.Message)
+}
+
+// GoodFields - значения пользовательских полей товара, колонка goods.fields (JSONB).
+// В JSON числа приходят как float64, поэтому типы значений - string, float64 и bool.
+type GoodFields map[string]interface{}
+
+// Scan - читает JSONB из базы.
+func (f *GoodFields) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*f = GoodFields{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into GoodFields", src)
+	}
+	fields := GoodFields{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*f = fields
+	return nil
+}
+
+// Value - записывает значения в JSONB.
+func (f GoodFields) Value() (driver.Value, error) {
+	// Строкой, а не []byte: pq передает []byte как bytea
+	if f == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(map[string]interface{}(f))
+	return string(data), err
+}
+
+// ImplementsGraphQLType - GoodFields служит скаляром JSON в GraphQL.
+func (GoodFields) ImplementsGraphQLType(name string) bool {
+	return name == "JSON"
+}
+
+// UnmarshalGraphQL - значение скаляра JSON из запроса. Числа из литералов
+// приходят как int32, поэтому значения приводятся к виду encoding/json.
+func (f *GoodFields) UnmarshalGraphQL(input interface{}) error {
+	if _, ok := input.(map[string]interface{}); !ok {
+		return fmt.Errorf("fields must be an object, got %T", input)
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	fields := GoodFields{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*f = fields
+	return nil
+}
+
+// fieldString - значение поля в виде строки, как оно пишется в query и формах.
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// validateFieldSchema - проверяет схему полей перед сохранением.
+func validateFieldSchema(defs []FieldDef) error {
+	seen := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		if !fieldNamePattern.MatchString(def.Name) {
+			return fmt.Errorf("invalid field name %q", def.Name)
+		}
+		if seen[def.Name] {
+			return fmt.Errorf("duplicate field %q", def.Name)
+		}
+		seen[def.Name] = true
+		switch def.Type {
+		case FieldString, FieldNumber, FieldBoolean:
+			if len(def.Enum) > 0 {
+				return fmt.Errorf("field %q: enum values are only allowed for type enum", def.Name)
+			}
+		case FieldEnum:
+			if len(def.Enum) == 0 {
+				return fmt.Errorf("field %q: enum needs at least one value", def.Name)
+			}
+			for _, value := range def.Enum {
+				if value == "" {
+					return fmt.Errorf("field %q: enum values must not be empty", def.Name)
+				}
+			}
+		default:
+			return fmt.Errorf("field %q: unknown type %q", def.Name, def.Type)
+		}
+	}
+	return nil
+}
+
+// validateFields - проверяет значения полей товара по схеме проекта.
+// null считается отсутствующим значением и из fields удаляется.
+func validateFields(defs []FieldDef, fields GoodFields) error {
+	known := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		known[def.Name] = true
+		value, ok := fields[def.Name]
+		if ok && value == nil {
+			delete(fields, def.Name)
+			ok = false
+		}
+		if !ok {
+			if def.Required {
+				return &FieldError{Field: def.Name, Message: "is required"}
+			}
+			continue
+		}
+		switch def.Type {
+		case FieldString:
+			if _, ok := value.(string); !ok {
+				return &FieldError{Field: def.Name, Message: "must be a string"}
+			}
+		case FieldNumber:
+			if _, ok := value.(float64); !ok {
+				return &FieldError{Field: def.Name, Message: "must be a number"}
+			}
+		case FieldBoolean:
+			if _, ok := value.(bool); !ok {
+				return &FieldError{Field: def.Name, Message: "must be a boolean"}
+			}
+		case FieldEnum:
+			s, _ := value.(string)
+			if !containsString(def.Enum, s) {
+				return &FieldError{Field: def.Name, Message: "must be one of " + strings.Join(def.Enum, ", ")}
+			}
+		}
+	}
+	for name := range fields {
+		if !known[name] {
+			return &FieldError{Field: name, Message: "is not defined for the project"}
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateFieldsTable - создает таблицу схем полей и колонку goods.fields.
+func (s *SingletonDB) CreateFieldsTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS project_fields (
+		project_id INTEGER NOT NULL REFERENCES projects(id),
+		name VARCHAR(64) NOT NULL,
+		type VARCHAR(16) NOT NULL,
+		required BOOLEAN NOT NULL DEFAULT FALSE,
+		enum_values TEXT[] NOT NULL DEFAULT '{}',
+		position INTEGER NOT NULL,
+		PRIMARY KEY (project_id, name)
+	);
+	ALTER TABLE goods ADD COLUMN IF NOT EXISTS fields JSONB NOT NULL DEFAULT '{}';
+	`)
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы project_fields: %v", err)
+	}
+	log.Println("Таблица project_fields успешно создана")
+	return nil
+}
+
+// queryer - общее у *sql.DB и *sql.Tx, чтобы схему можно было читать и внутри транзакции.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func fieldSchema(q queryer, projectID int) ([]FieldDef, error) {
+	rows, err := q.Query("SELECT name, type, required, enum_values FROM project_fields WHERE project_id = $1 ORDER BY position", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading field schema: %w", err)
+	}
+	defer rows.Close()
+	defs := []FieldDef{}
+	for rows.Next() {
+		var def FieldDef
+		if err := rows.Scan(&def.Name, &def.Type, &def.Required, pq.Array(&def.Enum)); err != nil {
+			return nil, fmt.Errorf("error scanning field: %w", err)
+		}
+		if len(def.Enum) == 0 {
+			def.Enum = nil
+		}
+		defs = append(defs, def)
+	}
+	return defs, rows.Err()
+}
+
+// GetFieldSchema - схема пользовательских полей проекта в порядке объявления.
+func (s *SingletonDB) GetFieldSchema(projectID int) ([]FieldDef, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return fieldSchema(tx, projectID)
+}
+
+// SetFieldSchema - заменяет схему полей проекта. Значения полей, которых больше нет
+// в схеме, удаляются из товаров; остальные значения заново не проверяются.
+func (s *SingletonDB) SetFieldSchema(projectID int, defs []FieldDef) error {
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM project_fields WHERE project_id = $1", projectID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting field schema: %v", err)
+	}
+	names := make([]string, 0, len(defs))
+	for i, def := range defs {
+		enum := def.Enum
+		if enum == nil {
+			enum = []string{}
+		}
+		_, err := tx.Exec("INSERT INTO project_fields (project_id, name, type, required, enum_values, position) VALUES ($1, $2, $3, $4, $5, $6)",
+			projectID, def.Name, def.Type, def.Required, pq.Array(enum), i)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error inserting field %q: %v", def.Name, err)
+		}
+		names = append(names, def.Name)
+	}
+	// Измененные товары получают новую версию и событие, как при обычном изменении
+	changed, err := tx.Query(`
+	WITH changed AS (
+		UPDATE goods SET fields = (
+			SELECT COALESCE(jsonb_object_agg(key, value), '{}') FROM jsonb_each(fields) WHERE key = ANY($2)
+		) WHERE project_id = $1 AND EXISTS (SELECT 1 FROM jsonb_object_keys(fields) k WHERE k <> ALL($2))
+		RETURNING id, name, description, priority, fields
+	)
+	INSERT INTO goods_versions (good_id, version, name, description, priority, fields)
+	SELECT c.id, (SELECT COALESCE(MAX(v.version), 0) + 1 FROM goods_versions v WHERE v.good_id = c.id),
+		c.name, c.description, c.priority, c.fields
+	FROM changed c
+	RETURNING good_id`,
+		projectID, pq.Array(names))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error dropping removed fields: %v", err)
+	}
+	ids, err := scanIDs(changed)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error dropping removed fields: %v", err)
+	}
+	if err := recordGoodEvents(tx, GoodUpdated, ids); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Коммитим транзакцию
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	if err := s.updateGoodsCache(); err != nil {
+		fmt.Println("Error updating goods cache:", err)
+	}
+	return nil
+}
+
+// fieldsRequest - тело PUT /field/set.
+type fieldsRequest struct {
+	ProjectID string     `json:"projectId"`
+	Fields    []FieldDef `json:"fields"`
+}
+
+// ListFields - GET /field/list?project_id=1
+func (h *Handler) ListFields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, err := strconv.Atoi(r.URL.Query().Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	defs, err := h.store(r.Context()).GetFieldSchema(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, defs)
+}
+
+// SetFields - PUT /field/set {"projectId": "1", "fields": [{"name": "color", "type": "enum", "required": true, "enum": ["red", "green"]}]}
+// Схема заменяется целиком.
+func (h *Handler) SetFields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request fieldsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if request.Fields == nil {
+		request.Fields = []FieldDef{}
+	}
+	if err := validateFieldSchema(request.Fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	if err := h.store(r.Context()).SetFieldSchema(projectID, request.Fields); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "field.set", projectID, 0)
+	writeJSON(w, http.StatusOK, request.Fields)
+}
+
+// writeFieldError - отвечает 400 с описанием ошибки, если err - ошибка валидации полей.
+func writeFieldError(w http.ResponseWriter, err error) bool {
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		return false
+	}
+	http.Error(w, fieldErr.Error(), http.StatusBadRequest)
+	return true
+}
+
+// goodFieldFilter - отбор товаров /good/get по значениям полей: ?field.color=red&field.size=10.
+type goodFieldFilter map[string]string
+
+func parseGoodFieldFilter(r *http.Request) goodFieldFilter {
+	var filter goodFieldFilter
+	for key, values := range r.URL.Query() {
+		name := strings.TrimPrefix(key, "field.")
+		if name == key || name == "" || len(values) == 0 {
+			continue
+		}
+		if filter == nil {
+			filter = make(goodFieldFilter)
+		}
+		filter[name] = values[0]
+	}
+	return filter
+}
+
+// matches - все поля фильтра есть у товара и равны указанным значениям.
+func (f goodFieldFilter) matches(good Good) bool {
+	for name, want := range f {
+		value, ok := good.Fields[name]
+		if !ok || value == nil || fieldString(value) != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package gotest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateFieldSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		defs    []FieldDef
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"all types", []FieldDef{
+			{Name: "color", Type: FieldString},
+			{Name: "weight_kg", Type: FieldNumber, Required: true},
+			{Name: "organic", Type: FieldBoolean},
+			{Name: "size", Type: FieldEnum, Enum: []string{"s", "m", "l"}},
+		}, ""},
+		{"name with capital", []FieldDef{{Name: "Color", Type: FieldString}}, "invalid field name"},
+		{"name starts with digit", []FieldDef{{Name: "1st", Type: FieldString}}, "invalid field name"},
+		{"name with dot", []FieldDef{{Name: "a.b", Type: FieldString}}, "invalid field name"},
+		{"empty name", []FieldDef{{Name: "", Type: FieldString}}, "invalid field name"},
+		{"name too long", []FieldDef{{Name: "a" + strings.Repeat("b", 64), Type: FieldString}}, "invalid field name"},
+		{"duplicate", []FieldDef{{Name: "color", Type: FieldString}, {Name: "color", Type: FieldNumber}}, "duplicate field"},
+		{"unknown type", []FieldDef{{Name: "color", Type: "date"}}, "unknown type"},
+		{"enum without values", []FieldDef{{Name: "size", Type: FieldEnum}}, "at least one value"},
+		{"empty enum value", []FieldDef{{Name: "size", Type: FieldEnum, Enum: []string{"s", ""}}}, "must not be empty"},
+		{"enum values on string", []FieldDef{{Name: "color", Type: FieldString, Enum: []string{"red"}}}, "only allowed for type enum"},
+	}
+	for _, tt := range tests {
+		err := validateFieldSchema(tt.defs)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateFields(t *testing.T) {
+	defs := []FieldDef{
+		{Name: "color", Type: FieldString},
+		{Name: "weight", Type: FieldNumber, Required: true},
+		{Name: "organic", Type: FieldBoolean},
+		{Name: "size", Type: FieldEnum, Enum: []string{"s", "m"}},
+	}
+	tests := []struct {
+		name      string
+		fields    GoodFields
+		want      GoodFields
+		wantField string
+		wantMsg   string
+	}{
+		{
+			name:   "valid",
+			fields: GoodFields{"color": "red", "weight": 1.5, "organic": true, "size": "m"},
+			want:   GoodFields{"color": "red", "weight": 1.5, "organic": true, "size": "m"},
+		},
+		{
+			name:   "only required",
+			fields: GoodFields{"weight": 0.0},
+			want:   GoodFields{"weight": 0.0},
+		},
+		{
+			name:   "null removes optional field",
+			fields: GoodFields{"weight": 2.0, "color": nil, "size": nil},
+			want:   GoodFields{"weight": 2.0},
+		},
+		{name: "missing required", fields: GoodFields{"color": "red"}, wantField: "weight", wantMsg: "is required"},
+		{name: "null required", fields: GoodFields{"weight": nil}, wantField: "weight", wantMsg: "is required"},
+		{name: "string as number", fields: GoodFields{"weight": "2"}, wantField: "weight", wantMsg: "must be a number"},
+		{name: "number as string", fields: GoodFields{"weight": 1.0, "color": 5.0}, wantField: "color", wantMsg: "must be a string"},
+		{name: "string as boolean", fields: GoodFields{"weight": 1.0, "organic": "true"}, wantField: "organic", wantMsg: "must be a boolean"},
+		{name: "outside enum", fields: GoodFields{"weight": 1.0, "size": "xl"}, wantField: "size", wantMsg: "must be one of s, m"},
+		{name: "enum as number", fields: GoodFields{"weight": 1.0, "size": 1.0}, wantField: "size", wantMsg: "must be one of s, m"},
+		{name: "undefined field", fields: GoodFields{"weight": 1.0, "price": 3.0}, wantField: "price", wantMsg: "is not defined for the project"},
+	}
+	for _, tt := range tests {
+		err := validateFields(defs, tt.fields)
+		if tt.wantField == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			} else if !reflect.DeepEqual(tt.fields, tt.want) {
+				t.Errorf("%s: fields = %v, want %v", tt.name, tt.fields, tt.want)
+			}
+			continue
+		}
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != tt.wantField || fieldErr.Message != tt.wantMsg {
+			t.Errorf("%s: err = %v, want field %q %s", tt.name, err, tt.wantField, tt.wantMsg)
+		}
+	}
+
+	// Без схемы допустим только пустой набор полей
+	if err := validateFields(nil, GoodFields{}); err != nil {
+		t.Errorf("no schema, no fields: %v", err)
+	}
+	if err := validateFields(nil, GoodFields{"color": "red"}); err == nil {
+		t.Error("no schema: field accepted")
+	}
+}
+
+func TestGoodFieldFilter(t *testing.T) {
+	parse := func(query string) goodFieldFilter {
+		return parseGoodFieldFilter(httptest.NewRequest(http.MethodGet, "/good/get?"+query, nil))
+	}
+	tests := []struct {
+		query string
+		want  goodFieldFilter
+	}{
+		{"", nil},
+		{"tags=a&field=x&field.=y", nil},
+		{"field.color=red", goodFieldFilter{"color": "red"}},
+		{"field.color=red&field.color=blue&field.size=10", goodFieldFilter{"color": "red", "size": "10"}},
+		{"field.color=", goodFieldFilter{"color": ""}},
+	}
+	for _, tt := range tests {
+		if got := parse(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseGoodFieldFilter(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	good := Good{Fields: GoodFields{"color": "red", "weight": 1.5, "count": 10.0, "organic": true, "note": nil}}
+	matches := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"field.color=red", true},
+		{"field.color=Red", false},
+		{"field.weight=1.5", true},
+		{"field.count=10", true},
+		{"field.count=10.0", false},
+		{"field.organic=true", true},
+		{"field.organic=1", false},
+		{"field.color=red&field.organic=true", true},
+		{"field.color=red&field.organic=false", false},
+		{"field.size=m", false},
+		{"field.note=", false},
+	}
+	for _, tt := range matches {
+		if got := parse(tt.query).matches(good); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,608 @@
+// graphql.go
+package gotest
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// graphqlSchema - схема /graphql.
+//
+//go:embed schema.graphql
+var graphqlSchema string
+
+// maxGraphQLPage - наибольшее значение аргумента first.
+const maxGraphQLPage = 500
+
+// Ошибки GraphQL с теми же текстами, что и ответы HTTP API.
+var (
+	errGraphQLBadRequest = errors.New("Bad request")
+	errGraphQLForbidden  = errors.New("Forbidden")
+	errGraphQLNotFound   = errors.New("Not found")
+	errGraphQLInternal   = errors.New("Internal server error")
+)
+
+// graphqlInternal - пишет ошибку в лог и не отдает ее клиенту.
+func graphqlInternal(err error) error {
+	log.Println(err)
+	return errGraphQLInternal
+}
+
+// requireGrant - проверка права в проекте для резолверов. Маршрут /graphql
+// требует только read, поэтому изменения проверяются здесь.
+func requireGrant(ctx context.Context, projectID int, scope string) error {
+	if p := PrincipalFromContext(ctx); p != nil && !p.Allows(projectID, scope) {
+		return errGraphQLForbidden
+	}
+	return nil
+}
+
+// graphqlLoader - данные одного запроса. Товары загружаются только для нужных
+// проектов, одним запросом на уровень вложенности: список проектов заранее сообщает
+// загрузчику свои ID (wantGoods), и первый же резолвер goods забирает товары всех
+// этих проектов сразу, поэтому список проектов с товарами не превращается в N+1
+// запросов. Так же проекты товаров загружаются пачкой (wantProjects).
+// Резолверы выполняются параллельно.
+type graphqlLoader struct {
+	db DBHandler
+
+	mu sync.Mutex
+	// goods - товары загруженных проектов; ключ есть, если проект уже загружен.
+	goods map[int][]Good
+	// projects - загруженные проекты; nil - проекта нет или он недоступен.
+	projects map[int]*Project
+	// all - все доступные проекты по порядку ID, если список уже читали.
+	all          []Project
+	wantGoods    map[int]bool
+	wantProjects map[int]bool
+}
+
+func newGraphQLLoader(db DBHandler) *graphqlLoader {
+	l := &graphqlLoader{db: db}
+	l.reset()
+	return l
+}
+
+type graphqlLoaderKey struct{}
+
+func loaderFromContext(ctx context.Context) *graphqlLoader {
+	return ctx.Value(graphqlLoaderKey{}).(*graphqlLoader)
+}
+
+func (l *graphqlLoader) reset() {
+	l.goods = make(map[int][]Good)
+	l.projects = make(map[int]*Project)
+	l.all = nil
+	l.wantGoods = make(map[int]bool)
+	l.wantProjects = make(map[int]bool)
+}
+
+// primeGoods - товары этих проектов понадобятся: загрузить их вместе с первыми запрошенными.
+func (l *graphqlLoader) primeGoods(projects []Project) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, project := range projects {
+		l.wantGoods[project.ID] = true
+	}
+}
+
+// primeProjects - проекты этих товаров понадобятся: загрузить их вместе с первым запрошенным.
+func (l *graphqlLoader) primeProjects(goods []Good) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, good := range goods {
+		l.wantProjects[good.ProjectID] = true
+	}
+}
+
+// goodsOf - товары доступных проектов из projectIDs, сгруппированные по проекту.
+// Недоступные проекты в результат не попадают.
+func (l *graphqlLoader) goodsOf(ctx context.Context, projectIDs []int) (map[int][]Good, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	missing := map[int]bool{}
+	for _, id := range projectIDs {
+		missing[id] = true
+	}
+	for id := range l.wantGoods {
+		missing[id] = true
+	}
+	var load []int
+	for id := range missing {
+		if _, ok := l.goods[id]; !ok && visibleProject(ctx, id) {
+			load = append(load, id)
+		}
+	}
+	l.wantGoods = make(map[int]bool)
+	if len(load) > 0 {
+		sort.Ints(load)
+		goods, err := l.db.GetGoodsByProjects(load)
+		if err != nil {
+			return nil, graphqlInternal(err)
+		}
+		for _, id := range load {
+			l.goods[id] = []Good{}
+		}
+		for _, good := range goods {
+			l.goods[good.ProjectID] = append(l.goods[good.ProjectID], good)
+		}
+	}
+	result := make(map[int][]Good, len(projectIDs))
+	for _, id := range projectIDs {
+		if goods, ok := l.goods[id]; ok {
+			result[id] = goods
+		}
+	}
+	return result, nil
+}
+
+// projectsOf - доступные проекты из ids.
+func (l *graphqlLoader) projectsOf(ctx context.Context, ids []int) (map[int]Project, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	missing := map[int]bool{}
+	for _, id := range ids {
+		missing[id] = true
+	}
+	for id := range l.wantProjects {
+		missing[id] = true
+	}
+	var load []int
+	for id := range missing {
+		if _, ok := l.projects[id]; !ok && visibleProject(ctx, id) {
+			load = append(load, id)
+		}
+	}
+	l.wantProjects = make(map[int]bool)
+	if len(load) > 0 {
+		sort.Ints(load)
+		projects, err := l.db.GetProjectsByIDs(load)
+		if err != nil {
+			return nil, graphqlInternal(err)
+		}
+		for _, id := range load {
+			l.projects[id] = nil
+		}
+		for i := range projects {
+			l.projects[projects[i].ID] = &projects[i]
+		}
+	}
+	result := make(map[int]Project, len(ids))
+	for _, id := range ids {
+		if project := l.projects[id]; project != nil {
+			result[id] = *project
+		}
+	}
+	return result, nil
+}
+
+// allProjects - все доступные проекты по порядку ID.
+func (l *graphqlLoader) allProjects(ctx context.Context) ([]Project, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.all != nil {
+		return l.all, nil
+	}
+	projects, err := l.db.GetProjects()
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	l.all = []Project{}
+	for i := range projects {
+		if visibleProject(ctx, projects[i].ID) {
+			l.all = append(l.all, projects[i])
+			l.projects[projects[i].ID] = &projects[i]
+		}
+	}
+	sort.Slice(l.all, func(i, j int) bool { return l.all[i].ID < l.all[j].ID })
+	return l.all, nil
+}
+
+// invalidate - после изменения следующее чтение снова идет в базу.
+func (l *graphqlLoader) invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reset()
+}
+
+// page - проверяет аргументы пагинации.
+func page(first, offset int32) (int, int, error) {
+	f, o := int(first), int(offset)
+	if f < 0 || f > maxGraphQLPage || o < 0 {
+		return 0, 0, errGraphQLBadRequest
+	}
+	return f, o, nil
+}
+
+type goodsArgs struct {
+	Name    *string
+	Removed *bool
+	First   int32
+	Offset  int32
+}
+
+// filterGoods - отбор по name и removed и страница результата.
+func filterGoods(goods []Good, args goodsArgs) (*goodConnectionResolver, error) {
+	first, offset, err := page(args.First, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []Good
+	for _, good := range goods {
+		if args.Name != nil && !strings.Contains(strings.ToLower(good.Name), strings.ToLower(*args.Name)) {
+			continue
+		}
+		if args.Removed != nil && good.Removed != *args.Removed {
+			continue
+		}
+		filtered = append(filtered, good)
+	}
+	connection := &goodConnectionResolver{total: len(filtered)}
+	if offset < len(filtered) {
+		end := offset + first
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+		connection.goods = filtered[offset:end]
+		connection.more = end < len(filtered)
+	}
+	return connection, nil
+}
+
+// goodsPage - страница товаров; проекты ее товаров загрузятся одним запросом.
+func goodsPage(ctx context.Context, goods []Good, args goodsArgs) (*goodConnectionResolver, error) {
+	connection, err := filterGoods(goods, args)
+	if err != nil {
+		return nil, err
+	}
+	loaderFromContext(ctx).primeProjects(connection.goods)
+	return connection, nil
+}
+
+// graphqlRoot - резолверы Query и Mutation.
+type graphqlRoot struct {
+	h *Handler
+}
+
+func (r *graphqlRoot) Projects(ctx context.Context, args struct{ First, Offset int32 }) (*projectConnectionResolver, error) {
+	first, offset, err := page(args.First, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	loader := loaderFromContext(ctx)
+	list, err := loader.allProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connection := &projectConnectionResolver{total: len(list)}
+	if offset < len(list) {
+		end := offset + first
+		if end > len(list) {
+			end = len(list)
+		}
+		connection.projects = list[offset:end]
+		connection.more = end < len(list)
+	}
+	loader.primeGoods(connection.projects)
+	return connection, nil
+}
+
+func (r *graphqlRoot) Project(ctx context.Context, args struct{ ID int32 }) (*projectResolver, error) {
+	projects, err := loaderFromContext(ctx).projectsOf(ctx, []int{int(args.ID)})
+	if err != nil {
+		return nil, err
+	}
+	project, ok := projects[int(args.ID)]
+	if !ok {
+		return nil, nil
+	}
+	return &projectResolver{project: project}, nil
+}
+
+func (r *graphqlRoot) Goods(ctx context.Context, args struct {
+	ProjectID *int32
+	goodsArgs
+}) (*goodConnectionResolver, error) {
+	loader := loaderFromContext(ctx)
+	var projectIDs []int
+	if args.ProjectID != nil {
+		projectIDs = []int{int(*args.ProjectID)}
+	} else {
+		projects, err := loader.allProjects(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, project := range projects {
+			projectIDs = append(projectIDs, project.ID)
+		}
+	}
+	byProject, err := loader.goodsOf(ctx, projectIDs)
+	if err != nil {
+		return nil, err
+	}
+	var goods []Good
+	for _, list := range byProject {
+		goods = append(goods, list...)
+	}
+	sort.Slice(goods, func(i, j int) bool { return goods[i].ID < goods[j].ID })
+	return goodsPage(ctx, goods, args.goodsArgs)
+}
+
+func (r *graphqlRoot) Good(ctx context.Context, args struct{ ProjectID, ID int32 }) (*goodResolver, error) {
+	projectID := int(args.ProjectID)
+	if !visibleProject(ctx, projectID) {
+		return nil, nil
+	}
+	good, err := loaderFromContext(ctx).db.GetGood(projectID, int(args.ID))
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	if good == nil {
+		return nil, nil
+	}
+	return &goodResolver{good: *good}, nil
+}
+
+func (r *graphqlRoot) CreateGood(ctx context.Context, args struct {
+	ProjectID int32
+	Name      string
+	Fields    *GoodFields
+}) (*goodResolver, error) {
+	projectID := int(args.ProjectID)
+	if err := requireGrant(ctx, projectID, ScopeWrite); err != nil {
+		return nil, err
+	}
+	if args.Name == "" {
+		return nil, errGraphQLBadRequest
+	}
+	exists, err := r.h.store(ctx).CheckIfProjectExists(projectID)
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	if !exists {
+		return nil, errGraphQLNotFound
+	}
+	var fields GoodFields
+	if args.Fields != nil {
+		fields = *args.Fields
+	}
+	good, err := r.h.store(ctx).CreateGoods(projectID, args.Name, fields)
+	if errors.As(err, new(*FieldError)) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	loaderFromContext(ctx).invalidate()
+	audit(ctx, "good.create", projectID, good.ID)
+	return &goodResolver{good: *good}, nil
+}
+
+func (r *graphqlRoot) UpdateGood(ctx context.Context, args struct {
+	ProjectID   int32
+	ID          int32
+	Name        string
+	Description string
+	Fields      *GoodFields
+}) (*goodResolver, error) {
+	projectID, id := int(args.ProjectID), int(args.ID)
+	if err := requireGrant(ctx, projectID, ScopeWrite); err != nil {
+		return nil, err
+	}
+	if args.Name == "" {
+		return nil, errGraphQLBadRequest
+	}
+	exists, err := r.h.store(ctx).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	if !exists {
+		return nil, errGraphQLNotFound
+	}
+	var fields GoodFields
+	if args.Fields != nil {
+		fields = *args.Fields
+	}
+	good, err := r.h.store(ctx).UpdateGoods(projectID, id, args.Name, args.Description, fields)
+	if errors.As(err, new(*FieldError)) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	loaderFromContext(ctx).invalidate()
+	audit(ctx, "good.update", projectID, id)
+	return &goodResolver{good: *good}, nil
+}
+
+func (r *graphqlRoot) RemoveGood(ctx context.Context, args struct{ ProjectID, ID int32 }) (bool, error) {
+	projectID, id := int(args.ProjectID), int(args.ID)
+	if err := requireGrant(ctx, projectID, ScopeWrite); err != nil {
+		return false, err
+	}
+	exists, err := r.h.store(ctx).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		return false, graphqlInternal(err)
+	}
+	if !exists {
+		return false, errGraphQLNotFound
+	}
+	if err := r.h.store(ctx).DeleteGoods(projectID, id); err != nil {
+		return false, graphqlInternal(err)
+	}
+	loaderFromContext(ctx).invalidate()
+	audit(ctx, "good.remove", projectID, id)
+	return true, nil
+}
+
+// CreateProject - как и в HTTP API, нужен admin во всех проектах.
+func (r *graphqlRoot) CreateProject(ctx context.Context, args struct{ Name string }) (*projectResolver, error) {
+	if err := requireGrant(ctx, allProjects, ScopeAdmin); err != nil {
+		return nil, err
+	}
+	if args.Name == "" {
+		return nil, errGraphQLBadRequest
+	}
+	project, err := r.h.store(ctx).CreateProject(args.Name)
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	loaderFromContext(ctx).invalidate()
+	audit(ctx, "project.create", project.ID, project.ID)
+	return &projectResolver{project: *project}, nil
+}
+
+func (r *graphqlRoot) UpdateProject(ctx context.Context, args struct {
+	ID   int32
+	Name string
+}) (*projectResolver, error) {
+	projectID := int(args.ID)
+	if err := requireGrant(ctx, projectID, ScopeAdmin); err != nil {
+		return nil, err
+	}
+	if args.Name == "" {
+		return nil, errGraphQLBadRequest
+	}
+	exists, err := r.h.store(ctx).CheckIfProjectExists(projectID)
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	if !exists {
+		return nil, errGraphQLNotFound
+	}
+	project, err := r.h.store(ctx).UpdateProject(projectID, args.Name)
+	if err != nil {
+		return nil, graphqlInternal(err)
+	}
+	loaderFromContext(ctx).invalidate()
+	audit(ctx, "project.update", projectID, projectID)
+	return &projectResolver{project: *project}, nil
+}
+
+func (r *graphqlRoot) RemoveProject(ctx context.Context, args struct{ ID int32 }) (bool, error) {
+	projectID := int(args.ID)
+	if err := requireGrant(ctx, projectID, ScopeAdmin); err != nil {
+		return false, err
+	}
+	exists, err := r.h.store(ctx).CheckIfProjectExists(projectID)
+	if err != nil {
+		return false, graphqlInternal(err)
+	}
+	if !exists {
+		return false, errGraphQLNotFound
+	}
+	err = r.h.store(ctx).DeleteProject(projectID)
+	if err == ErrProjectHasGoods {
+		return false, errors.New("Project has goods")
+	}
+	if err != nil {
+		return false, graphqlInternal(err)
+	}
+	loaderFromContext(ctx).invalidate()
+	audit(ctx, "project.remove", projectID, projectID)
+	return true, nil
+}
+
+type projectResolver struct {
+	project Project
+}
+
+func (r *projectResolver) ID() int32         { return int32(r.project.ID) }
+func (r *projectResolver) Name() string      { return r.project.Name }
+func (r *projectResolver) CreatedAt() string { return r.project.CreatedAt }
+
+func (r *projectResolver) Goods(ctx context.Context, args goodsArgs) (*goodConnectionResolver, error) {
+	byProject, err := loaderFromContext(ctx).goodsOf(ctx, []int{r.project.ID})
+	if err != nil {
+		return nil, err
+	}
+	return goodsPage(ctx, byProject[r.project.ID], args)
+}
+
+type goodResolver struct {
+	good Good
+}
+
+func (r *goodResolver) ID() int32           { return int32(r.good.ID) }
+func (r *goodResolver) ProjectID() int32    { return int32(r.good.ProjectID) }
+func (r *goodResolver) Name() string        { return r.good.Name }
+func (r *goodResolver) Description() string { return r.good.Description }
+func (r *goodResolver) Priority() int32     { return int32(r.good.Priority) }
+func (r *goodResolver) Removed() bool       { return r.good.Removed }
+func (r *goodResolver) CreatedAt() string   { return r.good.CreatedAt }
+func (r *goodResolver) Tags() []string      { return r.good.Tags }
+func (r *goodResolver) Fields() GoodFields  { return r.good.Fields }
+
+func (r *goodResolver) Project(ctx context.Context) (*projectResolver, error) {
+	projects, err := loaderFromContext(ctx).projectsOf(ctx, []int{r.good.ProjectID})
+	if err != nil {
+		return nil, err
+	}
+	project, ok := projects[r.good.ProjectID]
+	if !ok {
+		return nil, nil
+	}
+	return &projectResolver{project: project}, nil
+}
+
+type goodConnectionResolver struct {
+	goods []Good
+	total int
+	more  bool
+}
+
+func (r *goodConnectionResolver) TotalCount() int32 { return int32(r.total) }
+func (r *goodConnectionResolver) HasNextPage() bool { return r.more }
+
+func (r *goodConnectionResolver) Nodes() []*goodResolver {
+	nodes := make([]*goodResolver, len(r.goods))
+	for i, good := range r.goods {
+		nodes[i] = &goodResolver{good: good}
+	}
+	return nodes
+}
+
+type projectConnectionResolver struct {
+	projects []Project
+	total    int
+	more     bool
+}
+
+func (r *projectConnectionResolver) TotalCount() int32 { return int32(r.total) }
+func (r *projectConnectionResolver) HasNextPage() bool { return r.more }
+
+func (r *projectConnectionResolver) Nodes() []*projectResolver {
+	nodes := make([]*projectResolver, len(r.projects))
+	for i, project := range r.projects {
+		nodes[i] = &projectResolver{project: project}
+	}
+	return nodes
+}
+
+// GraphQL - POST /graphql {"query": "...", "operationName": "...", "variables": {...}}
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithValue(r.Context(), graphqlLoaderKey{}, newGraphQLLoader(h.store(r.Context())))
+	response := h.graphql.Exec(ctx, request.Query, request.OperationName, request.Variables)
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,253 @@
+package gotest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingDB - memoryHandler, считающий запросы чтения товаров и проектов.
+type countingDB struct {
+	*memoryHandler
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newCountingDB() *countingDB {
+	return &countingDB{memoryHandler: newMemoryHandler(), calls: map[string]int{}}
+}
+
+func (c *countingDB) count(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[method]++
+}
+
+func (c *countingDB) ForTenant(tenantID int) DBHandler { return c }
+func (c *countingDB) ReadFromReplicas() DBHandler      { return c }
+
+func (c *countingDB) GetGoods() ([]Good, error) {
+	c.count("GetGoods")
+	return c.memoryHandler.GetGoods()
+}
+
+func (c *countingDB) GetProjects() ([]Project, error) {
+	c.count("GetProjects")
+	return c.memoryHandler.GetProjects()
+}
+
+func (c *countingDB) GetGoodsByProjects(projectIDs []int) ([]Good, error) {
+	c.count("GetGoodsByProjects")
+	return c.memoryHandler.GetGoodsByProjects(projectIDs)
+}
+
+func (c *countingDB) GetProjectsByIDs(ids []int) ([]Project, error) {
+	c.count("GetProjectsByIDs")
+	return c.memoryHandler.GetProjectsByIDs(ids)
+}
+
+// graphqlResponse - ответ /graphql.
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// execGraphQL - выполняет запрос от имени principal (nil - без авторизации).
+func execGraphQL(t *testing.T, h *Handler, principal *Principal, query string) graphqlResponse {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	if principal != nil {
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+	rec := httptest.NewRecorder()
+	h.GraphQL(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var response graphqlResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// seedGraphQL - projects проектов по goodsPerProject товаров в каждом.
+func seedGraphQL(t *testing.T, db DBHandler, projects, goodsPerProject int) []*Project {
+	var created []*Project
+	for i := 0; i < projects; i++ {
+		project := mustCreateProject(t, db, "project")
+		for j := 0; j < goodsPerProject; j++ {
+			mustCreateGood(t, db, project.ID, "good")
+		}
+		created = append(created, project)
+	}
+	return created
+}
+
+func TestGraphQLProjectsWithGoodsAreBatched(t *testing.T) {
+	db := newCountingDB()
+	seedGraphQL(t, db, 5, 3)
+	h := NewHandler(db)
+
+	response := execGraphQL(t, h, nil, `{ projects { nodes { id goods { totalCount nodes { id projectId project { id } } } } } }`)
+	if len(response.Errors) > 0 {
+		t.Fatalf("errors: %+v", response.Errors)
+	}
+	var data struct {
+		Projects struct {
+			Nodes []struct {
+				ID    int
+				Goods struct {
+					TotalCount int
+					Nodes      []struct {
+						ID        int
+						ProjectID int
+						Project   struct{ ID int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(response.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Projects.Nodes) != 5 {
+		t.Fatalf("got %d projects, want 5", len(data.Projects.Nodes))
+	}
+	for _, project := range data.Projects.Nodes {
+		if project.Goods.TotalCount != 3 || len(project.Goods.Nodes) != 3 {
+			t.Errorf("project %d has %d goods, want 3", project.ID, project.Goods.TotalCount)
+		}
+		for _, good := range project.Goods.Nodes {
+			if good.ProjectID != project.ID || good.Project.ID != project.ID {
+				t.Errorf("good %d of project %d resolved to project %d", good.ID, project.ID, good.Project.ID)
+			}
+		}
+	}
+	want := map[string]int{"GetProjects": 1, "GetGoodsByProjects": 1}
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("queries = %v, want %v", db.calls, want)
+	}
+}
+
+func TestGraphQLGoodsLoadProjectsInOneQuery(t *testing.T) {
+	db := newCountingDB()
+	projects := seedGraphQL(t, db, 3, 2)
+	h := NewHandler(db)
+
+	response := execGraphQL(t, h, nil, `{ goods(projectId: `+strconv.Itoa(projects[1].ID)+`) { totalCount nodes { project { id name } } } }`)
+	if len(response.Errors) > 0 {
+		t.Fatalf("errors: %+v", response.Errors)
+	}
+	if !strings.Contains(string(response.Data), `"totalCount":2`) || strings.Count(string(response.Data), `"id":`+strconv.Itoa(projects[1].ID)) != 2 {
+		t.Errorf("data = %s", response.Data)
+	}
+	want := map[string]int{"GetGoodsByProjects": 1, "GetProjectsByIDs": 1}
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("queries = %v, want %v", db.calls, want)
+	}
+}
+
+func TestGraphQLHidesProjectsWithoutGrant(t *testing.T) {
+	db := newCountingDB()
+	projects := seedGraphQL(t, db, 2, 2)
+	h := NewHandler(db)
+	visible, hidden := projects[0], projects[1]
+	reader := &Principal{Actor: "test", grants: map[int][]string{visible.ID: {ScopeRead}}}
+
+	response := execGraphQL(t, h, reader, `{
+		projects { totalCount }
+		goods { totalCount nodes { projectId } }
+		project(id: `+strconv.Itoa(hidden.ID)+`) { id }
+		good(projectId: `+strconv.Itoa(hidden.ID)+`, id: 3) { id }
+	}`)
+	if len(response.Errors) > 0 {
+		t.Fatalf("errors: %+v", response.Errors)
+	}
+	var data struct {
+		Projects struct{ TotalCount int }
+		Goods    struct {
+			TotalCount int
+			Nodes      []struct{ ProjectID int }
+		}
+		Project *struct{ ID int }
+		Good    *struct{ ID int }
+	}
+	if err := json.Unmarshal(response.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Projects.TotalCount != 1 || data.Goods.TotalCount != 2 {
+		t.Errorf("visible: %d projects, %d goods; want 1 and 2", data.Projects.TotalCount, data.Goods.TotalCount)
+	}
+	for _, good := range data.Goods.Nodes {
+		if good.ProjectID != visible.ID {
+			t.Errorf("good of hidden project %d returned", good.ProjectID)
+		}
+	}
+	if data.Project != nil || data.Good != nil {
+		t.Errorf("hidden project or good returned: %+v, %+v", data.Project, data.Good)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	db := newCountingDB()
+	project := mustCreateProject(t, db, "mutations")
+	h := NewHandler(db)
+	writer := &Principal{Actor: "writer", grants: map[int][]string{project.ID: {ScopeWrite}}}
+	reader := &Principal{Actor: "reader", grants: map[int][]string{project.ID: {ScopeRead}}}
+
+	// Созданный мутацией товар сохраняется в хранилище
+	response := execGraphQL(t, h, writer, `mutation {
+		createGood(projectId: `+strconv.Itoa(project.ID)+`, name: "new") { id name priority }
+	}`)
+	if len(response.Errors) > 0 {
+		t.Fatalf("createGood errors: %+v", response.Errors)
+	}
+	var created struct {
+		CreateGood struct {
+			ID       int
+			Name     string
+			Priority int
+		}
+	}
+	json.Unmarshal(response.Data, &created)
+	if created.CreateGood.Name != "new" || created.CreateGood.Priority != 1 {
+		t.Errorf("createGood = %+v", created.CreateGood)
+	}
+	if got, _ := db.GetGood(project.ID, created.CreateGood.ID); got == nil {
+		t.Error("created good is not stored")
+	}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		query     string
+		want      string
+	}{
+		{"read-only create", reader, `mutation { createGood(projectId: ` + strconv.Itoa(project.ID) + `, name: "x") { id } }`, "Forbidden"},
+		{"empty name", writer, `mutation { createGood(projectId: ` + strconv.Itoa(project.ID) + `, name: "") { id } }`, "Bad request"},
+		{"missing good", writer, `mutation { removeGood(projectId: ` + strconv.Itoa(project.ID) + `, id: 999) }`, "Not found"},
+		{"project without admin", writer, `mutation { createProject(name: "x") { id } }`, "Forbidden"},
+		{"page too large", reader, `{ goods(first: 501) { totalCount } }`, "Bad request"},
+	}
+	for _, tt := range tests {
+		response := execGraphQL(t, h, tt.principal, tt.query)
+		if len(response.Errors) != 1 || response.Errors[0].Message != tt.want {
+			t.Errorf("%s: errors = %+v, want %q", tt.name, response.Errors, tt.want)
+		}
+	}
+
+	response = execGraphQL(t, h, writer, `mutation { removeGood(projectId: `+strconv.Itoa(project.ID)+`, id: `+strconv.Itoa(created.CreateGood.ID)+`) }`)
+	if len(response.Errors) > 0 || string(response.Data) != `{"removeGood":true}` {
+		t.Errorf("removeGood = %s, %+v", response.Data, response.Errors)
+	}
+}
@@ -0,0 +1,398 @@
+// grpc.go
+package gotest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"gotest/api/goodspb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcScopes - право, необходимое для каждого метода, как у соответствующего HTTP-маршрута.
+// Методы, которых нет в списке, запрещены.
+var grpcScopes = map[string]string{
+	goodspb.GoodsService_GetGood_FullMethodName:       ScopeRead,
+	goodspb.GoodsService_ListGoods_FullMethodName:     ScopeRead,
+	goodspb.GoodsService_CreateGood_FullMethodName:    ScopeWrite,
+	goodspb.GoodsService_UpdateGood_FullMethodName:    ScopeWrite,
+	goodspb.GoodsService_RemoveGood_FullMethodName:    ScopeWrite,
+	goodspb.GoodsService_GetProject_FullMethodName:    ScopeRead,
+	goodspb.GoodsService_ListProjects_FullMethodName:  ScopeRead,
+	goodspb.GoodsService_CreateProject_FullMethodName: ScopeAdmin,
+	goodspb.GoodsService_UpdateProject_FullMethodName: ScopeAdmin,
+	goodspb.GoodsService_RemoveProject_FullMethodName: ScopeAdmin,
+	goodspb.GoodsService_WatchGoods_FullMethodName:    ScopeRead,
+}
+
+// grpcCodes - соответствие HTTP-статусов кодам gRPC, чтобы клиенты обоих API
+// получали одинаковые ошибки в одинаковых ситуациях.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// grpcError - ошибка gRPC, соответствующая HTTP-статусу httpStatus.
+func grpcError(httpStatus int, message string) error {
+	code, ok := grpcCodes[httpStatus]
+	if !ok {
+		code = codes.Unknown
+	}
+	return status.Error(code, message)
+}
+
+// grpcInternal - пишет ошибку в лог и, как и HTTP API, не отдает ее клиенту.
+func grpcInternal(err error) error {
+	log.Println(err)
+	return grpcError(http.StatusInternalServerError, "Internal server error")
+}
+
+// GRPCServer - реализация GoodsService поверх того же DBHandler, что и HTTP API.
+type GRPCServer struct {
+	goodspb.UnimplementedGoodsServiceServer
+	h *Handler
+}
+
+// NewGRPCServer - gRPC-сервер с GoodsService и теми же проверками доступа, что у HTTP API.
+// limiter ограничивает частоту унарных вызовов, nil - без ограничений.
+func NewGRPCServer(h *Handler, limiter *RateLimiter) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{h.unaryAuthInterceptor}
+	if limiter != nil {
+		unary = append(unary, limiter.UnaryServerInterceptor)
+	}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.StreamInterceptor(h.streamAuthInterceptor),
+	)
+	goodspb.RegisterGoodsServiceServer(server, &GRPCServer{h: h})
+	return server
+}
+
+// grpcPrincipal - аналог authenticate для метаданных gRPC: x-api-key или authorization,
+// мастер-ключ выбирает организацию через x-tenant-id.
+func (h *Handler) grpcPrincipal(ctx context.Context, method string, req interface{}) (*Principal, error) {
+	scope, ok := grpcScopes[method]
+	if !ok {
+		return nil, grpcError(http.StatusForbidden, "Forbidden")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	principal, err := h.authenticateCredentials(first("x-api-key"), first("authorization"), first("x-tenant-id"))
+	if err == errUnauthenticated {
+		return nil, grpcError(http.StatusUnauthorized, "Unauthorized")
+	}
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+
+	var projectIDs []int
+	if r, ok := req.(interface{ GetProjectId() int64 }); ok && r.GetProjectId() != 0 {
+		projectIDs = append(projectIDs, int(r.GetProjectId()))
+	}
+	if !principal.allowsAll(projectIDs, scope) {
+		return nil, grpcError(http.StatusForbidden, "Forbidden")
+	}
+	return principal, nil
+}
+
+func (h *Handler) unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	principal, err := h.grpcPrincipal(ctx, info.FullMethod, req)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+	if grpcScopes[info.FullMethod] == ScopeRead {
+		ctx = withReadOnly(ctx)
+	}
+	return handler(ctx, req)
+}
+
+// principalStream - поток с контекстом, в котором сохранен субъект.
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
+
+// streamAuthInterceptor - сообщение запроса потоку еще не прочитано, поэтому здесь
+// проверяется только наличие права хотя бы в одном проекте, а проект проверяет сам метод.
+func (h *Handler) streamAuthInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	principal, err := h.grpcPrincipal(stream.Context(), info.FullMethod, nil)
+	if err != nil {
+		return err
+	}
+	ctx := context.WithValue(stream.Context(), principalKey{}, principal)
+	return handler(srv, &principalStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcFields - значения полей из запроса; nil, если поле fields не задано.
+func grpcFields(fields *structpb.Struct) GoodFields {
+	if fields == nil {
+		return nil
+	}
+	return fields.AsMap()
+}
+
+func toGoodPB(good Good) *goodspb.Good {
+	// Значения полей прошли validateFields, поэтому в Struct они переводятся без ошибок
+	fields, _ := structpb.NewStruct(good.Fields)
+	return &goodspb.Good{
+		Id:          int64(good.ID),
+		ProjectId:   int64(good.ProjectID),
+		Name:        good.Name,
+		Description: good.Description,
+		Priority:    int64(good.Priority),
+		Removed:     good.Removed,
+		CreatedAt:   good.CreatedAt,
+		Tags:        good.Tags,
+		Fields:      fields,
+	}
+}
+
+func toProjectPB(project Project) *goodspb.Project {
+	return &goodspb.Project{
+		Id:        int64(project.ID),
+		Name:      project.Name,
+		CreatedAt: project.CreatedAt,
+	}
+}
+
+var goodEventTypes = map[string]goodspb.GoodEvent_Type{
+	GoodCreated: goodspb.GoodEvent_TYPE_CREATED,
+	GoodUpdated: goodspb.GoodEvent_TYPE_UPDATED,
+	GoodRemoved: goodspb.GoodEvent_TYPE_REMOVED,
+}
+
+func (s *GRPCServer) GetGood(ctx context.Context, req *goodspb.GetGoodRequest) (*goodspb.Good, error) {
+	if req.ProjectId <= 0 || req.Id <= 0 {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	good, err := s.h.store(ctx).GetGood(int(req.ProjectId), int(req.Id))
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if good == nil {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	return toGoodPB(*good), nil
+}
+
+func (s *GRPCServer) ListGoods(ctx context.Context, req *goodspb.ListGoodsRequest) (*goodspb.ListGoodsResponse, error) {
+	goods, err := s.h.store(ctx).GetGoods()
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	response := &goodspb.ListGoodsResponse{}
+	for _, good := range goods {
+		if req.ProjectId != 0 && int64(good.ProjectID) != req.ProjectId {
+			continue
+		}
+		if visibleProject(ctx, good.ProjectID) {
+			response.Goods = append(response.Goods, toGoodPB(good))
+		}
+	}
+	return response, nil
+}
+
+func (s *GRPCServer) CreateGood(ctx context.Context, req *goodspb.CreateGoodRequest) (*goodspb.Good, error) {
+	if req.ProjectId <= 0 || req.Name == "" {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	projectID := int(req.ProjectId)
+	exists, err := s.h.store(ctx).CheckIfProjectExists(projectID)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if !exists {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	good, err := s.h.store(ctx).CreateGoods(projectID, req.Name, grpcFields(req.Fields))
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		return nil, grpcError(http.StatusBadRequest, fieldErr.Error())
+	}
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	audit(ctx, "good.create", projectID, good.ID)
+	return toGoodPB(*good), nil
+}
+
+func (s *GRPCServer) UpdateGood(ctx context.Context, req *goodspb.UpdateGoodRequest) (*goodspb.Good, error) {
+	if req.ProjectId <= 0 || req.Id <= 0 || req.Name == "" {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	projectID, id := int(req.ProjectId), int(req.Id)
+	exists, err := s.h.store(ctx).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if !exists {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	good, err := s.h.store(ctx).UpdateGoods(projectID, id, req.Name, req.Description, grpcFields(req.Fields))
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		return nil, grpcError(http.StatusBadRequest, fieldErr.Error())
+	}
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	audit(ctx, "good.update", projectID, id)
+	return toGoodPB(*good), nil
+}
+
+func (s *GRPCServer) RemoveGood(ctx context.Context, req *goodspb.RemoveGoodRequest) (*goodspb.RemoveGoodResponse, error) {
+	if req.ProjectId <= 0 || req.Id <= 0 {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	projectID, id := int(req.ProjectId), int(req.Id)
+	exists, err := s.h.store(ctx).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if !exists {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	if err := s.h.store(ctx).DeleteGoods(projectID, id); err != nil {
+		return nil, grpcInternal(err)
+	}
+	audit(ctx, "good.remove", projectID, id)
+	return &goodspb.RemoveGoodResponse{ProjectId: req.ProjectId, Id: req.Id, Removed: true}, nil
+}
+
+func (s *GRPCServer) GetProject(ctx context.Context, req *goodspb.GetProjectRequest) (*goodspb.Project, error) {
+	if req.ProjectId <= 0 {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	project, err := s.h.store(ctx).GetProject(int(req.ProjectId))
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if project == nil {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	return toProjectPB(*project), nil
+}
+
+func (s *GRPCServer) ListProjects(ctx context.Context, req *goodspb.ListProjectsRequest) (*goodspb.ListProjectsResponse, error) {
+	projects, err := s.h.store(ctx).GetProjects()
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	response := &goodspb.ListProjectsResponse{}
+	for _, project := range projects {
+		if visibleProject(ctx, project.ID) {
+			response.Projects = append(response.Projects, toProjectPB(project))
+		}
+	}
+	return response, nil
+}
+
+// CreateProject - как и в HTTP API, нужен admin во всех проектах.
+func (s *GRPCServer) CreateProject(ctx context.Context, req *goodspb.CreateProjectRequest) (*goodspb.Project, error) {
+	if p := PrincipalFromContext(ctx); p != nil && !p.Allows(allProjects, ScopeAdmin) {
+		return nil, grpcError(http.StatusForbidden, "Forbidden")
+	}
+	if req.Name == "" {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	project, err := s.h.store(ctx).CreateProject(req.Name)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	audit(ctx, "project.create", project.ID, project.ID)
+	return toProjectPB(*project), nil
+}
+
+func (s *GRPCServer) UpdateProject(ctx context.Context, req *goodspb.UpdateProjectRequest) (*goodspb.Project, error) {
+	if req.ProjectId <= 0 || req.Name == "" {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	projectID := int(req.ProjectId)
+	exists, err := s.h.store(ctx).CheckIfProjectExists(projectID)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if !exists {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	project, err := s.h.store(ctx).UpdateProject(projectID, req.Name)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	audit(ctx, "project.update", projectID, projectID)
+	return toProjectPB(*project), nil
+}
+
+func (s *GRPCServer) RemoveProject(ctx context.Context, req *goodspb.RemoveProjectRequest) (*goodspb.RemoveProjectResponse, error) {
+	if req.ProjectId <= 0 {
+		return nil, grpcError(http.StatusBadRequest, "Bad request")
+	}
+	projectID := int(req.ProjectId)
+	exists, err := s.h.store(ctx).CheckIfProjectExists(projectID)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	if !exists {
+		return nil, grpcError(http.StatusNotFound, "Not found")
+	}
+	err = s.h.store(ctx).DeleteProject(projectID)
+	if err == ErrProjectHasGoods {
+		return nil, grpcError(http.StatusConflict, "Project has goods")
+	}
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	audit(ctx, "project.remove", projectID, projectID)
+	return &goodspb.RemoveProjectResponse{ProjectId: req.ProjectId, Removed: true}, nil
+}
+
+// WatchGoods - отправляет изменения товаров, пока клиент не закроет поток.
+// События проектов, недоступных вызывающему, пропускаются.
+func (s *GRPCServer) WatchGoods(req *goodspb.WatchGoodsRequest, stream goodspb.GoodsService_WatchGoodsServer) error {
+	ctx := stream.Context()
+	if req.ProjectId < 0 {
+		return grpcError(http.StatusBadRequest, "Bad request")
+	}
+	if req.ProjectId != 0 && !visibleProject(ctx, int(req.ProjectId)) {
+		return grpcError(http.StatusForbidden, "Forbidden")
+	}
+	events, err := s.h.store(ctx).SubscribeGoodEvents(ctx)
+	if err != nil {
+		return grpcInternal(err)
+	}
+	for event := range events {
+		if req.ProjectId != 0 && int64(event.Good.ProjectID) != req.ProjectId {
+			continue
+		}
+		if !visibleProject(ctx, event.Good.ProjectID) {
+			continue
+		}
+		err := stream.Send(&goodspb.GoodEvent{Type: goodEventTypes[event.Type], Good: toGoodPB(event.Good)})
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
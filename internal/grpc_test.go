@@ -0,0 +1,312 @@
+package gotest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gotest/api/goodspb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcStore - memoryHandler с API-ключами: "reader" читает проект 1, "writer" пишет в него.
+type grpcStore struct {
+	*memoryHandler
+}
+
+func (s *grpcStore) ForTenant(tenantID int) DBHandler { return s }
+func (s *grpcStore) ReadFromReplicas() DBHandler      { return s }
+
+func (s *grpcStore) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	switch keyHash {
+	case hashAPIKey("reader"):
+		return &APIKey{ID: 1, TenantID: 1, ProjectID: 1, Scopes: []string{ScopeRead}}, nil
+	case hashAPIKey("writer"):
+		return &APIKey{ID: 2, TenantID: 1, ProjectID: 1, Scopes: []string{ScopeWrite}}, nil
+	}
+	return nil, nil
+}
+
+// newGRPCStore - проекты 1 и 2 с товарами 3 (в проекте 1) и 4 (в проекте 2).
+func newGRPCStore(t *testing.T) *grpcStore {
+	s := &grpcStore{newMemoryHandler()}
+	first := mustCreateProject(t, s, "first")
+	second := mustCreateProject(t, s, "second")
+	mustCreateGood(t, s, first.ID, "first good")
+	mustCreateGood(t, s, second.ID, "second good")
+	return s
+}
+
+// dialGRPC - клиент GoodsService, подключенный к серверу в памяти через bufconn.
+func dialGRPC(t *testing.T, db DBHandler) goodspb.GoodsServiceClient {
+	return dialLimitedGRPC(t, db, nil)
+}
+
+// dialLimitedGRPC - dialGRPC с ограничением частоты вызовов limiter.
+func dialLimitedGRPC(t *testing.T, db DBHandler, limiter *RateLimiter) goodspb.GoodsServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(NewHandler(db), limiter)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return goodspb.NewGoodsServiceClient(conn)
+}
+
+// withKey - контекст вызова с API-ключом в метаданных; пустой ключ - без учетных данных.
+func withKey(key string) context.Context {
+	if key == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
+// TestGRPCMatchesHTTP - одни и те же запросы через HTTP и gRPC дают соответствующие
+// друг другу статусы (см. grpcCodes).
+func TestGRPCMatchesHTTP(t *testing.T) {
+	cases := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		body   string
+		call   func(goodspb.GoodsServiceClient, context.Context) error
+		want   int
+	}{
+		{
+			name: "no credentials", method: http.MethodPost, path: "/good/create",
+			body: `{"projectId":"1","name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.CreateGood(ctx, &goodspb.CreateGoodRequest{ProjectId: 1, Name: "x"})
+				return err
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown key", key: "nobody", method: http.MethodPost, path: "/good/create",
+			body: `{"projectId":"1","name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.CreateGood(ctx, &goodspb.CreateGoodRequest{ProjectId: 1, Name: "x"})
+				return err
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "read key writes", key: "reader", method: http.MethodPost, path: "/good/create",
+			body: `{"projectId":"1","name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.CreateGood(ctx, &goodspb.CreateGoodRequest{ProjectId: 1, Name: "x"})
+				return err
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "other project", key: "writer", method: http.MethodPatch, path: "/good/update",
+			body: `{"projectId":"2","id":"4","name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.UpdateGood(ctx, &goodspb.UpdateGoodRequest{ProjectId: 2, Id: 4, Name: "x"})
+				return err
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "project needs admin", key: "writer", method: http.MethodPost, path: "/project/create",
+			body: `{"name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.CreateProject(ctx, &goodspb.CreateProjectRequest{Name: "x"})
+				return err
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "empty name", key: "writer", method: http.MethodPost, path: "/good/create",
+			body: `{"projectId":"1","name":""}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.CreateGood(ctx, &goodspb.CreateGoodRequest{ProjectId: 1})
+				return err
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "missing good", key: "writer", method: http.MethodPatch, path: "/good/update",
+			body: `{"projectId":"1","id":"99","name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.UpdateGood(ctx, &goodspb.UpdateGoodRequest{ProjectId: 1, Id: 99, Name: "x"})
+				return err
+			},
+			want: http.StatusNotFound,
+		},
+		{
+			name: "remove missing good", key: "writer", method: http.MethodDelete, path: "/good/remove",
+			body: `{"projectId":"1","id":"99"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.RemoveGood(ctx, &goodspb.RemoveGoodRequest{ProjectId: 1, Id: 99})
+				return err
+			},
+			want: http.StatusNotFound,
+		},
+		{
+			name: "create", key: "writer", method: http.MethodPost, path: "/good/create",
+			body: `{"projectId":"1","name":"x"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.CreateGood(ctx, &goodspb.CreateGoodRequest{ProjectId: 1, Name: "x"})
+				return err
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "remove", key: "writer", method: http.MethodDelete, path: "/good/remove",
+			body: `{"projectId":"1","id":"3"}`,
+			call: func(c goodspb.GoodsServiceClient, ctx context.Context) error {
+				_, err := c.RemoveGood(ctx, &goodspb.RemoveGoodRequest{ProjectId: 1, Id: 3})
+				return err
+			},
+			want: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Каждый транспорт работает со своей копией данных: успешные вызовы их меняют
+			h := NewHandler(newGRPCStore(t))
+			var route Route
+			for _, r := range h.Routes() {
+				if r.Path == c.path {
+					route = r
+				}
+			}
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			if c.key != "" {
+				req.Header.Set("X-API-Key", c.key)
+			}
+			rec := httptest.NewRecorder()
+			h.RequireScope(route.Scope, route.Handler)(rec, req)
+			if rec.Code != c.want {
+				t.Errorf("HTTP status = %d, want %d", rec.Code, c.want)
+			}
+
+			wantCode := codes.OK
+			if c.want != http.StatusOK {
+				wantCode = grpcCodes[c.want]
+			}
+			err := c.call(dialGRPC(t, newGRPCStore(t)), withKey(c.key))
+			if got := status.Code(err); got != wantCode {
+				t.Errorf("gRPC code = %v (%v), want %v", got, err, wantCode)
+			}
+		})
+	}
+}
+
+func TestGRPCReads(t *testing.T) {
+	client := dialGRPC(t, newGRPCStore(t))
+	ctx := withKey("reader")
+
+	good, err := client.GetGood(ctx, &goodspb.GetGoodRequest{ProjectId: 1, Id: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if good.Id != 3 || good.ProjectId != 1 || good.Name != "first good" {
+		t.Errorf("GetGood = %v", good)
+	}
+
+	tests := []struct {
+		name string
+		req  *goodspb.GetGoodRequest
+		want codes.Code
+	}{
+		{"missing good", &goodspb.GetGoodRequest{ProjectId: 1, Id: 99}, codes.NotFound},
+		{"good of other project", &goodspb.GetGoodRequest{ProjectId: 1, Id: 4}, codes.NotFound},
+		{"project without grant", &goodspb.GetGoodRequest{ProjectId: 2, Id: 4}, codes.PermissionDenied},
+		{"no project", &goodspb.GetGoodRequest{Id: 3}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		if _, err := client.GetGood(ctx, tt.req); status.Code(err) != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Без project_id список ограничен проектами ключа
+	list, err := client.ListGoods(ctx, &goodspb.ListGoodsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Goods) != 1 || list.Goods[0].Id != 3 {
+		t.Errorf("ListGoods = %v, want only good 3", list.Goods)
+	}
+	projects, err := client.ListProjects(ctx, &goodspb.ListProjectsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects.Projects) != 1 || projects.Projects[0].Id != 1 {
+		t.Errorf("ListProjects = %v, want only project 1", projects.Projects)
+	}
+}
+
+func TestGRPCStreamRequiresCredentials(t *testing.T) {
+	client := dialGRPC(t, newGRPCStore(t))
+	stream, err := client.WatchGoods(context.Background(), &goodspb.WatchGoodsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("WatchGoods without credentials: err = %v, want %v", err, codes.Unauthenticated)
+	}
+}
+
+func TestGRPCRateLimitPerCallerAndMethod(t *testing.T) {
+	limiter, _ := newMemoryLimiter(map[string]RateLimit{
+		goodspb.GoodsService_GetGood_FullMethodName: {Rate: 0.5, Burst: 2},
+	})
+	client := dialLimitedGRPC(t, newGRPCStore(t), limiter)
+	req := &goodspb.GetGoodRequest{ProjectId: 1, Id: 3}
+
+	for i := 0; i < 2; i++ {
+		var header metadata.MD
+		if _, err := client.GetGood(withKey("reader"), req, grpc.Header(&header)); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		if got := header.Get("ratelimit-remaining"); len(got) != 1 || got[0] != strconv.Itoa(1-i) {
+			t.Errorf("call %d: ratelimit-remaining = %v, want %d", i+1, got, 1-i)
+		}
+	}
+	var header metadata.MD
+	_, err := client.GetGood(withKey("reader"), req, grpc.Header(&header))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("over the limit: err = %v, want %v", err, codes.ResourceExhausted)
+	}
+	if got := header.Get("retry-after"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("retry-after = %v, want 2", got)
+	}
+
+	// Другой субъект и другой метод считаются отдельно
+	if _, err := client.GetGood(withKey("writer"), req); err != nil {
+		t.Errorf("other caller: %v", err)
+	}
+	if _, err := client.ListGoods(withKey("reader"), &goodspb.ListGoodsRequest{}); err != nil {
+		t.Errorf("other method: %v", err)
+	}
+	// Без учетных данных вызов отклоняется до лимита
+	if _, err := client.GetGood(withKey(""), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("no credentials: err = %v, want %v", err, codes.Unauthenticated)
+	}
+}
@@ -4,32 +4,49 @@ package gotest
 import (
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+
+	"gotest/ui"
+
+	graphql "github.com/graph-gophers/graphql-go"
 )
 
 // Создаем структуру хендлера с полем db типа DBHandler
 type Handler struct {
-	db DBHandler
+	db           DBHandler
+	adminKeyHash string
+	jwt          *JWTVerifier
+	graphql      *graphql.Schema
+	templates    *Templates
 }
 
 // Изменяем конструктор для хендлера, чтобы он принимал объект базы данных и клиент Redis
 func NewHandler(db DBHandler) *Handler {
-	return &Handler{
+	h := &Handler{
 		db: db,
 	}
+	h.graphql = graphql.MustParseSchema(graphqlSchema, &graphqlRoot{h: h})
+	// Встроенные шаблоны проверены при сборке, ошибка здесь - ошибка в самих шаблонах
+	templates, err := LoadTemplates(ui.Files, false)
+	if err != nil {
+		panic(err)
+	}
+	h.templates = templates
+	return h
 }
 
 type Good struct {
-	ID          int    `json:"id"`
-	ProjectID   int    `json:"project_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Priority    int    `json:"priority"`
-	Removed     bool   `json:"removed"`
-	CreatedAt   string `json:"created_at"`
+	ID          int        `json:"id"`
+	ProjectID   int        `json:"project_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Priority    int        `json:"priority"`
+	Removed     bool       `json:"removed"`
+	CreatedAt   string     `json:"created_at"`
+	Tags        []string   `json:"tags"`
+	Fields      GoodFields `json:"fields"`
 }
 
 type Project struct {
@@ -38,34 +55,19 @@ type Project struct {
 	CreatedAt string `json:"created_at"`
 }
 
-func (h *Handler) Main(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	temp, err := template.ParseFiles("ui/templates/main.html")
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal server error", 500)
-		return
-	}
-	err = temp.Execute(w, nil)
-	if err != nil {
-
-		http.Error(w, "Internal server error", 500)
-		return
-	}
-}
-
-var JsonData struct {
+// goodRequest - тело запросов /good/create и /good/update; /good/update отвечает им же
+// с новыми priority и fields.
+type goodRequest struct {
 	Id          string `json:"id"`
 	ProjectID   string `json:"projectId"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Priority    int
+	Fields      GoodFields `json:"fields,omitempty"`
 }
 
-var JsonDataDeleted struct {
+// goodRemoveRequest - тело запроса /good/remove и ответ на него.
+type goodRemoveRequest struct {
 	Id        string `json:"id"`
 	ProjectID string `json:"projectId"`
 	Removed   bool
@@ -76,27 +78,28 @@ func (h *Handler) POST(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&JsonData); err != nil {
+	var data goodRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		log.Println(err.Error())
 		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
 		return
 	}
-	if JsonData.ProjectID == "" {
+	if data.ProjectID == "" {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	projectId := JsonData.ProjectID
+	projectId := data.ProjectID
 	idNum, err := strconv.Atoi(projectId)
 	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	name := JsonData.Name
+	name := data.Name
 	if name == "" {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	booelan, err := h.db.CheckIfProjectExists(idNum)
+	booelan, err := h.store(r.Context()).CheckIfProjectExists(idNum)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", 500)
@@ -107,7 +110,16 @@ func (h *Handler) POST(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	good, err := h.db.CreateGoods(idNum, name)
+	good, err := h.store(r.Context()).CreateGoods(idNum, name, data.Fields)
+	if writeFieldError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "good.create", idNum, good.ID)
 	responseJSON, err := json.Marshal(good)
 	if err != nil {
 		log.Println(err)
@@ -127,26 +139,27 @@ func (h *Handler) PATCH(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&JsonData); err != nil {
+	var data goodRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		log.Println(err.Error())
 		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
 		return
 	}
-	if JsonData.Name == "" {
+	if data.Name == "" {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	projectIdNum, err := strconv.Atoi(JsonData.ProjectID)
+	projectIdNum, err := strconv.Atoi(data.ProjectID)
 	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	idNum, err := strconv.Atoi(JsonData.Id)
+	idNum, err := strconv.Atoi(data.Id)
 	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	boolean, err := h.db.CheckIfGoodExists(idNum, projectIdNum)
+	boolean, err := h.store(r.Context()).CheckIfGoodExists(idNum, projectIdNum)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", 500)
@@ -157,15 +170,20 @@ func (h *Handler) PATCH(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	goods, err := h.db.UpdateGoods(projectIdNum, idNum, JsonData.Name, JsonData.Description)
+	goods, err := h.store(r.Context()).UpdateGoods(projectIdNum, idNum, data.Name, data.Description, data.Fields)
+	if writeFieldError(w, err) {
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", 500)
 		return
 	}
-	JsonData.Priority = goods.Priority
+	audit(r.Context(), "good.update", projectIdNum, idNum)
+	data.Priority = goods.Priority
+	data.Fields = goods.Fields
 
-	responseJSON, err := json.Marshal(JsonData)
+	responseJSON, err := json.Marshal(data)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -185,22 +203,23 @@ func (h *Handler) DELETE(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&JsonDataDeleted); err != nil {
+	var data goodRemoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		log.Println(err.Error())
 		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
 		return
 	}
-	projectIdNum, err := strconv.Atoi(JsonDataDeleted.ProjectID)
+	projectIdNum, err := strconv.Atoi(data.ProjectID)
 	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	idNum, err := strconv.Atoi(JsonDataDeleted.Id)
+	idNum, err := strconv.Atoi(data.Id)
 	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	boolean, err := h.db.CheckIfGoodExists(idNum, projectIdNum)
+	boolean, err := h.store(r.Context()).CheckIfGoodExists(idNum, projectIdNum)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", 500)
@@ -210,14 +229,15 @@ func (h *Handler) DELETE(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	err = h.db.DeleteGoods(projectIdNum, idNum)
+	err = h.store(r.Context()).DeleteGoods(projectIdNum, idNum)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", 500)
 		return
 	}
-	JsonDataDeleted.Removed = true
-	responseJSON, err := json.Marshal(JsonDataDeleted)
+	audit(r.Context(), "good.remove", projectIdNum, idNum)
+	data.Removed = true
+	responseJSON, err := json.Marshal(data)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -235,21 +255,41 @@ func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	goods, err := h.db.GetGoods()
+	tagFilter, err := parseGoodTagFilter(r)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	fieldFilter := parseGoodFieldFilter(r)
+	goods, err := h.store(r.Context()).GetGoods()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	projects, err := h.db.GetProjects()
+	projects, err := h.store(r.Context()).GetProjects()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Оставляем только проекты, доступные вызывающему, и товары с нужными тегами и полями
+	visibleGoods := []Good{}
+	for _, good := range goods {
+		if visibleProject(r.Context(), good.ProjectID) && tagFilter.matches(good) && fieldFilter.matches(good) {
+			visibleGoods = append(visibleGoods, good)
+		}
+	}
+	visibleProjects := []Project{}
+	for _, project := range projects {
+		if visibleProject(r.Context(), project.ID) {
+			visibleProjects = append(visibleProjects, project)
+		}
+	}
+
 	data := map[string]interface{}{
-		"goods":    goods,
-		"projects": projects,
+		"goods":    visibleGoods,
+		"projects": visibleProjects,
 	}
 
 	// Преобразование данных в JSON
@@ -265,3 +305,18 @@ func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
 	// Возвращение данных в виде JSON
 	fmt.Fprintf(w, "%s\n", JsonData)
 }
+
+// writeJSON - отправляет value в виде JSON с указанным статусом.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	responseJSON, err := json.Marshal(value)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Установка заголовка Content-Type на application/json
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
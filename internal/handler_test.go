@@ -0,0 +1,46 @@
+package gotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCreateGoodRequestsDoNotShareFields(t *testing.T) {
+	db := newMemoryHandler()
+	project, _ := db.CreateProject("fields")
+	h := NewHandler(db)
+
+	const requests = 32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"projectId":"%d","name":"good %d","fields":{"n%d":%d}}`, project.ID, i, i, i)
+			rec := httptest.NewRecorder()
+			h.POST(rec, httptest.NewRequest(http.MethodPost, "/good/create", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("request %d: status %d", i, rec.Code)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	goods, _ := db.GetGoods()
+	if len(goods) != requests {
+		t.Fatalf("created %d goods, want %d", len(goods), requests)
+	}
+	for _, good := range goods {
+		var i int
+		fmt.Sscanf(good.Name, "good %d", &i)
+		want, _ := json.Marshal(GoodFields{fmt.Sprintf("n%d", i): i})
+		if got, _ := json.Marshal(good.Fields); string(got) != string(want) {
+			t.Errorf("%s has fields %s, want %s", good.Name, got, want)
+		}
+	}
+}
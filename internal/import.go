@@ -0,0 +1,438 @@
+// import.go
+package gotest
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Поддерживаемые форматы импорта и экспорта.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// maxImportBody - наибольший размер файла импорта, принимаемого по HTTP.
+const maxImportBody = 32 << 20
+
+// ErrInvalidImport - файл импорта не удалось разобрать целиком.
+var ErrInvalidImport = errors.New("invalid import file")
+
+// importFields - поля товара, которые можно заполнить при импорте.
+var importFields = []string{"name", "description", "priority"}
+
+// ImportRow - одна валидная строка файла импорта.
+type ImportRow struct {
+	Line        int    `json:"line"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Priority    int    `json:"priority"`
+}
+
+// ImportError - ошибка валидации конкретной строки файла.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ImportDuplicate - строка, название которой уже встречается в файле или в проекте.
+type ImportDuplicate struct {
+	Line int    `json:"line"`
+	Name string `json:"name"`
+	// Existing - true, если товар с таким названием уже есть в проекте,
+	// false - если повтор внутри самого файла.
+	Existing bool `json:"existing"`
+}
+
+// ImportReport - отчет об импорте (или о пробном прогоне).
+type ImportReport struct {
+	ProjectID  int               `json:"project_id"`
+	DryRun     bool              `json:"dry_run"`
+	Total      int               `json:"total"`
+	Valid      int               `json:"valid"`
+	Imported   int               `json:"imported"`
+	Errors     []ImportError     `json:"errors"`
+	Duplicates []ImportDuplicate `json:"duplicates"`
+}
+
+// ParseColumnMapping - разбирает сопоставление колонок вида "name:title,description:desc".
+// Слева поле товара, справа колонка (или ключ NDJSON) во входном файле.
+func ParseColumnMapping(s string) (map[string]string, error) {
+	mapping := make(map[string]string, len(importFields))
+	for _, field := range importFields {
+		mapping[field] = field
+	}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid column mapping %q", pair)
+		}
+		field := strings.TrimSpace(parts[0])
+		if _, ok := mapping[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q in column mapping", field)
+		}
+		mapping[field] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// ParseImport - читает CSV (с заголовком) или NDJSON и возвращает валидные строки
+// и ошибки валидации по строкам. Ошибка возвращается только если файл не читается целиком.
+func ParseImport(r io.Reader, format string, mapping map[string]string) ([]ImportRow, []ImportError, error) {
+	switch format {
+	case FormatCSV:
+		return parseImportCSV(r, mapping)
+	case FormatNDJSON:
+		return parseImportNDJSON(r, mapping)
+	}
+	return nil, nil, fmt.Errorf("unsupported import format %q", format)
+}
+
+func parseImportCSV(r io.Reader, mapping map[string]string) ([]ImportRow, []ImportError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	if _, ok := columns[mapping["name"]]; !ok {
+		return nil, nil, fmt.Errorf("csv header has no column %q for name", mapping["name"])
+	}
+
+	var rows []ImportRow
+	var errs []ImportError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Номер строки в файле, а не номер записи: значение в кавычках
+			// может занимать несколько строк
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, fmt.Errorf("error reading csv: %w", err)
+			}
+			errs = append(errs, ImportError{Line: parseErr.StartLine, Message: err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		values := make(map[string]string, len(importFields))
+		for _, field := range importFields {
+			if i, ok := columns[mapping[field]]; ok && i < len(record) {
+				values[field] = record[i]
+			}
+		}
+		row, err := validateImportRow(line, values)
+		if err != nil {
+			errs = append(errs, ImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+func parseImportNDJSON(r io.Reader, mapping map[string]string) ([]ImportRow, []ImportError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []ImportRow
+	var errs []ImportError
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &object); err != nil {
+			errs = append(errs, ImportError{Line: line, Message: "invalid json: " + err.Error()})
+			continue
+		}
+		values := make(map[string]string, len(importFields))
+		for _, field := range importFields {
+			switch v := object[mapping[field]].(type) {
+			case nil:
+			case string:
+				values[field] = v
+			case float64:
+				values[field] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				values[field] = fmt.Sprint(v)
+			}
+		}
+		row, err := validateImportRow(line, values)
+		if err != nil {
+			errs = append(errs, ImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading ndjson: %w", err)
+	}
+	return rows, errs, nil
+}
+
+// validateImportRow - проверяет значения строки по тем же правилам, что и POST /good/create.
+func validateImportRow(line int, values map[string]string) (ImportRow, error) {
+	row := ImportRow{
+		Line:        line,
+		Name:        strings.TrimSpace(values["name"]),
+		Description: values["description"],
+		Priority:    1,
+	}
+	if row.Name == "" {
+		return row, fmt.Errorf("name is required")
+	}
+	if len(row.Name) > 255 {
+		return row, fmt.Errorf("name is longer than 255 characters")
+	}
+	if p := strings.TrimSpace(values["priority"]); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil || priority < 1 {
+			return row, fmt.Errorf("priority must be a positive integer, got %q", p)
+		}
+		row.Priority = priority
+	}
+	return row, nil
+}
+
+// ImportGoods - загружает строки в таблицу goods одним COPY внутри транзакции.
+// Строки-дубликаты (по названию в рамках проекта) пропускаются и попадают в отчет.
+// При dryRun данные в базу не пишутся.
+func (s *SingletonDB) ImportGoods(projectID int, rows []ImportRow, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{
+		ProjectID:  projectID,
+		DryRun:     dryRun,
+		Errors:     []ImportError{},
+		Duplicates: []ImportDuplicate{},
+	}
+
+	// Файл импорта не несет пользовательских полей, поэтому при обязательных
+	// полях в схеме проекта ни одну строку импортировать нельзя
+	defs, err := s.GetFieldSchema(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFields(defs, GoodFields{}); err != nil {
+		for _, row := range rows {
+			report.Errors = append(report.Errors, ImportError{Line: row.Line, Message: err.Error()})
+		}
+		return report, nil
+	}
+
+	existing, err := s.fetchGoodNames(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching existing goods: %v", err)
+	}
+	seen := make(map[string]bool, len(rows))
+	var toInsert []ImportRow
+	for _, row := range rows {
+		key := strings.ToLower(row.Name)
+		if existing[key] {
+			report.Duplicates = append(report.Duplicates, ImportDuplicate{Line: row.Line, Name: row.Name, Existing: true})
+			continue
+		}
+		if seen[key] {
+			report.Duplicates = append(report.Duplicates, ImportDuplicate{Line: row.Line, Name: row.Name})
+			continue
+		}
+		seen[key] = true
+		toInsert = append(toInsert, row)
+	}
+	report.Valid = len(toInsert)
+	if dryRun || len(toInsert) == 0 {
+		return report, nil
+	}
+
+	// Начинаем транзакцию
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	// COPY FROM не работает для таблиц под RLS, поэтому строки передаются
+	// массивами и вставляются одним INSERT
+	names := make([]string, len(toInsert))
+	descriptions := make([]string, len(toInsert))
+	priorities := make([]int64, len(toInsert))
+	for i, row := range toInsert {
+		names[i], descriptions[i], priorities[i] = row.Name, row.Description, int64(row.Priority)
+	}
+	inserted, err := tx.Query(`
+	INSERT INTO goods (project_id, name, description, priority)
+	SELECT $1, name, description, priority FROM unnest($2::text[], $3::text[], $4::int[]) AS r(name, description, priority)
+	RETURNING id`,
+		projectID, pq.Array(names), pq.Array(descriptions), pq.Array(priorities))
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error inserting imported goods: %v", err)
+	}
+	ids, err := scanIDs(inserted)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error inserting imported goods: %v", err)
+	}
+	// Первые версии - только для вставленных товаров
+	_, err = tx.Exec(`
+	INSERT INTO goods_versions (good_id, version, name, description, priority, fields)
+	SELECT g.id, 1, g.name, g.description, g.priority, g.fields FROM goods g
+	WHERE g.id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error recording imported versions: %v", err)
+	}
+	if err := recordGoodEvents(tx, GoodCreated, ids); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Коммитим транзакцию
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	report.Imported = len(toInsert)
+
+	err = s.updateGoodsCache()
+	if err != nil {
+		fmt.Println("Error updating goods cache:", err)
+	}
+	fmt.Printf("Imported %d goods into project %d.\n", report.Imported, projectID)
+	return report, nil
+}
+
+// fetchGoodNames - названия товаров проекта в нижнем регистре.
+func (s *SingletonDB) fetchGoodNames(projectID int) (map[string]bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT name FROM goods WHERE project_id = $1", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names, rows.Err()
+}
+
+// RunImport - разбирает файл и импортирует его в проект. Если файл не разбирается,
+// возвращается ошибка, оборачивающая ErrInvalidImport.
+func RunImport(db DBHandler, projectID int, r io.Reader, format string, mapping map[string]string, dryRun bool) (*ImportReport, error) {
+	rows, errs, err := ParseImport(r, format, mapping)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	report, err := db.ImportGoods(projectID, rows, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	report.Total = len(rows) + len(errs)
+	if errs != nil {
+		report.Errors = append(errs, report.Errors...)
+	}
+	return report, nil
+}
+
+// Import - POST /good/import?project_id=1&format=csv|ndjson&map=name:title&dry_run=true
+// Тело запроса - сам файл.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	projectID, err := strconv.Atoi(query.Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = FormatCSV
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	mapping, err := ParseColumnMapping(query.Get("map"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+	exists, err := h.store(r.Context()).CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBody)
+	report, err := RunImport(h.store(r.Context()), projectID, body, format, mapping, dryRun)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, ErrInvalidImport) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !dryRun {
+		audit(r.Context(), "good.import", projectID, 0)
+	}
+	responseJSON, err := json.Marshal(report)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,225 @@
+package gotest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseColumnMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{"name": "name", "description": "description", "priority": "priority"}, false},
+		{"name:title", map[string]string{"name": "title", "description": "description", "priority": "priority"}, false},
+		{" name : title , priority:rank", map[string]string{"name": "title", "description": "description", "priority": "rank"}, false},
+		{"name", nil, true},
+		{"name:", nil, true},
+		{"price:cost", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColumnMapping(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseColumnMapping(%q): err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseColumnMapping(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	defaults, _ := ParseColumnMapping("")
+	renamed, _ := ParseColumnMapping("name:title,priority:rank")
+	tests := []struct {
+		name     string
+		format   string
+		mapping  map[string]string
+		input    string
+		wantRows []ImportRow
+		wantErrs []int
+		wantFail bool
+	}{
+		{
+			name:     "csv",
+			format:   FormatCSV,
+			mapping:  defaults,
+			input:    "name,description,priority\na,first,2\nb,,\n",
+			wantRows: []ImportRow{{Line: 2, Name: "a", Description: "first", Priority: 2}, {Line: 3, Name: "b", Priority: 1}},
+		},
+		{
+			name:     "csv mapped columns",
+			format:   FormatCSV,
+			mapping:  renamed,
+			input:    "rank,title\n5,a\n",
+			wantRows: []ImportRow{{Line: 2, Name: "a", Priority: 5}},
+		},
+		{
+			name:     "csv validation errors",
+			format:   FormatCSV,
+			mapping:  defaults,
+			input:    "name,priority\n,1\na,zero\nb,0\nc,3\n",
+			wantRows: []ImportRow{{Line: 5, Name: "c", Priority: 3}},
+			wantErrs: []int{2, 3, 4},
+		},
+		{
+			name:     "csv multiline value counts physical lines",
+			format:   FormatCSV,
+			mapping:  defaults,
+			input:    "name,description\na,\"one\ntwo\"\n,missing\nb,x\n",
+			wantRows: []ImportRow{{Line: 2, Name: "a", Description: "one\ntwo", Priority: 1}, {Line: 5, Name: "b", Description: "x", Priority: 1}},
+			wantErrs: []int{4},
+		},
+		{
+			name:     "csv malformed quote",
+			format:   FormatCSV,
+			mapping:  defaults,
+			input:    "name\na\n\"b\"x\nc\n",
+			wantRows: []ImportRow{{Line: 2, Name: "a", Priority: 1}, {Line: 4, Name: "c", Priority: 1}},
+			wantErrs: []int{3},
+		},
+		{
+			name:     "csv without name column",
+			format:   FormatCSV,
+			mapping:  defaults,
+			input:    "title\na\n",
+			wantFail: true,
+		},
+		{
+			name:    "csv empty",
+			format:  FormatCSV,
+			mapping: defaults,
+			input:   "",
+		},
+		{
+			name:     "ndjson",
+			format:   FormatNDJSON,
+			mapping:  renamed,
+			input:    "{\"title\":\"a\",\"rank\":3}\n\n{\"title\":\"b\",\"description\":\"x\"}\n",
+			wantRows: []ImportRow{{Line: 1, Name: "a", Priority: 3}, {Line: 3, Name: "b", Description: "x", Priority: 1}},
+		},
+		{
+			name:     "ndjson errors",
+			format:   FormatNDJSON,
+			mapping:  defaults,
+			input:    "{\"name\":\"a\"}\nnot json\n{\"priority\":2}\n{\"name\":\"b\",\"priority\":1.5}\n",
+			wantRows: []ImportRow{{Line: 1, Name: "a", Priority: 1}},
+			wantErrs: []int{2, 3, 4},
+		},
+		{
+			name:     "unknown format",
+			format:   "xml",
+			mapping:  defaults,
+			wantFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, errs, err := ParseImport(strings.NewReader(tt.input), tt.format, tt.mapping)
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("err = %v, wantFail %v", err, tt.wantFail)
+			}
+			if !reflect.DeepEqual(rows, tt.wantRows) {
+				t.Errorf("rows = %+v, want %+v", rows, tt.wantRows)
+			}
+			var lines []int
+			for _, e := range errs {
+				lines = append(lines, e.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantErrs) {
+				t.Errorf("error lines = %v, want %v (%+v)", lines, tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+// importTarget - проект, в который импортируются товары, без Postgres.
+type importTarget struct {
+	DBHandler
+	rows   []ImportRow
+	dryRun bool
+}
+
+func (d *importTarget) ForTenant(tenantID int) DBHandler {
+	return d
+}
+
+func (d *importTarget) CheckIfProjectExists(id int) (bool, error) {
+	return id == 1, nil
+}
+
+// ImportGoods - считает повтором строку с тем же названием, что у предыдущей.
+func (d *importTarget) ImportGoods(projectID int, rows []ImportRow, dryRun bool) (*ImportReport, error) {
+	d.rows, d.dryRun = rows, dryRun
+	report := &ImportReport{ProjectID: projectID, DryRun: dryRun, Errors: []ImportError{}, Duplicates: []ImportDuplicate{}}
+	for i, row := range rows {
+		if i > 0 && rows[i-1].Name == row.Name {
+			report.Duplicates = append(report.Duplicates, ImportDuplicate{Line: row.Line, Name: row.Name})
+			continue
+		}
+		report.Valid++
+	}
+	if !dryRun {
+		report.Imported = report.Valid
+	}
+	return report, nil
+}
+
+func TestImportDryRunReport(t *testing.T) {
+	db := &importTarget{}
+	h := NewHandler(db)
+	body := "name,priority\na,1\na,2\n,3\nb,x\nc,1\n"
+	rec := httptest.NewRecorder()
+	h.Import(rec, httptest.NewRequest(http.MethodPost, "/good/import?project_id=1&dry_run=true", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var report ImportReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if !db.dryRun || !report.DryRun {
+		t.Errorf("dry run was not passed through: db %v, report %v", db.dryRun, report.DryRun)
+	}
+	want := ImportReport{
+		ProjectID:  1,
+		DryRun:     true,
+		Total:      5,
+		Valid:      2,
+		Errors:     []ImportError{{Line: 4, Message: "name is required"}, {Line: 5, Message: `priority must be a positive integer, got "x"`}},
+		Duplicates: []ImportDuplicate{{Line: 3, Name: "a"}},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
+
+func TestImportRejectsUnreadableFile(t *testing.T) {
+	db := &importTarget{}
+	h := NewHandler(db)
+	rec := httptest.NewRecorder()
+	h.Import(rec, httptest.NewRequest(http.MethodPost, "/good/import?project_id=1", strings.NewReader("title\na\n")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	if _, err := RunImport(db, 1, strings.NewReader("title\na\n"), FormatCSV, nil, false); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("RunImport: err = %v, want %v", err, ErrInvalidImport)
+	}
+}
+
+func TestImportRejectsOversizedBody(t *testing.T) {
+	h := NewHandler(&importTarget{})
+	body := "name\n" + strings.Repeat("x", maxImportBody)
+	rec := httptest.NewRecorder()
+	h.Import(rec, httptest.NewRequest(http.MethodPost, "/good/import?project_id=1", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
@@ -0,0 +1,189 @@
+// jobhandlers.go
+package gotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Типы фоновых задач, кроме cacheRebuildJob.
+const (
+	exportGoodsJob   = "export.goods"
+	reindexSearchJob = "search.reindex"
+)
+
+// exportJobMaxBytes - предел размера выгрузки в фоне: результат хранится в Redis.
+// Для выгрузок больше лимита остается потоковый /good/export.
+const exportJobMaxBytes = 64 << 20
+
+var errExportTooLarge = errors.New("export exceeds size limit")
+
+// exportJobPayload - параметры выгрузки товаров в фоне.
+type exportJobPayload struct {
+	Format string       `json:"format"`
+	Filter ExportFilter `json:"filter"`
+}
+
+// RegisterJobHandlers - регистрирует обработчики задач приложения. Задача
+// выполняется от имени организации, поставившей ее в очередь.
+func RegisterJobHandlers(pool *WorkerPool, db DBHandler) {
+	pool.Handle(cacheRebuildJob, func(ctx context.Context, job *Job) (*JobResult, error) {
+		return nil, db.ForTenant(job.TenantID).RebuildGoodsCache()
+	})
+	pool.Handle(reindexSearchJob, func(ctx context.Context, job *Job) (*JobResult, error) {
+		return nil, db.ReindexSearch()
+	})
+	pool.Handle(exportGoodsJob, func(ctx context.Context, job *Job) (*JobResult, error) {
+		var payload exportJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("error decoding export job: %v", err)
+		}
+		var buf limitedBuffer
+		buf.limit = exportJobMaxBytes
+		ew := newExportWriter(&buf, payload.Format, goodsCSVHeader)
+		err := db.ForTenant(job.TenantID).ExportGoods(ctx, payload.Filter, func(good Good) error {
+			return ew.write(goodCSVRecord(good), good)
+		})
+		ew.flush()
+		if err == nil && buf.overflow {
+			err = errExportTooLarge
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &JobResult{ContentType: exportContentType(payload.Format), Body: buf.Bytes()}, nil
+	})
+}
+
+// limitedBuffer - буфер, отказывающий в записи сверх limit байт.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		b.overflow = true
+		return 0, errExportTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// enqueueJob - ставит задачу от имени субъекта запроса и отвечает 202 с задачей.
+func (h *Handler) enqueueJob(w http.ResponseWriter, r *http.Request, request JobRequest) {
+	request.TenantID = TenantFromContext(r.Context())
+	request.Actor = "anonymous"
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		request.Actor = p.Actor
+	}
+	job, err := h.db.Jobs().Enqueue(request)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "job.enqueue."+request.Type, request.ProjectID, int(job.ID))
+	w.Header().Set("Location", fmt.Sprintf("/job/get?id=%d", job.ID))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// findJob - задача из параметра id, если субъект ее видит: задача его организации,
+// и он поставил ее сам или может читать ее проект. Иначе отвечает 404, не раскрывая
+// чужие задачи.
+func (h *Handler) findJob(w http.ResponseWriter, r *http.Request) *Job {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+	job, err := h.db.Jobs().Get(id)
+	if err == ErrJobNotFound {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return nil
+	}
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		if job.TenantID != p.TenantID || (job.Actor != p.Actor && !p.Allows(job.ProjectID, ScopeRead)) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return nil
+		}
+	}
+	return job
+}
+
+// GetJob - GET /job/get?id=1
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job := h.findJob(w, r)
+	if job == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// JobResult - GET /job/result?id=1 - результат завершенной задачи, например файл выгрузки.
+// Пока задача не завершена, отвечает 409.
+func (h *Handler) JobResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job := h.findJob(w, r)
+	if job == nil {
+		return
+	}
+	if job.Status != JobSucceeded {
+		http.Error(w, "Job is "+job.Status, http.StatusConflict)
+		return
+	}
+	result, err := h.db.Jobs().Result(job.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if result == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", result.ContentType)
+	if job.Type == exportGoodsJob {
+		var payload exportJobPayload
+		if json.Unmarshal(job.Payload, &payload) == nil {
+			setExportHeaders(w, payload.Format, "goods")
+		}
+	}
+	w.Write(result.Body)
+}
+
+// ReindexSearch - POST /search/reindex - перестраивает поисковый индекс в фоне.
+// Индекс общий для всех организаций, поэтому запустить перестройку может только мастер-ключ.
+func (h *Handler) ReindexSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireSuperAdmin(w, r) {
+		return
+	}
+	h.enqueueJob(w, r, JobRequest{
+		Type:      reindexSearchJob,
+		Timeout:   30 * time.Minute,
+		UniqueKey: reindexSearchJob,
+	})
+}
@@ -0,0 +1,511 @@
+// jobs.go
+package gotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Состояния задачи. Задача, ожидающая повтора после ошибки, снова queued.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Ключи Redis очереди задач. Задача хранится в хеше jobs:job:<id>; ready - список
+// готовых к запуску ID, delayed - отложенные по времени запуска, running - взятые
+// воркерами по сроку таймаута видимости.
+const (
+	jobsSeqKey     = "jobs:seq"
+	jobsReadyKey   = "jobs:ready"
+	jobsDelayedKey = "jobs:delayed"
+	jobsRunningKey = "jobs:running"
+	jobKeyPrefix   = "jobs:job:"
+	jobResultKey   = "jobs:result:%d"
+	jobUniqueKey   = "jobs:unique:%s"
+)
+
+// jobTTL - сколько хранятся завершенные задачи и их результаты.
+const jobTTL = 24 * time.Hour
+
+// jobVisibilityMargin - запас таймаута видимости сверх Timeout задачи: воркер успевает
+// записать итог, прежде чем задачу посчитают потерянной и отдадут другому.
+const jobVisibilityMargin = 30 * time.Second
+
+// ErrJobNotFound - задачи нет или она уже удалена по сроку хранения.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job - фоновая задача. Attempts - сколько раз задача запускалась.
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	TenantID    int             `json:"tenant_id"`
+	ProjectID   int             `json:"project_id"`
+	Actor       string          `json:"actor"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Error       string          `json:"error"`
+	HasResult   bool            `json:"has_result"`
+	RunAt       string          `json:"run_at"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+	// timeout - сколько задаче дается на выполнение.
+	timeout time.Duration `json:"-"`
+}
+
+// JobRequest - новая задача. Нулевые MaxAttempts и Timeout заменяются значениями
+// по умолчанию (3 попытки, минута). Пока задача с тем же UniqueKey ждет запуска,
+// Enqueue возвращает ее вместо новой.
+type JobRequest struct {
+	Type        string
+	TenantID    int
+	ProjectID   int
+	Actor       string
+	Payload     interface{}
+	Delay       time.Duration
+	MaxAttempts int
+	Timeout     time.Duration
+	UniqueKey   string
+}
+
+// JobResult - результат задачи, который можно забрать через /job/result.
+type JobResult struct {
+	ContentType string
+	Body        []byte
+}
+
+// JobQueue - очередь задач на общем клиенте Redis. Задачи переживают перезапуск
+// приложения, а взятая воркером задача возвращается в очередь, если воркер
+// не отчитался до конца таймаута видимости.
+type JobQueue struct {
+	redis *redis.Client
+}
+
+// NewJobQueue - очередь на клиенте client.
+func NewJobQueue(client *redis.Client) *JobQueue {
+	return &JobQueue{redis: client}
+}
+
+func jobKey(id int64) string {
+	return jobKeyPrefix + strconv.FormatInt(id, 10)
+}
+
+func formatJobTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Enqueue - ставит задачу в очередь.
+func (q *JobQueue) Enqueue(request JobRequest) (*Job, error) {
+	payload, err := json.Marshal(request.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling job payload: %v", err)
+	}
+	if request.MaxAttempts <= 0 {
+		request.MaxAttempts = 3
+	}
+	if request.Timeout <= 0 {
+		request.Timeout = time.Minute
+	}
+	id, err := q.redis.Incr(jobsSeqKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error numbering job: %v", err)
+	}
+	if request.UniqueKey != "" {
+		key := fmt.Sprintf(jobUniqueKey, request.UniqueKey)
+		// Ключ живет не дольше задачи; его снимает воркер, взяв задачу
+		set, err := q.redis.SetNX(key, id, request.Delay+request.Timeout+jobVisibilityMargin).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error locking unique job: %v", err)
+		}
+		if !set {
+			existing, err := q.redis.Get(key).Int64()
+			if err == nil {
+				if job, err := q.Get(existing); err == nil {
+					return job, nil
+				}
+			}
+			// Ключ только что сняли - ставим задачу как обычную
+			q.redis.Set(key, id, request.Delay+request.Timeout+jobVisibilityMargin)
+		}
+	}
+
+	now := time.Now()
+	runAt := now.Add(request.Delay)
+	fields := map[string]interface{}{
+		"type":         request.Type,
+		"tenant_id":    request.TenantID,
+		"project_id":   request.ProjectID,
+		"actor":        request.Actor,
+		"payload":      string(payload),
+		"status":       JobQueued,
+		"attempts":     0,
+		"max_attempts": request.MaxAttempts,
+		"timeout_ms":   request.Timeout.Milliseconds(),
+		"unique_key":   request.UniqueKey,
+		"error":        "",
+		"run_at":       formatJobTime(runAt),
+		"created_at":   formatJobTime(now),
+		"updated_at":   formatJobTime(now),
+	}
+	_, err = q.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(jobKey(id), fields)
+		if request.Delay > 0 {
+			pipe.ZAdd(jobsDelayedKey, redis.Z{Score: float64(runAt.UnixNano() / int64(time.Millisecond)), Member: id})
+		} else {
+			pipe.LPush(jobsReadyKey, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error enqueueing job: %v", err)
+	}
+	return q.Get(id)
+}
+
+// Get - задача по ID.
+func (q *JobQueue) Get(id int64) (*Job, error) {
+	fields, err := q.redis.HGetAll(jobKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading job: %v", err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrJobNotFound
+	}
+	job := &Job{
+		ID:        id,
+		Type:      fields["type"],
+		Actor:     fields["actor"],
+		Payload:   json.RawMessage(fields["payload"]),
+		Status:    fields["status"],
+		Error:     fields["error"],
+		HasResult: fields["has_result"] == "1",
+		RunAt:     fields["run_at"],
+		CreatedAt: fields["created_at"],
+		UpdatedAt: fields["updated_at"],
+	}
+	job.TenantID, _ = strconv.Atoi(fields["tenant_id"])
+	job.ProjectID, _ = strconv.Atoi(fields["project_id"])
+	job.Attempts, _ = strconv.Atoi(fields["attempts"])
+	job.MaxAttempts, _ = strconv.Atoi(fields["max_attempts"])
+	timeout, _ := strconv.ParseInt(fields["timeout_ms"], 10, 64)
+	job.timeout = time.Duration(timeout) * time.Millisecond
+	return job, nil
+}
+
+// Result - результат завершенной задачи; nil, если его нет.
+func (q *JobQueue) Result(id int64) (*JobResult, error) {
+	fields, err := q.redis.HGetAll(fmt.Sprintf(jobResultKey, id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading job result: %v", err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return &JobResult{ContentType: fields["content_type"], Body: []byte(fields["body"])}, nil
+}
+
+// promoteScript - переносит наступившие отложенные задачи в очередь.
+// KEYS: ready, delayed. ARGV: сейчас (мс).
+var promoteScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('LPUSH', KEYS[1], id)
+end
+return #ids
+`)
+
+// expireScript - возвращает в очередь задачу с истекшим таймаутом видимости; на
+// последней попытке задача считается проваленной. Возвращает 0, если таймаут уже
+// не истек: задачу завершили или отдали другому воркеру.
+// KEYS: running, ready, ключ задачи. ARGV: ID, сейчас (мс), сейчас (строкой), срок хранения (с).
+var expireScript = redis.NewScript(`
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not deadline or tonumber(deadline) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+local attempts = tonumber(redis.call('HGET', KEYS[3], 'attempts') or '0')
+local max = tonumber(redis.call('HGET', KEYS[3], 'max_attempts') or '1')
+if attempts >= max then
+	redis.call('HSET', KEYS[3], 'status', 'failed')
+	redis.call('HSET', KEYS[3], 'error', 'visibility timeout expired')
+	redis.call('HSET', KEYS[3], 'updated_at', ARGV[3])
+	redis.call('EXPIRE', KEYS[3], ARGV[4])
+else
+	redis.call('HSET', KEYS[3], 'status', 'queued')
+	redis.call('HSET', KEYS[3], 'error', 'visibility timeout expired')
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// claimScript - берет из очереди задачу ARGV[1], если она все еще следующая, и
+// снимает ее ключ уникальности. Возвращает 1, если задача взята, 0, если очередь
+// успела измениться, и -1, если задачи уже нет по сроку хранения.
+// KEYS: ready, running, ключ задачи и, для уникальной задачи, ее ключ уникальности.
+// ARGV: ID, сейчас (мс), сейчас (строкой), запас таймаута видимости (мс).
+var claimScript = redis.NewScript(`
+if redis.call('LINDEX', KEYS[1], -1) ~= ARGV[1] then
+	return 0
+end
+redis.call('RPOP', KEYS[1])
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	return -1
+end
+local timeout = tonumber(redis.call('HGET', KEYS[3], 'timeout_ms') or '60000')
+redis.call('ZADD', KEYS[2], tonumber(ARGV[2]) + timeout + tonumber(ARGV[4]), ARGV[1])
+redis.call('HSET', KEYS[3], 'status', 'running')
+redis.call('HSET', KEYS[3], 'updated_at', ARGV[3])
+redis.call('HINCRBY', KEYS[3], 'attempts', 1)
+if KEYS[4] and redis.call('GET', KEYS[4]) == ARGV[1] then
+	redis.call('DEL', KEYS[4])
+end
+return 1
+`)
+
+// finishScript - записывает итог задачи, если она все еще числится за воркером.
+// Возвращает 0, если таймаут видимости уже истек и задачу отдали другому.
+// KEYS: running, delayed, ключ задачи. ARGV: ID, статус, ошибка, сейчас (строкой),
+// время повтора (мс), срок хранения (с), время повтора (строкой).
+var finishScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[3], 'status', ARGV[2])
+redis.call('HSET', KEYS[3], 'error', ARGV[3])
+redis.call('HSET', KEYS[3], 'updated_at', ARGV[4])
+if ARGV[2] == 'queued' then
+	redis.call('HSET', KEYS[3], 'run_at', ARGV[7])
+	redis.call('ZADD', KEYS[2], ARGV[5], ARGV[1])
+else
+	redis.call('EXPIRE', KEYS[3], ARGV[6])
+end
+return 1
+`)
+
+// reserve - берет задачу из очереди; nil, если готовых задач нет. Сначала в очередь
+// переносятся наступившие отложенные задачи и задачи с истекшим таймаутом видимости.
+// Скрипты получают все ключи, которые трогают, через KEYS, поэтому ID задачи
+// читается до запуска скрипта, а скрипт проверяет, что он не устарел.
+func (q *JobQueue) reserve() (*Job, error) {
+	now := time.Now()
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	if err := promoteScript.Run(q.redis, []string{jobsReadyKey, jobsDelayedKey}, nowMs).Err(); err != nil {
+		return nil, fmt.Errorf("error promoting delayed jobs: %v", err)
+	}
+	expired, err := q.redis.ZRangeByScore(jobsRunningKey, redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(nowMs, 10), Count: 100,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading expired jobs: %v", err)
+	}
+	for _, id := range expired {
+		err := expireScript.Run(q.redis, []string{jobsRunningKey, jobsReadyKey, jobKeyPrefix + id},
+			id, nowMs, formatJobTime(now), int64(jobTTL/time.Second)).Err()
+		if err != nil {
+			return nil, fmt.Errorf("error requeueing expired job %s: %v", id, err)
+		}
+	}
+
+	for {
+		id, err := q.redis.LIndex(jobsReadyKey, -1).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reserving job: %v", err)
+		}
+		keys := []string{jobsReadyKey, jobsRunningKey, jobKeyPrefix + id}
+		unique, err := q.redis.HGet(jobKeyPrefix+id, "unique_key").Result()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("error reserving job: %v", err)
+		}
+		if unique != "" {
+			keys = append(keys, fmt.Sprintf(jobUniqueKey, unique))
+		}
+		claimed, err := claimScript.Run(q.redis, keys, id, nowMs, formatJobTime(now),
+			jobVisibilityMargin.Milliseconds()).Int()
+		if err != nil {
+			return nil, fmt.Errorf("error reserving job: %v", err)
+		}
+		// Задачу взял другой воркер или ее уже нет - берем следующую
+		if claimed != 1 {
+			continue
+		}
+		jobID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error reserving job: invalid id %q", id)
+		}
+		return q.Get(jobID)
+	}
+}
+
+// finish - записывает итог задачи. retryAt задается только для JobQueued.
+func (q *JobQueue) finish(job *Job, status, errorText string, retryAt time.Time) (bool, error) {
+	now := time.Now()
+	ok, err := finishScript.Run(q.redis, []string{jobsRunningKey, jobsDelayedKey, jobKey(job.ID)},
+		job.ID, status, errorText, formatJobTime(now), retryAt.UnixNano()/int64(time.Millisecond),
+		int64(jobTTL/time.Second), formatJobTime(retryAt)).Int()
+	if err != nil {
+		return false, fmt.Errorf("error finishing job %d: %v", job.ID, err)
+	}
+	return ok == 1, nil
+}
+
+// saveResult - сохраняет результат задачи на jobTTL.
+func (q *JobQueue) saveResult(job *Job, result *JobResult) error {
+	key := fmt.Sprintf(jobResultKey, job.ID)
+	_, err := q.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(key, map[string]interface{}{"content_type": result.ContentType, "body": result.Body})
+		pipe.Expire(key, jobTTL)
+		pipe.HSet(jobKey(job.ID), "has_result", 1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving job result: %v", err)
+	}
+	return nil
+}
+
+// JobFunc - обработчик задачи одного типа. ctx отменяется по истечении Timeout задачи.
+// Возвращенный результат (если не nil) сохраняется для /job/result.
+type JobFunc func(ctx context.Context, job *Job) (*JobResult, error)
+
+// WorkerPool - воркеры, выполняющие задачи очереди. Поля можно менять после
+// NewWorkerPool, но до Run.
+type WorkerPool struct {
+	queue    *JobQueue
+	handlers map[string]JobFunc
+	// Concurrency - число одновременно выполняемых задач.
+	Concurrency int
+	// PollInterval - пауза воркера, когда задач нет.
+	PollInterval time.Duration
+	// Пауза перед повтором после ошибки растет от MinBackoff вдвое с каждой попыткой до MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewWorkerPool - пул из 4 воркеров с повтором через 5 секунд, 10, 20 ... до 5 минут.
+func NewWorkerPool(queue *JobQueue) *WorkerPool {
+	return &WorkerPool{
+		queue:        queue,
+		handlers:     make(map[string]JobFunc),
+		Concurrency:  4,
+		PollInterval: 500 * time.Millisecond,
+		MinBackoff:   5 * time.Second,
+		MaxBackoff:   5 * time.Minute,
+	}
+}
+
+// Handle - регистрирует обработчик задач типа jobType.
+func (p *WorkerPool) Handle(jobType string, fn JobFunc) {
+	p.handlers[jobType] = fn
+}
+
+// Run - выполняет задачи, пока не отменен ctx, и дожидается текущих задач.
+func (p *WorkerPool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *WorkerPool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := p.queue.reserve()
+		if err != nil {
+			log.Println(err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.PollInterval):
+			}
+			continue
+		}
+		p.process(ctx, job)
+	}
+}
+
+// process - выполняет задачу и записывает итог: успех, повтор с задержкой или провал.
+func (p *WorkerPool) process(ctx context.Context, job *Job) {
+	result, err := p.execute(ctx, job)
+	if err == nil && result != nil {
+		err = p.queue.saveResult(job, result)
+	}
+
+	status, errorText, retryAt := JobSucceeded, "", time.Time{}
+	if err != nil {
+		errorText = err.Error()
+		status = JobFailed
+		if job.Attempts < job.MaxAttempts && !errors.Is(err, errUnknownJobType) {
+			status = JobQueued
+			retryAt = time.Now().Add(p.backoff(job.Attempts - 1))
+		}
+		log.Printf("job %d (%s) attempt %d/%d failed: %v", job.ID, job.Type, job.Attempts, job.MaxAttempts, err)
+	}
+	ok, err := p.queue.finish(job, status, errorText, retryAt)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !ok {
+		log.Printf("job %d (%s) outlived its visibility timeout, result discarded", job.ID, job.Type)
+	}
+}
+
+// errUnknownJobType - обработчика нет, повторять бессмысленно.
+var errUnknownJobType = errors.New("unknown job type")
+
+func (p *WorkerPool) execute(ctx context.Context, job *Job) (result *JobResult, err error) {
+	fn, ok := p.handlers[job.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownJobType, job.Type)
+	}
+	timeout := job.timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// Паника в обработчике не должна останавливать воркер
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, job)
+}
+
+// backoff - пауза перед повтором после attempt прошлых неудач, со случайным
+// разбросом от половины до полной.
+func (p *WorkerPool) backoff(attempt int) time.Duration {
+	delay := p.MinBackoff << uint(attempt)
+	if delay <= 0 || delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
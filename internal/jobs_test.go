@@ -0,0 +1,227 @@
+package gotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// exportSource - товары для выгрузки в памяти вместо Postgres.
+type exportSource struct {
+	DBHandler
+	goods  []Good
+	tenant int
+	// err - ошибка запроса, возвращаемая до первой строки.
+	err error
+}
+
+func (s *exportSource) ForTenant(tenantID int) DBHandler {
+	s.tenant = tenantID
+	return s
+}
+
+func (s *exportSource) ExportGoods(ctx context.Context, filter ExportFilter, fn func(Good) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, good := range s.goods {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if filter.ProjectID != 0 && good.ProjectID != filter.ProjectID {
+			continue
+		}
+		if err := fn(good); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runExportJob(t *testing.T, source *exportSource, payload exportJobPayload) (*JobResult, error) {
+	t.Helper()
+	pool := NewWorkerPool(nil)
+	RegisterJobHandlers(pool, source)
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool.handlers[exportGoodsJob](context.Background(), &Job{ID: 1, TenantID: 3, Payload: raw})
+}
+
+func TestExportJobBuildsResult(t *testing.T) {
+	source := &exportSource{goods: []Good{
+		{ID: 1, ProjectID: 1, Name: "a", Tags: []string{"x", "y"}},
+		{ID: 2, ProjectID: 2, Name: "b"},
+	}}
+	result, err := runExportJob(t, source, exportJobPayload{Format: FormatCSV, Filter: ExportFilter{ProjectID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.tenant != 3 {
+		t.Errorf("export ran for tenant %d, want 3", source.tenant)
+	}
+	if result.ContentType != exportContentType(FormatCSV) {
+		t.Errorf("content type = %q", result.ContentType)
+	}
+	lines := strings.Split(strings.TrimSpace(string(result.Body)), "\n")
+	if len(lines) != 2 || lines[0] != strings.Join(goodsCSVHeader, ",") || !strings.HasPrefix(lines[1], "1,1,a,") {
+		t.Errorf("export body:\n%s", result.Body)
+	}
+}
+
+func TestExportJobRejectsOversizedExport(t *testing.T) {
+	big := Good{ID: 1, ProjectID: 1, Description: strings.Repeat("x", 1<<20)}
+	source := &exportSource{}
+	for i := 0; i < exportJobMaxBytes>>20+1; i++ {
+		source.goods = append(source.goods, big)
+	}
+	if _, err := runExportJob(t, source, exportJobPayload{Format: FormatNDJSON}); !errors.Is(err, errExportTooLarge) {
+		t.Errorf("oversized export: err = %v, want %v", err, errExportTooLarge)
+	}
+}
+
+func TestExportReportsQueryErrorBeforeHeaders(t *testing.T) {
+	h := NewHandler(&exportSource{err: errors.New("connection refused")})
+	rec := httptest.NewRecorder()
+	h.Export(rec, httptest.NewRequest(http.MethodGet, "/good/export?format=csv", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("Content-Disposition = %q on failed export", cd)
+	}
+}
+
+func TestExportWritesHeaderForEmptyResult(t *testing.T) {
+	h := NewHandler(&exportSource{})
+	rec := httptest.NewRecorder()
+	h.Export(rec, httptest.NewRequest(http.MethodGet, "/good/export?format=csv", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != exportContentType(FormatCSV) {
+		t.Errorf("status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != strings.Join(goodsCSVHeader, ",") {
+		t.Errorf("body = %q, want only the CSV header", body)
+	}
+}
+
+func TestWorkerPoolRecoversFromPanic(t *testing.T) {
+	pool := NewWorkerPool(nil)
+	pool.Handle("boom", func(ctx context.Context, job *Job) (*JobResult, error) {
+		panic("boom")
+	})
+	if _, err := pool.execute(context.Background(), &Job{Type: "boom"}); err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Errorf("panicking job: err = %v", err)
+	}
+	if _, err := pool.execute(context.Background(), &Job{Type: "missing"}); !errors.Is(err, errUnknownJobType) {
+		t.Errorf("unknown job type: err = %v", err)
+	}
+}
+
+func TestJobBackoffIsCapped(t *testing.T) {
+	pool := NewWorkerPool(nil)
+	for attempt := 0; attempt < 64; attempt++ {
+		if delay := pool.backoff(attempt); delay <= 0 || delay > pool.MaxBackoff {
+			t.Fatalf("backoff(%d) = %v", attempt, delay)
+		}
+	}
+}
+
+// openTestQueue - очередь на Redis из REDIS_HOST и REDIS_PORT в отдельной базе 15,
+// которая очищается до и после теста. Без REDIS_HOST тест пропускается.
+func openTestQueue(t *testing.T) (*JobQueue, *redis.Client) {
+	t.Helper()
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+	port := os.Getenv("REDIS_PORT")
+	if port == "" {
+		port = "6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: net.JoinHostPort(host, port), DB: 15})
+	if err := client.FlushDB().Err(); err != nil {
+		t.Fatalf("REDIS_HOST=%s is set but Redis is unreachable: %v", host, err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB()
+		client.Close()
+	})
+	return NewJobQueue(client), client
+}
+
+func TestJobQueueReserveAndFinish(t *testing.T) {
+	queue, client := openTestQueue(t)
+
+	first, err := queue.Enqueue(JobRequest{Type: "a", UniqueKey: "rebuild", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := queue.Enqueue(JobRequest{Type: "a", UniqueKey: "rebuild"}); err != nil || again.ID != first.ID {
+		t.Fatalf("duplicate unique job = %+v, %v, want job %d", again, err, first.ID)
+	}
+	delayed, err := queue.Enqueue(JobRequest{Type: "b", Delay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := queue.reserve()
+	if err != nil || job == nil || job.ID != first.ID || job.Status != JobRunning || job.Attempts != 1 {
+		t.Fatalf("reserve = %+v, %v, want job %d running", job, err, first.ID)
+	}
+	// Взятая задача снимает ключ уникальности: такую же можно поставить снова
+	if n, _ := client.Exists("jobs:unique:rebuild").Result(); n != 0 {
+		t.Error("unique lock kept after the job was reserved")
+	}
+	if job, err := queue.reserve(); err != nil || job != nil {
+		t.Errorf("reserve with only a delayed job = %+v, %v", job, err)
+	}
+
+	if ok, err := queue.finish(job, JobSucceeded, "", time.Time{}); err != nil || !ok {
+		t.Fatalf("finish = %v, %v", ok, err)
+	}
+	if job, _ := queue.Get(first.ID); job.Status != JobSucceeded {
+		t.Errorf("finished job status = %s", job.Status)
+	}
+
+	// Отложенная задача берется, когда наступает ее время
+	client.ZAdd(jobsDelayedKey, redis.Z{Score: 0, Member: delayed.ID})
+	job, err = queue.reserve()
+	if err != nil || job == nil || job.ID != delayed.ID {
+		t.Fatalf("reserve of a due job = %+v, %v, want job %d", job, err, delayed.ID)
+	}
+
+	// Задача, у которой истек таймаут видимости, возвращается в очередь
+	client.ZAdd(jobsRunningKey, redis.Z{Score: 0, Member: delayed.ID})
+	retried, err := queue.reserve()
+	if err != nil || retried == nil || retried.ID != delayed.ID || retried.Attempts != 2 || retried.Error != "visibility timeout expired" {
+		t.Fatalf("reserve of an expired job = %+v, %v", retried, err)
+	}
+	// Итог воркера, у которого задачу забрали, отбрасывается
+	client.ZAdd(jobsRunningKey, redis.Z{Score: 0, Member: delayed.ID})
+	id := strconv.FormatInt(delayed.ID, 10)
+	err = expireScript.Run(client, []string{jobsRunningKey, jobsReadyKey, jobKeyPrefix + id},
+		id, time.Now().UnixNano()/int64(time.Millisecond), formatJobTime(time.Now()), int64(jobTTL/time.Second)).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := queue.finish(retried, JobSucceeded, "", time.Time{}); err != nil || ok {
+		t.Errorf("finish after the job was requeued = %v, %v", ok, err)
+	}
+
+	// Задача без хеша (удалена по сроку хранения) пропускается
+	client.RPush(jobsReadyKey, 999)
+	if job, err := queue.reserve(); err != nil || job == nil || job.ID != delayed.ID || job.Attempts != 3 {
+		t.Errorf("reserve past a missing job = %+v, %v, want job %d", job, err, delayed.ID)
+	}
+}
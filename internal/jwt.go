@@ -0,0 +1,270 @@
+// jwt.go
+package gotest
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Роли пользователя в проекте и соответствующие им права.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleScopes = map[string]string{
+	RoleViewer: ScopeRead,
+	RoleEditor: ScopeWrite,
+	RoleAdmin:  ScopeAdmin,
+}
+
+// jwtLeeway - допустимое расхождение часов при проверке exp/nbf.
+const jwtLeeway = time.Minute
+
+// JWTClaims - поля токена, которые использует сервис.
+// Роли задаются по проектам: {"projects": {"1": "editor", "*": "viewer"}},
+// где "*" означает роль во всех проектах организации tenant_id
+// (без tenant_id - DefaultTenantID).
+type JWTClaims struct {
+	Subject   string            `json:"sub"`
+	Issuer    string            `json:"iss"`
+	Audience  jwtAudience       `json:"aud"`
+	ExpiresAt int64             `json:"exp"`
+	NotBefore int64             `json:"nbf"`
+	Projects  map[string]string `json:"projects"`
+	TenantID  int               `json:"tenant_id"`
+}
+
+// jwtAudience - aud может быть и строкой, и массивом строк.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// JWTVerifier - проверяет подпись и срок действия bearer-токенов.
+// HS256 проверяется общим секретом, RS256 - ключами из JWKS-файла.
+type JWTVerifier struct {
+	secret   []byte
+	keys     map[string]*rsa.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewJWTVerifier - создает проверяльщик. Нужен хотя бы secret или jwksFile;
+// issuer и audience проверяются, только если заданы.
+func NewJWTVerifier(secret, jwksFile, issuer, audience string) (*JWTVerifier, error) {
+	v := &JWTVerifier{
+		issuer:   issuer,
+		audience: audience,
+		now:      time.Now,
+	}
+	if secret != "" {
+		v.secret = []byte(secret)
+	}
+	if jwksFile != "" {
+		keys, err := loadJWKS(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+	if v.secret == nil && v.keys == nil {
+		return nil, errors.New("jwt: neither secret nor jwks file configured")
+	}
+	return v, nil
+}
+
+// loadJWKS - читает RSA-ключи из JWKS-файла (RFC 7517).
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: error reading jwks file: %v", err)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("jwt: error parsing jwks file: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: invalid modulus of key %q: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: invalid exponent of key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: jwks file has no RSA signing keys")
+	}
+	return keys, nil
+}
+
+// Verify - проверяет токен и возвращает его claims.
+func (v *JWTVerifier) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("jwt: malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("jwt: malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("jwt: malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	// Алгоритм выбирается по заголовку, но только среди настроенных ключей,
+	// поэтому подменить RS256 на HS256 с публичным ключом в качестве секрета нельзя.
+	switch header.Alg {
+	case "HS256":
+		if v.secret == nil {
+			return nil, errors.New("jwt: HS256 is not configured")
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("jwt: invalid signature")
+		}
+	case "RS256":
+		key, ok := v.keys[header.Kid]
+		if !ok {
+			return nil, fmt.Errorf("jwt: unknown key id %q", header.Kid)
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("jwt: invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", header.Alg)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("jwt: malformed payload")
+	}
+	var claims JWTClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("jwt: malformed payload")
+	}
+
+	now := v.now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, errors.New("jwt: token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, errors.New("jwt: token not valid yet")
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, errors.New("jwt: unexpected issuer")
+	}
+	if v.audience != "" && !claims.Audience.contains(v.audience) {
+		return nil, errors.New("jwt: unexpected audience")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("jwt: token has no subject")
+	}
+	return &claims, nil
+}
+
+func (a jwtAudience) contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// principal - права субъекта по ролям из claims. Неизвестные роли игнорируются.
+func (c *JWTClaims) principal() *Principal {
+	p := &Principal{
+		Actor:    "user:" + c.Subject,
+		TenantID: c.TenantID,
+		grants:   make(map[int][]string),
+	}
+	if p.TenantID <= 0 {
+		p.TenantID = DefaultTenantID
+	}
+	for project, role := range c.Projects {
+		scope, ok := roleScopes[role]
+		if !ok {
+			continue
+		}
+		if project == "*" {
+			p.grants[allProjects] = append(p.grants[allProjects], scope)
+			continue
+		}
+		id, err := strconv.Atoi(project)
+		if err != nil || id <= 0 {
+			continue
+		}
+		p.grants[id] = append(p.grants[id], scope)
+	}
+	return p
+}
+
+// SetJWTVerifier - включает аутентификацию по заголовку Authorization: Bearer.
+func (h *Handler) SetJWTVerifier(v *JWTVerifier) {
+	h.jwt = v
+}
+
+func (h *Handler) authenticateJWT(token string) (*Principal, error) {
+	if h.jwt == nil {
+		return nil, errUnauthenticated
+	}
+	claims, err := h.jwt.Verify(token)
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+	return claims.principal(), nil
+}
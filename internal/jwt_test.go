@@ -0,0 +1,254 @@
+package gotest
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "test-secret"
+
+var testJWTNow = time.Unix(1700000000, 0)
+
+// testJWTKey - RSA-ключ для RS256 и JWKS-файл с его открытой частью под kid "k1".
+func testJWTKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
+
+func newTestJWTVerifier(t *testing.T, secret, jwksFile string) *JWTVerifier {
+	t.Helper()
+	v, err := NewJWTVerifier(secret, jwksFile, "issuer", "goods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return testJWTNow }
+	return v
+}
+
+// testClaims - валидные claims; mutate меняет их перед подписью.
+func testClaims(mutate func(map[string]interface{})) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":      "alice",
+		"iss":      "issuer",
+		"aud":      "goods",
+		"exp":      testJWTNow.Add(time.Hour).Unix(),
+		"projects": map[string]string{"1": RoleEditor},
+	}
+	if mutate != nil {
+		mutate(claims)
+	}
+	return claims
+}
+
+func encodeJWTSegment(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signHS256 - токен с заголовком header, подписанный HMAC-SHA256 ключом secret.
+func signHS256(header, claims map[string]interface{}, secret []byte) string {
+	signed := encodeJWTSegment(header) + "." + encodeJWTSegment(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, kid string, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	t.Helper()
+	signed := encodeJWTSegment(map[string]interface{}{"alg": "RS256", "kid": kid}) + "." + encodeJWTSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifyAcceptsValidTokens(t *testing.T) {
+	key, jwks := testJWTKey(t)
+	v := newTestJWTVerifier(t, testJWTSecret, jwks)
+	tokens := map[string]string{
+		"HS256": signHS256(map[string]interface{}{"alg": "HS256"}, testClaims(nil), []byte(testJWTSecret)),
+		"RS256": signRS256(t, "k1", testClaims(nil), key),
+	}
+	for name, token := range tokens {
+		claims, err := v.Verify(token)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if p := claims.principal(); p.Actor != "user:alice" || !p.Allows(1, ScopeWrite) || p.Allows(2, ScopeRead) {
+			t.Errorf("%s: principal = %+v", name, p)
+		}
+	}
+}
+
+func TestJWTVerifyRejectsAlgorithmConfusion(t *testing.T) {
+	key, jwks := testJWTKey(t)
+	rsaOnly := newTestJWTVerifier(t, "", jwks)
+	both := newTestJWTVerifier(t, testJWTSecret, jwks)
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := encodeJWTSegment(testClaims(nil))
+
+	tests := []struct {
+		name  string
+		v     *JWTVerifier
+		token string
+	}{
+		{"alg none", both, encodeJWTSegment(map[string]interface{}{"alg": "none"}) + "." + claims + "."},
+		{"alg None", both, encodeJWTSegment(map[string]interface{}{"alg": "None"}) + "." + claims + "."},
+		{"HS256 with public key, RSA only", rsaOnly, signHS256(map[string]interface{}{"alg": "HS256", "kid": "k1"}, testClaims(nil), publicDER)},
+		{"HS256 with public key", both, signHS256(map[string]interface{}{"alg": "HS256", "kid": "k1"}, testClaims(nil), publicDER)},
+		{"HS256 with modulus", both, signHS256(map[string]interface{}{"alg": "HS256"}, testClaims(nil), key.N.Bytes())},
+		{"RS512", both, encodeJWTSegment(map[string]interface{}{"alg": "RS512", "kid": "k1"}) + "." + claims + ".c2ln"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.v.Verify(tt.token); err == nil {
+			t.Errorf("%s: token accepted", tt.name)
+		}
+	}
+}
+
+func TestJWTVerifyChecksTimeWithLeeway(t *testing.T) {
+	v := newTestJWTVerifier(t, testJWTSecret, "")
+	tests := []struct {
+		name   string
+		exp    time.Duration
+		nbf    time.Duration
+		wantOK bool
+	}{
+		{"valid", time.Hour, 0, true},
+		{"expired within leeway", -jwtLeeway + time.Second, 0, true},
+		{"expired beyond leeway", -jwtLeeway - time.Second, 0, false},
+		{"not before within leeway", time.Hour, jwtLeeway - time.Second, true},
+		{"not before beyond leeway", time.Hour, jwtLeeway + time.Second, false},
+	}
+	for _, tt := range tests {
+		claims := testClaims(func(c map[string]interface{}) {
+			c["exp"] = testJWTNow.Add(tt.exp).Unix()
+			if tt.nbf != 0 {
+				c["nbf"] = testJWTNow.Add(tt.nbf).Unix()
+			}
+		})
+		_, err := v.Verify(signHS256(map[string]interface{}{"alg": "HS256"}, claims, []byte(testJWTSecret)))
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s: err = %v, wantOK %v", tt.name, err, tt.wantOK)
+		}
+	}
+
+	noExp := testClaims(func(c map[string]interface{}) { delete(c, "exp") })
+	if _, err := v.Verify(signHS256(map[string]interface{}{"alg": "HS256"}, noExp, []byte(testJWTSecret))); err == nil {
+		t.Error("token without exp accepted")
+	}
+}
+
+func TestJWTVerifyChecksClaims(t *testing.T) {
+	v := newTestJWTVerifier(t, testJWTSecret, "")
+	tests := []struct {
+		name   string
+		mutate func(map[string]interface{})
+		wantOK bool
+	}{
+		{"audience list", func(c map[string]interface{}) { c["aud"] = []string{"other", "goods"} }, true},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "evil" }, false},
+		{"no issuer", func(c map[string]interface{}) { delete(c, "iss") }, false},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other" }, false},
+		{"wrong audience list", func(c map[string]interface{}) { c["aud"] = []string{"a", "b"} }, false},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }, false},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(signHS256(map[string]interface{}{"alg": "HS256"}, testClaims(tt.mutate), []byte(testJWTSecret)))
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s: err = %v, wantOK %v", tt.name, err, tt.wantOK)
+		}
+	}
+}
+
+func TestJWTVerifyRejectsBadKeysAndSignatures(t *testing.T) {
+	key, jwks := testJWTKey(t)
+	other, _ := testJWTKey(t)
+	v := newTestJWTVerifier(t, testJWTSecret, jwks)
+
+	tests := map[string]string{
+		"unknown kid":       signRS256(t, "k2", testClaims(nil), key),
+		"missing kid":       signRS256(t, "", testClaims(nil), key),
+		"other RSA key":     signRS256(t, "k1", testClaims(nil), other),
+		"wrong HMAC secret": signHS256(map[string]interface{}{"alg": "HS256"}, testClaims(nil), []byte("other")),
+	}
+	valid := signRS256(t, "k1", testClaims(nil), key)
+	parts := strings.Split(valid, ".")
+	tests["tampered payload"] = parts[0] + "." + encodeJWTSegment(testClaims(func(c map[string]interface{}) { c["sub"] = "mallory" })) + "." + parts[2]
+	for name, token := range tests {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestJWTVerifyRejectsMalformedTokens(t *testing.T) {
+	v := newTestJWTVerifier(t, testJWTSecret, "")
+	valid := signHS256(map[string]interface{}{"alg": "HS256"}, testClaims(nil), []byte(testJWTSecret))
+	parts := strings.Split(valid, ".")
+	// Подписанный корректно, но не JSON payload
+	signed := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("not json"))
+	mac := hmac.New(sha256.New, []byte(testJWTSecret))
+	mac.Write([]byte(signed))
+	badPayload := signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := map[string]string{
+		"empty":              "",
+		"two segments":       parts[0] + "." + parts[1],
+		"four segments":      valid + ".x",
+		"header not base64":  "!!." + parts[1] + "." + parts[2],
+		"header not json":    base64.RawURLEncoding.EncodeToString([]byte("{")) + "." + parts[1] + "." + parts[2],
+		"signature padded":   valid + "=",
+		"payload not base64": parts[0] + ".!!." + parts[2],
+		"payload not json":   badPayload,
+	}
+	for name, token := range tests {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestNewJWTVerifierNeedsKeys(t *testing.T) {
+	if _, err := NewJWTVerifier("", "", "", ""); err == nil {
+		t.Error("verifier without secret and jwks created")
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, []byte(`{"keys":[{"kty":"EC","kid":"e1"}]}`), 0o600)
+	if _, err := NewJWTVerifier("", path, "", ""); err == nil {
+		t.Error("verifier with no RSA keys in jwks created")
+	}
+}
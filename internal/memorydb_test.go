@@ -0,0 +1,298 @@
+package gotest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryDB - GoodsStore в памяти, с той же семантикой, что у SingletonDB.
+type memoryDB struct {
+	mu       sync.Mutex
+	projects map[int]Project
+	goods    map[int]Good
+	nextID   int
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{projects: map[int]Project{}, goods: map[int]Good{}}
+}
+
+func (m *memoryDB) now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func (m *memoryDB) CheckIfProjectExists(id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.projects[id]
+	return ok, nil
+}
+
+func (m *memoryDB) CheckIfGoodExists(id int, projectID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	good, ok := m.goods[id]
+	return ok && good.ProjectID == projectID, nil
+}
+
+func (m *memoryDB) GetGoods() ([]Good, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var goods []Good
+	for _, good := range m.goods {
+		goods = append(goods, copyGood(good))
+	}
+	sort.Slice(goods, func(i, j int) bool { return goods[i].ID < goods[j].ID })
+	return goods, nil
+}
+
+func (m *memoryDB) GetProjects() ([]Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var projects []Project
+	for _, project := range m.projects {
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+	return projects, nil
+}
+
+func (m *memoryDB) GetGoodsByProjects(projectIDs []int) ([]Good, error) {
+	goods, _ := m.GetGoods()
+	var result []Good
+	for _, good := range goods {
+		if containsInt(projectIDs, good.ProjectID) {
+			result = append(result, good)
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryDB) GetProjectsByIDs(ids []int) ([]Project, error) {
+	projects, _ := m.GetProjects()
+	var result []Project
+	for _, project := range projects {
+		if containsInt(ids, project.ID) {
+			result = append(result, project)
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryDB) GetGood(projectID int, id int) (*Good, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	good, ok := m.goods[id]
+	if !ok || good.ProjectID != projectID {
+		return nil, nil
+	}
+	good = copyGood(good)
+	return &good, nil
+}
+
+func (m *memoryDB) GetProject(id int) (*Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	project, ok := m.projects[id]
+	if !ok {
+		return nil, nil
+	}
+	return &project, nil
+}
+
+func (m *memoryDB) CreateGoods(projectID int, name string, fields GoodFields) (*Good, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.projects[projectID]; !ok {
+		return nil, fmt.Errorf("error inserting goods: project %d does not exist", projectID)
+	}
+	if fields == nil {
+		fields = GoodFields{}
+	}
+	m.nextID++
+	good := Good{ID: m.nextID, ProjectID: projectID, Name: name, Priority: 1, CreatedAt: m.now(), Tags: []string{}, Fields: fields}
+	m.goods[good.ID] = good
+	good = copyGood(good)
+	return &good, nil
+}
+
+func (m *memoryDB) UpdateGoods(projectID int, id int, name string, description string, fields GoodFields) (*Good, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	good, ok := m.goods[id]
+	if !ok || good.ProjectID != projectID {
+		return nil, fmt.Errorf("error updating goods: %w", sql.ErrNoRows)
+	}
+	good.Name, good.Description = name, description
+	good.Priority++
+	if fields != nil {
+		good.Fields = fields
+	}
+	m.goods[id] = good
+	good = copyGood(good)
+	return &good, nil
+}
+
+func (m *memoryDB) UpdateGoodPriority(projectID int, id int, priority int) (*Good, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	good, ok := m.goods[id]
+	if !ok || good.ProjectID != projectID {
+		return nil, fmt.Errorf("error updating good priority: %w", sql.ErrNoRows)
+	}
+	good.Priority = priority
+	m.goods[id] = good
+	good = copyGood(good)
+	return &good, nil
+}
+
+func (m *memoryDB) DeleteGoods(projectID int, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if good, ok := m.goods[id]; ok && good.ProjectID == projectID {
+		delete(m.goods, id)
+	}
+	return nil
+}
+
+func (m *memoryDB) CreateProject(name string) (*Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	project := Project{ID: m.nextID, Name: name, CreatedAt: m.now()}
+	m.projects[project.ID] = project
+	return &project, nil
+}
+
+func (m *memoryDB) UpdateProject(id int, name string) (*Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	project, ok := m.projects[id]
+	if !ok {
+		return nil, fmt.Errorf("error updating project: %w", sql.ErrNoRows)
+	}
+	project.Name = name
+	m.projects[id] = project
+	return &project, nil
+}
+
+func (m *memoryDB) DeleteProject(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, good := range m.goods {
+		if good.ProjectID == id {
+			return ErrProjectHasGoods
+		}
+	}
+	delete(m.projects, id)
+	return nil
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// copyGood - копия товара, чтобы вызывающий не менял хранимые теги и поля.
+func copyGood(good Good) Good {
+	good.Tags = append([]string{}, good.Tags...)
+	fields := make(GoodFields, len(good.Fields))
+	for k, v := range good.Fields {
+		fields[k] = v
+	}
+	good.Fields = fields
+	return good
+}
+
+// memoryHandler - DBHandler для тестов хендлеров: товары и проекты хранит memoryDB,
+// остальные методы DBHandler не реализованы.
+type memoryHandler struct {
+	DBHandler
+	store *memoryDB
+}
+
+func newMemoryHandler() *memoryHandler {
+	return &memoryHandler{store: newMemoryDB()}
+}
+
+func (m *memoryHandler) ForTenant(tenantID int) DBHandler { return m }
+func (m *memoryHandler) ReadFromReplicas() DBHandler      { return m }
+
+func (m *memoryHandler) GetGoods() ([]Good, error)       { return m.store.GetGoods() }
+func (m *memoryHandler) GetProjects() ([]Project, error) { return m.store.GetProjects() }
+func (m *memoryHandler) GetGoodsByProjects(projectIDs []int) ([]Good, error) {
+	return m.store.GetGoodsByProjects(projectIDs)
+}
+func (m *memoryHandler) GetProjectsByIDs(ids []int) ([]Project, error) {
+	return m.store.GetProjectsByIDs(ids)
+}
+func (m *memoryHandler) GetGood(projectID int, id int) (*Good, error) {
+	return m.store.GetGood(projectID, id)
+}
+func (m *memoryHandler) GetProject(id int) (*Project, error) { return m.store.GetProject(id) }
+func (m *memoryHandler) CheckIfProjectExists(id int) (bool, error) {
+	return m.store.CheckIfProjectExists(id)
+}
+func (m *memoryHandler) CheckIfGoodExists(id int, projectID int) (bool, error) {
+	return m.store.CheckIfGoodExists(id, projectID)
+}
+func (m *memoryHandler) CreateGoods(projectID int, name string, fields GoodFields) (*Good, error) {
+	return m.store.CreateGoods(projectID, name, fields)
+}
+func (m *memoryHandler) UpdateGoods(projectID int, id int, name string, description string, fields GoodFields) (*Good, error) {
+	return m.store.UpdateGoods(projectID, id, name, description, fields)
+}
+func (m *memoryHandler) UpdateGoodPriority(projectID int, id int, priority int) (*Good, error) {
+	return m.store.UpdateGoodPriority(projectID, id, priority)
+}
+func (m *memoryHandler) DeleteGoods(projectID int, id int) error {
+	return m.store.DeleteGoods(projectID, id)
+}
+func (m *memoryHandler) CreateProject(name string) (*Project, error) {
+	return m.store.CreateProject(name)
+}
+func (m *memoryHandler) UpdateProject(id int, name string) (*Project, error) {
+	return m.store.UpdateProject(id, name)
+}
+func (m *memoryHandler) DeleteProject(id int) error { return m.store.DeleteProject(id) }
+
+// ExportGoods - выгрузка товаров memoryDB с фильтрами, как у SingletonDB.
+func (m *memoryHandler) ExportGoods(ctx context.Context, filter ExportFilter, fn func(Good) error) error {
+	goods, err := m.store.GetGoods()
+	if err != nil {
+		return err
+	}
+	for _, good := range goods {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if filter.ProjectID != 0 && good.ProjectID != filter.ProjectID {
+			continue
+		}
+		if filter.ProjectIDs != nil && !containsInt(filter.ProjectIDs, good.ProjectID) {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, good.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if !filter.From.IsZero() && created.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !created.Before(filter.To) {
+			continue
+		}
+		if err := fn(good); err != nil {
+			return err
+		}
+	}
+	return nil
+}
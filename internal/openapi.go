@@ -0,0 +1,158 @@
+// openapi.go
+package gotest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openAPISpec - спецификация OpenAPI 3 всех маршрутов из Routes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPI - GET /openapi.json
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// docsOperation - одна операция спецификации в виде, удобном для шаблона.
+type docsOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Scope       string
+	Parameters  []docsParameter
+	RequestBody string
+	Responses   []docsResponse
+}
+
+type docsParameter struct {
+	Name        string
+	In          string
+	Required    bool
+	Description string
+}
+
+type docsResponse struct {
+	Code        string
+	Description string
+	Schema      string
+}
+
+// openAPIDoc - часть спецификации, которую показывает страница документации.
+type openAPIDoc struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths map[string]map[string]struct {
+		Summary    string          `json:"summary"`
+		Scope      string          `json:"x-scope"`
+		Parameters []docsParameter `json:"parameters"`
+		// RequestBody и ответы показываем как есть, в виде JSON-схемы
+		RequestBody *struct {
+			Content map[string]struct {
+				Schema json.RawMessage `json:"schema"`
+			} `json:"content"`
+		} `json:"requestBody"`
+		Responses map[string]struct {
+			Ref         string `json:"$ref"`
+			Description string `json:"description"`
+			Content     map[string]struct {
+				Schema json.RawMessage `json:"schema"`
+			} `json:"content"`
+		} `json:"responses"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+// Docs - GET /docs, страница с описанием API, построенная по openapi.json.
+func (h *Handler) Docs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	var operations []docsOperation
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			operation := docsOperation{
+				Method:     strings.ToUpper(method),
+				Path:       path,
+				Summary:    op.Summary,
+				Scope:      op.Scope,
+				Parameters: op.Parameters,
+			}
+			if op.RequestBody != nil {
+				for contentType, content := range op.RequestBody.Content {
+					operation.RequestBody += contentType + ": " + indentJSON(content.Schema) + "\n"
+				}
+			}
+			for code, response := range op.Responses {
+				resp := docsResponse{Code: code, Description: response.Description}
+				if response.Ref != "" {
+					resp.Description = strings.TrimPrefix(response.Ref, "#/components/responses/")
+				}
+				for contentType, content := range response.Content {
+					resp.Schema += contentType + ": " + indentJSON(content.Schema) + "\n"
+				}
+				operation.Responses = append(operation.Responses, resp)
+			}
+			sort.Slice(operation.Responses, func(i, j int) bool {
+				return operation.Responses[i].Code < operation.Responses[j].Code
+			})
+			operations = append(operations, operation)
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].Path < operations[j].Path
+	})
+
+	schemas := make(map[string]string, len(doc.Components.Schemas))
+	for name, schema := range doc.Components.Schemas {
+		schemas[name] = indentJSON(schema)
+	}
+
+	err := h.templates.Execute(w, "docs.html", "docs.html", map[string]interface{}{
+		"Info":       doc.Info,
+		"Operations": operations,
+		"Schemas":    schemas,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+}
+
+func indentJSON(raw json.RawMessage) string {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return string(raw)
+	}
+	return string(data)
+}
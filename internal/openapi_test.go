@@ -0,0 +1,154 @@
+package gotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type specOperation struct {
+	Scope     string `json:"x-scope"`
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type spec struct {
+	Paths      map[string]map[string]specOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	var s spec
+	if err := json.Unmarshal(openAPISpec, &s); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return s
+}
+
+// jsonFields - имена полей, под которыми тип сериализуется в JSON.
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func propertyNames(properties map[string]json.RawMessage) []string {
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s := loadSpec(t)
+	h := NewHandler(nil)
+
+	registered := make(map[string]bool)
+	for _, route := range h.Routes() {
+		method := strings.ToLower(route.Method)
+		registered[route.Path+" "+method] = true
+
+		operation, ok := s.Paths[route.Path][method]
+		if !ok {
+			t.Errorf("%s %s is not described in openapi.json", route.Method, route.Path)
+			continue
+		}
+		if operation.Scope != route.Scope {
+			t.Errorf("%s %s: spec scope %q, route scope %q", route.Method, route.Path, operation.Scope, route.Scope)
+		}
+	}
+	for path, methods := range s.Paths {
+		for method := range methods {
+			if !registered[path+" "+method] {
+				t.Errorf("openapi.json describes %s %s, but no such route is registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIMethodsMatchHandlers(t *testing.T) {
+	h := NewHandler(nil)
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodPut, http.MethodDelete}
+	for _, route := range h.Routes() {
+		for _, method := range methods {
+			if method == route.Method {
+				continue
+			}
+			w := httptest.NewRecorder()
+			route.Handler(w, httptest.NewRequest(method, route.Path, nil))
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s accepts %s, but the spec only documents %s", route.Path, method, route.Method)
+			}
+		}
+	}
+}
+
+func TestOpenAPISchemasMatchModels(t *testing.T) {
+	s := loadSpec(t)
+	models := map[string]reflect.Type{
+		"Good":            reflect.TypeOf(Good{}),
+		"Project":         reflect.TypeOf(Project{}),
+		"APIKey":          reflect.TypeOf(APIKey{}),
+		"ImportReport":    reflect.TypeOf(ImportReport{}),
+		"SearchHit":       reflect.TypeOf(SearchHit{}),
+		"Tag":             reflect.TypeOf(Tag{}),
+		"FieldDef":        reflect.TypeOf(FieldDef{}),
+		"GoodVersion":     reflect.TypeOf(GoodVersion{}),
+		"FieldChange":     reflect.TypeOf(FieldChange{}),
+		"Job":             reflect.TypeOf(Job{}),
+		"Organisation":    reflect.TypeOf(Organisation{}),
+		"Webhook":         reflect.TypeOf(Webhook{}),
+		"WebhookDelivery": reflect.TypeOf(WebhookDelivery{}),
+	}
+	for name, model := range models {
+		schema, ok := s.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing from openapi.json", name)
+			continue
+		}
+		if got, want := propertyNames(schema.Properties), jsonFields(model); !reflect.DeepEqual(got, want) {
+			t.Errorf("schema %s has properties %v, model serializes %v", name, got, want)
+		}
+	}
+
+	// PATCH и DELETE отвечают телом запроса, а не моделью Good
+	echoes := map[string]reflect.Type{
+		"/good/update patch":     reflect.TypeOf(goodRequest{}),
+		"/good/remove delete":    reflect.TypeOf(goodRemoveRequest{}),
+		"/project/remove delete": reflect.TypeOf(projectRequest{}),
+	}
+	for key, model := range echoes {
+		parts := strings.Split(key, " ")
+		response := s.Paths[parts[0]][parts[1]].Responses["200"].Content["application/json"]
+		if got, want := propertyNames(response.Schema.Properties), jsonFields(model); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s response has properties %v, handler returns %v", key, got, want)
+		}
+	}
+}
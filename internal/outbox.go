@@ -0,0 +1,268 @@
+// outbox.go
+package gotest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// outboxLockKey - ключ advisory-блокировки Postgres: outbox разбирает один
+// экземпляр за раз, иначе события обгоняли бы друг друга.
+const outboxLockKey = 7260046
+
+// CreateOutboxTable - создает таблицу outbox: изменения товаров, записанные в той же
+// транзакции, что и само изменение, и еще не разосланные подписчикам.
+func (s *SingletonDB) CreateOutboxTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS outbox (
+		id BIGSERIAL PRIMARY KEY,
+		tenant_id INTEGER NOT NULL DEFAULT COALESCE(%[1]s, %[2]d),
+		event_type TEXT NOT NULL,
+		project_id INTEGER NOT NULL,
+		good JSONB NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT clock_timestamp(),
+		sent_at TIMESTAMP
+	);
+	ALTER TABLE outbox ADD COLUMN IF NOT EXISTS settle_after xid8;
+	CREATE INDEX IF NOT EXISTS outbox_unsent_index ON outbox (id) WHERE sent_at IS NULL;
+	CREATE INDEX IF NOT EXISTS outbox_sent_at_index ON outbox (sent_at) WHERE sent_at IS NOT NULL;
+	`, currentTenant, DefaultTenantID))
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы outbox: %v", err)
+	}
+	log.Println("Таблица outbox успешно создана")
+	return nil
+}
+
+// recordGoodEvent - записывает изменение товара в outbox в транзакции изменения.
+// Вызывается после записи в goods: строка товара уже заблокирована, поэтому
+// изменения одного товара получают ID в порядке коммита, а у транзакции уже есть
+// свой xid (на это опирается RelayOutbox, см. settle_after).
+func recordGoodEvent(tx *sql.Tx, eventType string, good Good) error {
+	payload, err := json.Marshal(good)
+	if err != nil {
+		return fmt.Errorf("error marshaling good event: %v", err)
+	}
+	_, err = tx.Exec("INSERT INTO outbox (event_type, project_id, good) VALUES ($1, $2, $3::jsonb)", eventType, good.ProjectID, string(payload))
+	if err != nil {
+		return fmt.Errorf("error recording good event: %w", err)
+	}
+	return nil
+}
+
+// goodJSONColumn - товар из table в том же JSON, что дает json.Marshal(Good).
+// created_at - в том виде, в каком database/sql читает TIMESTAMP в строку (RFC 3339).
+func goodJSONColumn(table string) string {
+	return `jsonb_build_object(
+		'id', ` + table + `.id, 'project_id', ` + table + `.project_id, 'name', ` + table + `.name,
+		'description', ` + table + `.description, 'priority', ` + table + `.priority, 'removed', ` + table + `.removed,
+		'created_at', to_char(` + table + `.created_at, 'YYYY-MM-DD"T"HH24:MI:SS')
+			|| rtrim(rtrim(to_char(` + table + `.created_at, '.US'), '0'), '.') || 'Z',
+		'tags', to_jsonb(` + goodTagsColumn(table) + `), 'fields', ` + table + `.fields)`
+}
+
+// recordGoodEvents - то же, что recordGoodEvent для каждого товара из ids, одним
+// запросом - для массовых изменений (импорт, схема полей, теги). Строки товаров
+// блокируются, поэтому события одного товара и здесь идут в порядке коммита.
+func recordGoodEvents(tx *sql.Tx, eventType string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+	INSERT INTO outbox (event_type, project_id, good)
+	SELECT $1, g.project_id, `+goodJSONColumn("g")+`
+	FROM goods g WHERE g.id = ANY($2) ORDER BY g.id FOR UPDATE OF g`, eventType, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error recording good events: %w", err)
+	}
+	return nil
+}
+
+// scanIDs - первая колонка всех строк rows; rows закрываются.
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// outboxRecord - неразосланная запись outbox.
+type outboxRecord struct {
+	event    GoodEvent
+	tenantID int
+	// settled - все транзакции, которые могли взять ID меньше этой записи, завершились
+	settled bool
+}
+
+// RelayOutbox - рассылает до limit записей outbox всех организаций строго по порядку ID:
+// публикует событие в Redis, ставит доставки вебхуков и отмечает запись разосланной.
+// Доставки и отметка пишутся одной транзакцией, а публикация в Redis - до коммита,
+// поэтому после сбоя событие может прийти повторно, но не потеряется; ID события -
+// ID записи, по нему подписчики отсеивают повторы. Возвращает, сколько записей разослано.
+//
+// ID выдаются при вставке, а видны после коммита, поэтому перед пропущенным ID
+// рассылка останавливается: транзакция с этим ID может еще закоммититься.
+// Откаченная транзакция определяется без таймаутов. Пропущенный ID взят раньше
+// ID следующей записи, а xid у той транзакции появился еще раньше (см. recordGoodEvent),
+// поэтому он меньше xmax любого снимка, в котором следующая запись уже видна.
+// Этот xmax запоминается в settle_after следующей записи; когда все транзакции
+// с меньшим xid завершились, а пропущенного ID так и нет, его транзакция откачена.
+func (s *SingletonDB) RelayOutbox(limit int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", outboxLockKey).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("error locking outbox: %v", err)
+	}
+	if !locked {
+		// Outbox разбирает другой экземпляр
+		return 0, nil
+	}
+	var last sql.NullInt64
+	err = tx.QueryRow("SELECT id FROM outbox WHERE sent_at IS NOT NULL ORDER BY id DESC LIMIT 1").Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error reading outbox: %v", err)
+	}
+	records, err := unsentOutbox(tx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent []int64
+	var gap int64
+	var relayErr error
+	for _, record := range records {
+		if record.event.ID != last.Int64+1 && !record.settled {
+			// Транзакция с предыдущим ID, возможно, еще не закоммичена - дождемся ее
+			gap = record.event.ID
+			break
+		}
+		scoped := s.ForTenant(record.tenantID).(*SingletonDB)
+		if relayErr = scoped.publishGoodEvent(record.event); relayErr != nil {
+			break
+		}
+		if relayErr = enqueueWebhooks(tx, record.event); relayErr != nil {
+			// Доставки этой пачки откатятся вместе с транзакцией
+			return 0, relayErr
+		}
+		sent = append(sent, record.event.ID)
+		last = sql.NullInt64{Int64: record.event.ID, Valid: true}
+	}
+	if gap != 0 {
+		_, err := tx.Exec("UPDATE outbox SET settle_after = pg_snapshot_xmax(pg_current_snapshot()) WHERE id = $1 AND settle_after IS NULL", gap)
+		if err != nil {
+			return 0, fmt.Errorf("error marking outbox gap: %v", err)
+		}
+	}
+	if len(sent) > 0 {
+		if _, err := tx.Exec("UPDATE outbox SET sent_at = NOW() WHERE id = ANY($1)", pq.Array(sent)); err != nil {
+			return 0, fmt.Errorf("error marking outbox sent: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return len(sent), relayErr
+}
+
+// unsentOutbox - первые limit неразосланных записей по возрастанию ID.
+func unsentOutbox(tx *sql.Tx, limit int) ([]outboxRecord, error) {
+	rows, err := tx.Query(`
+	SELECT id, tenant_id, event_type, good, COALESCE(settle_after <= pg_snapshot_xmin(pg_current_snapshot()), false)
+	FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox: %v", err)
+	}
+	defer rows.Close()
+
+	var records []outboxRecord
+	for rows.Next() {
+		var record outboxRecord
+		var good []byte
+		if err := rows.Scan(&record.event.ID, &record.tenantID, &record.event.Type, &good, &record.settled); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(good, &record.event.Good); err != nil {
+			return nil, fmt.Errorf("error decoding outbox record %d: %v", record.event.ID, err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// PurgeOutbox - удаляет записи, разосланные раньше, чем age назад. Последняя
+// разосланная запись остается: по ней RelayOutbox узнает, с какого ID продолжать.
+func (s *SingletonDB) PurgeOutbox(age time.Duration) (int64, error) {
+	result, err := s.db.Exec(`
+	DELETE FROM outbox WHERE sent_at < NOW() - $1::bigint * INTERVAL '1 millisecond'
+	AND id < (SELECT MAX(id) FROM outbox WHERE sent_at IS NOT NULL)`, age.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("error purging outbox: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// OutboxRelay - фоновая рассылка outbox. Работает без организации: записи всех
+// организаций расходятся одной очередью. Поля можно менять после NewOutboxRelay, но до Run.
+type OutboxRelay struct {
+	db DBHandler
+	// BatchSize - сколько записей рассылается за транзакцию, PollInterval - пауза, когда рассылать нечего.
+	BatchSize    int
+	PollInterval time.Duration
+	// Retention - сколько хранятся разосланные записи.
+	Retention time.Duration
+}
+
+// NewOutboxRelay - рассылка пачками по 100 записей с опросом 4 раза в секунду;
+// разосланные записи хранятся неделю.
+func NewOutboxRelay(db DBHandler) *OutboxRelay {
+	return &OutboxRelay{
+		db:           db,
+		BatchSize:    100,
+		PollInterval: 250 * time.Millisecond,
+		Retention:    7 * 24 * time.Hour,
+	}
+}
+
+// Run - рассылает outbox, пока не отменен ctx. Раз в час удаляет старые записи.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	purged := time.Time{}
+	for {
+		if time.Since(purged) > time.Hour {
+			if n, err := r.db.PurgeOutbox(r.Retention); err != nil {
+				log.Println("Error purging outbox:", err)
+			} else if n > 0 {
+				log.Printf("Purged %d outbox records", n)
+			}
+			purged = time.Now()
+		}
+		n, err := r.db.RelayOutbox(r.BatchSize)
+		if err != nil {
+			log.Println("Error relaying outbox:", err)
+		}
+		if n == r.BatchSize && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
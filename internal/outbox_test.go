@@ -0,0 +1,135 @@
+package gotest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOutboxRelayKeepsOrder - события из параллельных транзакций, в том числе
+// откаченных, расходятся строго по возрастанию ID и без потерь, пока рассылка
+// работает одновременно с записью. Нужны Postgres и Redis (см. openTestDB).
+func TestOutboxRelayKeepsOrder(t *testing.T) {
+	db := openTestDB(t)
+	root := db.(*SingletonDB)
+	org, err := db.CreateOrganisation("outbox " + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := db.ForTenant(org.ID)
+	project := mustCreateProject(t, store, "outbox")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	events, err := store.SubscribeGoodEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Рассылка работает все время, пока пишутся события, и до конца теста
+	stop := make(chan struct{})
+	relayed := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-relayed
+	}()
+	go func() {
+		defer close(relayed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := db.RelayOutbox(7); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	const writers, goodsPerWriter = 8, 10
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < goodsPerWriter; j++ {
+				good, err := store.CreateGoods(project.ID, "good", nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := store.UpdateGoods(project.ID, good.ID, "renamed", "", nil); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		// Откаченные транзакции оставляют в последовательности ID пропуски,
+		// на которых рассылка не должна останавливаться навсегда
+		go func() {
+			defer wg.Done()
+			for j := 0; j < goodsPerWriter; j++ {
+				tx, err := root.db.Begin()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, err = tx.Exec("INSERT INTO outbox (tenant_id, event_type, project_id, good) VALUES ($1, $2, $3, '{}')", org.ID, GoodCreated, project.ID)
+				tx.Rollback()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := 2 * writers * goodsPerWriter
+	var lastID int64
+	created := make(map[int]bool)
+	for received := 0; received < want; received++ {
+		select {
+		case event := <-events:
+			if event.ID <= lastID {
+				t.Fatalf("event %d arrived after %d", event.ID, lastID)
+			}
+			lastID = event.ID
+			switch event.Type {
+			case GoodCreated:
+				created[event.Good.ID] = true
+			case GoodUpdated:
+				if !created[event.Good.ID] {
+					t.Errorf("good %d updated before it was created", event.Good.ID)
+				}
+			}
+		case <-ctx.Done():
+			t.Fatalf("received %d of %d events", received, want)
+		}
+	}
+
+	// После очистки рассылка продолжает с последней разосланной записи
+	if _, err := db.PurgeOutbox(0); err != nil {
+		t.Fatal(err)
+	}
+	var remaining int
+	if err := root.db.QueryRow("SELECT COUNT(*) FROM outbox WHERE sent_at IS NOT NULL").Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 1 {
+		t.Errorf("%d sent records left after purge, want 1", remaining)
+	}
+	good := mustCreateGood(t, store, project.ID, "after purge")
+	select {
+	case event := <-events:
+		if event.ID <= lastID || event.Good.ID != good.ID {
+			t.Errorf("event after purge = %d for good %d, want after %d for good %d", event.ID, event.Good.ID, lastID, good.ID)
+		}
+	case <-ctx.Done():
+		t.Fatal("event after purge was not relayed")
+	}
+}
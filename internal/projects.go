@@ -0,0 +1,224 @@
+// projects.go
+package gotest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ErrProjectHasGoods - проект нельзя удалить, пока в нем есть товары.
+var ErrProjectHasGoods = errors.New("project has goods")
+
+// CreateProject - создает проект.
+// Проект достается организации транзакции (см. ForTenant).
+func (s *SingletonDB) CreateProject(name string) (*Project, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var project Project
+	err = tx.QueryRow("INSERT INTO projects (name) VALUES ($1) RETURNING id, name, created_at", name).Scan(&project.ID, &project.Name, &project.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting project: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return &project, nil
+}
+
+// UpdateProject - переименовывает проект.
+func (s *SingletonDB) UpdateProject(id int, name string) (*Project, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var project Project
+	err = tx.QueryRow("UPDATE projects SET name = $1 WHERE id = $2 RETURNING id, name, created_at", name, id).Scan(&project.ID, &project.Name, &project.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error updating project: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return &project, nil
+}
+
+// DeleteProject - удаляет пустой проект вместе с его API-ключами.
+// Если в проекте есть товары, возвращает ErrProjectHasGoods.
+func (s *SingletonDB) DeleteProject(id int) error {
+	// Начинаем транзакцию
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+
+	// Блокируем проект, чтобы в него не добавили товар между проверкой и удалением
+	if _, err := tx.Exec("SELECT id FROM projects WHERE id = $1 FOR UPDATE", id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error locking project: %v", err)
+	}
+	var hasGoods bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM goods WHERE project_id = $1)", id).Scan(&hasGoods); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error checking project goods: %v", err)
+	}
+	if hasGoods {
+		tx.Rollback()
+		return ErrProjectHasGoods
+	}
+	if _, err := tx.Exec("DELETE FROM api_keys WHERE project_id = $1", id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting project api keys: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM project_fields WHERE project_id = $1", id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting project fields: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE project_id = $1", id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting project tags: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE project_id = $1", id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting project webhooks: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM projects WHERE id = $1", id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting project: %v", err)
+	}
+
+	// Коммитим транзакцию
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// projectRequest - тело запросов /project/update и /project/remove.
+type projectRequest struct {
+	ProjectID string `json:"projectId"`
+	Name      string `json:"name,omitempty"`
+	Removed   bool   `json:"removed,omitempty"`
+}
+
+// CreateProject - POST /project/create {"name": "..."}
+// Новый проект не принадлежит ни одному ключу, поэтому нужен admin во всех проектах.
+func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p := PrincipalFromContext(r.Context()); p != nil && !p.Allows(allProjects, ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	project, err := h.store(r.Context()).CreateProject(request.Name)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "project.create", project.ID, project.ID)
+	writeJSON(w, http.StatusOK, project)
+}
+
+// UpdateProject - PATCH /project/update {"projectId": "1", "name": "..."}
+func (h *Handler) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request projectRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil || request.Name == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	project, err := h.store(r.Context()).UpdateProject(projectID, request.Name)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "project.update", projectID, projectID)
+	writeJSON(w, http.StatusOK, project)
+}
+
+// DeleteProject - DELETE /project/remove {"projectId": "1"}
+// Проект с товарами не удаляется: 409 Conflict.
+func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request projectRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	err = h.store(r.Context()).DeleteProject(projectID)
+	if err == ErrProjectHasGoods {
+		http.Error(w, "Project has goods", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "project.remove", projectID, projectID)
+	request.Removed = true
+	writeJSON(w, http.StatusOK, request)
+}
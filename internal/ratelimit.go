@@ -0,0 +1,297 @@
+// ratelimit.go
+package gotest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RateLimit - параметры token bucket: Rate токенов в секунду, не больше Burst за раз.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultRateLimitRoute - ключ в конфигурации лимитов, действующий для всех прочих маршрутов.
+const DefaultRateLimitRoute = "default"
+
+// ParseRateLimits - разбирает конфигурацию вида "default=10:20,/good/update=1:5",
+// где для каждого маршрута указаны запросы в секунду и размер пачки.
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q", item)
+		}
+		values := strings.SplitN(parts[1], ":", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q, expected rate:burst", item)
+		}
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q", item)
+		}
+		burst, err := strconv.Atoi(values[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst in %q", item)
+		}
+		limits[parts[0]] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+// tokenBucketScript - атомарно пополняет корзину по прошедшему времени и списывает
+// ARGV[3] токенов (0 - только проверить, есть ли токен).
+// Время берется у Redis, поэтому часы разных экземпляров приложения не важны.
+// Возвращает {разрешено, осталось токенов, через сколько мс будет токен, через сколько мс корзина полна}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - cost
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+local reset = math.ceil((burst - tokens) * 1000 / rate)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), wait, reset}
+`)
+
+// RateLimiter - распределенный лимит запросов, общий для всех экземпляров приложения.
+type RateLimiter struct {
+	buckets tokenBuckets
+	limits  map[string]RateLimit
+}
+
+// NewRateLimiter - limits задает лимиты по маршрутам, DefaultRateLimitRoute - для остальных.
+// Маршруты без лимита не ограничиваются. Корзины хранятся в Redis client.
+func NewRateLimiter(client *redis.Client, limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{buckets: redisBuckets{client}, limits: limits}
+}
+
+// tokenBuckets - хранилище корзин: take списывает cost токенов из корзины key.
+type tokenBuckets interface {
+	take(key string, limit RateLimit, cost int) (*bucket, error)
+}
+
+// redisBuckets - корзины в Redis, см. tokenBucketScript.
+type redisBuckets struct {
+	client *redis.Client
+}
+
+// bucket - состояние корзины после проверки.
+type bucket struct {
+	allowed   bool
+	remaining int64
+	wait      int64
+	reset     int64
+}
+
+func (l *RateLimiter) limit(route string) (RateLimit, bool) {
+	limit, ok := l.limits[route]
+	if !ok {
+		limit, ok = l.limits[DefaultRateLimitRoute]
+	}
+	return limit, ok
+}
+
+func (r redisBuckets) take(key string, limit RateLimit, cost int) (*bucket, error) {
+	result, err := tokenBucketScript.Run(r.client, []string{key}, limit.Rate, limit.Burst, cost).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+	allowed, _ := values[0].(int64)
+	b := &bucket{allowed: allowed == 1}
+	b.remaining, _ = values[1].(int64)
+	b.wait, _ = values[2].(int64)
+	b.reset, _ = values[3].(int64)
+	return b, nil
+}
+
+// Limit - middleware, ограничивающее частоту запросов к route отдельно для каждого
+// субъекта (см. RequireScope) или, на маршрутах без авторизации, для каждого IP.
+// Для маршрутов с правами ставится после RequireScope, чтобы считать запросы
+// по проверенному ключу, а не по присланному заголовку.
+func (l *RateLimiter) Limit(route string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := l.limit(route)
+	if !ok {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := l.buckets.take("ratelimit:"+route+":"+rateLimitClient(r), limit, 1)
+		if err != nil {
+			// Недоступность Redis не должна останавливать API
+			log.Println("Error checking rate limit:", err)
+			next(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatInt(b.remaining, 10))
+		w.Header().Set("RateLimit-Reset", strconv.FormatInt(msToSeconds(b.reset), 10))
+		if !b.allowed {
+			tooManyRequests(w, b)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// LimitAuthFailures - middleware перед RequireScope: запросы, не прошедшие
+// аутентификацию (401), расходуют корзину IP клиента. Когда она пуста, запросы
+// с этого IP отклоняются до проверки ключа, поэтому перебор или случайные ключи
+// не нагружают базу.
+func (l *RateLimiter) LimitAuthFailures(route string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := l.limit(route)
+	if !ok {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ratelimit:" + route + ":auth:" + clientIP(r)
+		b, err := l.buckets.take(key, limit, 0)
+		if err != nil {
+			log.Println("Error checking rate limit:", err)
+		} else if !b.allowed {
+			tooManyRequests(w, b)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status == http.StatusUnauthorized {
+			if _, err := l.buckets.take(key, limit, 1); err != nil {
+				log.Println("Error checking rate limit:", err)
+			}
+		}
+	}
+}
+
+// UnaryServerInterceptor - Limit для gRPC: ставится после проверки учетных данных,
+// корзина своя у каждого метода и субъекта. Лимит метода задается по полному
+// имени, например "/goods.v1.GoodsService/CreateGood", иначе действует DefaultRateLimitRoute.
+func (l *RateLimiter) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	limit, ok := l.limit(info.FullMethod)
+	if !ok {
+		return handler(ctx, req)
+	}
+	b, err := l.buckets.take("ratelimit:"+info.FullMethod+":"+grpcRateLimitClient(ctx), limit, 1)
+	if err != nil {
+		log.Println("Error checking rate limit:", err)
+		return handler(ctx, req)
+	}
+	header := metadata.Pairs(
+		"ratelimit-limit", strconv.Itoa(limit.Burst),
+		"ratelimit-remaining", strconv.FormatInt(b.remaining, 10),
+		"ratelimit-reset", strconv.FormatInt(msToSeconds(b.reset), 10),
+	)
+	if !b.allowed {
+		header.Set("retry-after", strconv.FormatInt(msToSeconds(b.wait), 10))
+	}
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		log.Println("Error setting rate limit headers:", err)
+	}
+	if !b.allowed {
+		return nil, grpcError(http.StatusTooManyRequests, "Too many requests")
+	}
+	return handler(ctx, req)
+}
+
+func tooManyRequests(w http.ResponseWriter, b *bucket) {
+	w.Header().Set("Retry-After", strconv.FormatInt(msToSeconds(b.wait), 10))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+func msToSeconds(ms int64) int64 {
+	return int64(math.Ceil(float64(ms) / 1000))
+}
+
+// rateLimitClient - кого ограничиваем: субъекта, прошедшего аутентификацию, иначе IP.
+func rateLimitClient(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		return fmt.Sprintf("actor:%d:%s", p.TenantID, p.Actor)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// grpcRateLimitClient - rateLimitClient для вызова gRPC.
+func grpcRateLimitClient(ctx context.Context) string {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return fmt.Sprintf("actor:%d:%s", p.TenantID, p.Actor)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:unknown"
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder - запоминает код ответа; Flush нужен потоковым ответам (экспорт, SSE).
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,220 @@
+package gotest
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRateLimitClient(t *testing.T) {
+	anonymous := httptest.NewRequest(http.MethodGet, "/good/get", nil)
+	anonymous.RemoteAddr = "203.0.113.7:51234"
+	// Непроверенный ключ в заголовке не дает отдельной корзины
+	withKey := anonymous.Clone(context.Background())
+	withKey.Header.Set("X-API-Key", "random")
+	if got, want := rateLimitClient(withKey), "ip:203.0.113.7"; rateLimitClient(anonymous) != want || got != want {
+		t.Errorf("rateLimitClient = %q, want %q", got, want)
+	}
+
+	principal := &Principal{Actor: "apikey:12", TenantID: 3}
+	authenticated := anonymous.WithContext(context.WithValue(anonymous.Context(), principalKey{}, principal))
+	if got, want := rateLimitClient(authenticated), "actor:3:apikey:12"; got != want {
+		t.Errorf("rateLimitClient = %q, want %q", got, want)
+	}
+}
+
+func TestStatusRecorderKeepsFlusher(t *testing.T) {
+	recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	var w http.ResponseWriter = recorder
+	if _, ok := w.(http.Flusher); !ok {
+		t.Fatal("statusRecorder is not an http.Flusher")
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if recorder.status != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", recorder.status)
+	}
+}
+
+// memoryBuckets - корзины в памяти при остановленных часах: токены не пополняются.
+type memoryBuckets struct {
+	tokens map[string]float64
+}
+
+func newMemoryLimiter(limits map[string]RateLimit) (*RateLimiter, *memoryBuckets) {
+	buckets := &memoryBuckets{tokens: map[string]float64{}}
+	return &RateLimiter{buckets: buckets, limits: limits}, buckets
+}
+
+// take - тот же расчет, что в tokenBucketScript, без пополнения.
+func (m *memoryBuckets) take(key string, limit RateLimit, cost int) (*bucket, error) {
+	tokens, ok := m.tokens[key]
+	if !ok {
+		tokens = float64(limit.Burst)
+	}
+	b := &bucket{}
+	if tokens >= 1 {
+		tokens -= float64(cost)
+		b.allowed = true
+	} else {
+		b.wait = int64(math.Ceil((1 - tokens) * 1000 / limit.Rate))
+	}
+	m.tokens[key] = tokens
+	b.remaining = int64(math.Floor(tokens))
+	b.reset = int64(math.Ceil((float64(limit.Burst) - tokens) * 1000 / limit.Rate))
+	return b, nil
+}
+
+func TestParseRateLimits(t *testing.T) {
+	got, err := ParseRateLimits(" default=10:20, /good/update=0.5:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]RateLimit{"default": {Rate: 10, Burst: 20}, "/good/update": {Rate: 0.5, Burst: 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRateLimits = %v, want %v", got, want)
+	}
+	if got, err := ParseRateLimits(""); err != nil || len(got) != 0 {
+		t.Errorf("ParseRateLimits(\"\") = %v, %v", got, err)
+	}
+
+	for _, s := range []string{
+		"default",
+		"default=10",
+		"default=x:5",
+		"default=0:5",
+		"default=-1:5",
+		"default=1:x",
+		"default=1:0",
+		"default=1:2.5",
+		"default=1:5,",
+	} {
+		if _, err := ParseRateLimits(s); err == nil {
+			t.Errorf("ParseRateLimits(%q): no error", s)
+		}
+	}
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func limitedRequest(h http.HandlerFunc, path, ip string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	return rec
+}
+
+func TestLimitRejectsWhenBucketIsEmpty(t *testing.T) {
+	limiter, _ := newMemoryLimiter(map[string]RateLimit{"/good/get": {Rate: 0.5, Burst: 2}})
+	h := limiter.Limit("/good/get", okHandler)
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rec := limitedRequest(h, "/good/get", "203.0.113.1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != wantRemaining {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i+1, got, wantRemaining)
+		}
+		if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit = %q, want 2", i+1, got)
+		}
+	}
+
+	rec := limitedRequest(h, "/good/get", "203.0.113.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	// Токен появится через 1 / 0.5 = 2 с, корзина наполнится через 2 / 0.5 = 4 с
+	want := map[string]string{"Retry-After": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "4"}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	// У другого клиента своя корзина
+	if rec := limitedRequest(h, "/good/get", "203.0.113.2"); rec.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLimitUsesRouteLimits(t *testing.T) {
+	limits, err := ParseRateLimits("default=1:3,/good/update=1:1,/good/export=1:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, _ := newMemoryLimiter(limits)
+	tests := []struct {
+		route string
+		want  int
+	}{
+		{"/good/update", 1},
+		{"/good/export", 2},
+		{"/good/get", 3},
+	}
+	for _, tt := range tests {
+		h := limiter.Limit(tt.route, okHandler)
+		allowed := 0
+		for i := 0; i < 5; i++ {
+			if limitedRequest(h, tt.route, "203.0.113.1").Code == http.StatusOK {
+				allowed++
+			}
+		}
+		if allowed != tt.want {
+			t.Errorf("%s: %d requests allowed, want %d", tt.route, allowed, tt.want)
+		}
+	}
+
+	// Без default маршруты без своего лимита не ограничиваются
+	limiter, _ = newMemoryLimiter(map[string]RateLimit{"/good/update": {Rate: 1, Burst: 1}})
+	h := limiter.Limit("/good/get", okHandler)
+	for i := 0; i < 5; i++ {
+		if rec := limitedRequest(h, "/good/get", "203.0.113.1"); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("unlimited route: status = %d, headers %v", rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestLimitAuthFailuresThrottlesFailedLogins(t *testing.T) {
+	limiter, buckets := newMemoryLimiter(map[string]RateLimit{"/good/create": {Rate: 1, Burst: 2}})
+	authorized := true
+	h := limiter.LimitAuthFailures("/good/create", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Успешные запросы корзину не расходуют
+	for i := 0; i < 5; i++ {
+		if rec := limitedRequest(h, "/good/create", "203.0.113.1"); rec.Code != http.StatusOK {
+			t.Fatalf("authorized request %d: status = %d, want 200", i+1, rec.Code)
+		}
+	}
+	if len(buckets.tokens) != 1 || buckets.tokens["ratelimit:/good/create:auth:203.0.113.1"] != 2 {
+		t.Errorf("tokens after successful requests = %v", buckets.tokens)
+	}
+
+	authorized = false
+	for i := 0; i < 2; i++ {
+		if rec := limitedRequest(h, "/good/create", "203.0.113.1"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: status = %d, want 401", i+1, rec.Code)
+		}
+	}
+	// Корзина пуста: даже верный ключ с этого IP не проверяется
+	authorized = true
+	rec := limitedRequest(h, "/good/create", "203.0.113.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("after failed logins: status = %d, Retry-After %q; want 429 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := limitedRequest(h, "/good/create", "203.0.113.2"); rec.Code != http.StatusOK {
+		t.Errorf("other IP: status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,215 @@
+// replicas.go
+package gotest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaCheckInterval - как часто проверяются доступность и отставание реплик.
+const replicaCheckInterval = 2 * time.Second
+
+// replicaStatusQuery - состояние реплики: в режиме ли восстановления, получает
+// ли WAL от primary, применила ли все полученное и сколько секунд назад применила
+// последнюю транзакцию. Статус pg_stat_wal_receiver виден только суперпользователю
+// и членам pg_read_all_stats, для остальных реплика считается не получающей WAL.
+const replicaStatusQuery = `
+SELECT pg_is_in_recovery(),
+	COALESCE((SELECT status = 'streaming' FROM pg_stat_wal_receiver), false),
+	COALESCE(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), false),
+	EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())`
+
+// replicaStatus - результат replicaStatusQuery.
+type replicaStatus struct {
+	inRecovery bool
+	streaming  bool
+	caughtUp   bool
+	replayAge  sql.NullFloat64
+}
+
+// lag - отставание реплики; false, если его не установить. Реплика, применившая
+// все полученное, не отстает, даже если на primary давно не было записей, но только
+// пока получает WAL: отключенная от primary не знает, сколько пропустила, и ее
+// отставание считается от последней примененной транзакции.
+func (st replicaStatus) lag() (time.Duration, bool) {
+	switch {
+	case st.streaming && st.caughtUp:
+		return 0, true
+	case st.replayAge.Valid:
+		return time.Duration(st.replayAge.Float64 * float64(time.Second)), true
+	default:
+		// Реплика еще не применила ни одной транзакции
+		return 0, st.streaming
+	}
+}
+
+// replica - реплика для чтения и ее состояние по последней проверке.
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy bool
+	lag     time.Duration
+}
+
+// replicaSet - реплики для чтения. Чтение идет на здоровые реплики, отстающие
+// не больше maxLag, по очереди.
+type replicaSet struct {
+	mu       sync.RWMutex
+	replicas []*replica
+	maxLag   time.Duration
+	next     uint32
+	stop     chan struct{}
+}
+
+// ConnectReplicas - подключает реплики для чтения по строкам подключения dsns.
+// Реплика, отстающая больше maxLag или недоступная, не получает запросов, пока
+// не догонит primary. Состояние реплик проверяется в фоне до Close.
+func (s *SingletonDB) ConnectReplicas(dsns []string, maxLag time.Duration) error {
+	set := &replicaSet{maxLag: maxLag, stop: make(chan struct{})}
+	for i, dsn := range dsns {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			set.close()
+			return fmt.Errorf("Ошибка при подключении к реплике %d: %v", i+1, err)
+		}
+		s.pool.apply(db)
+		// Имя для логов: в строке подключения может быть пароль
+		set.replicas = append(set.replicas, &replica{name: fmt.Sprintf("replica %d", i+1), db: db})
+	}
+	set.check()
+	go set.monitor()
+	s.replicas = set
+	log.Printf("Подключено реплик для чтения: %d", len(dsns))
+	return nil
+}
+
+// ReadFromReplicas - копия подключения, которая читает с реплик: GetGoods, GetGood,
+// GetProjects, GetProject, CheckIf*Exists и SearchGoods. Запись по-прежнему идет на primary.
+func (s *SingletonDB) ReadFromReplicas() DBHandler {
+	scoped := *s
+	scoped.readReplicas = true
+	return &scoped
+}
+
+// usesReplicas - читает ли подключение с реплик.
+func (s *SingletonDB) usesReplicas() bool {
+	return s.readReplicas && s.replicas != nil
+}
+
+// readTx - транзакция для чтения, допускающего отставание: на реплике, если
+// подключение читает с реплик и есть подходящая реплика, иначе на primary.
+func (s *SingletonDB) readTx() (*sql.Tx, error) {
+	if s.usesReplicas() {
+		if r := s.replicas.pick(); r != nil {
+			tx, err := s.beginOn(context.Background(), r.db, &sql.TxOptions{ReadOnly: true})
+			if err == nil {
+				return tx, nil
+			}
+			log.Printf("Error reading from %s, falling back to primary: %v", r.name, err)
+			s.replicas.markDown(r)
+		}
+	}
+	return s.begin()
+}
+
+// pick - следующая по очереди здоровая реплика с допустимым отставанием; nil, если таких нет.
+func (set *replicaSet) pick() *replica {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	var usable []*replica
+	for _, r := range set.replicas {
+		if r.healthy && r.lag <= set.maxLag {
+			usable = append(usable, r)
+		}
+	}
+	if len(usable) == 0 {
+		return nil
+	}
+	return usable[int(atomic.AddUint32(&set.next, 1))%len(usable)]
+}
+
+// markDown - выводит реплику из работы до следующей успешной проверки.
+func (set *replicaSet) markDown(r *replica) {
+	set.mu.Lock()
+	r.healthy = false
+	set.mu.Unlock()
+}
+
+// monitor - проверяет реплики каждые replicaCheckInterval до close.
+func (set *replicaSet) monitor() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-set.stop:
+			return
+		case <-ticker.C:
+			set.check()
+		}
+	}
+}
+
+// check - обновляет доступность и отставание реплик. Пишет в лог, когда реплика
+// выходит из работы или возвращается в нее.
+func (set *replicaSet) check() {
+	for _, r := range set.replicas {
+		st, err := replicaState(r.db)
+		lag, known := st.lag()
+		healthy := err == nil && st.inRecovery && known
+		set.mu.Lock()
+		wasUsable := r.healthy && r.lag <= set.maxLag
+		r.healthy, r.lag = healthy, lag
+		usable := r.healthy && r.lag <= set.maxLag
+		set.mu.Unlock()
+
+		switch {
+		case wasUsable && !usable && err != nil:
+			log.Printf("%s is down, reading from primary: %v", r.name, err)
+		case wasUsable && !usable && !st.inRecovery:
+			log.Printf("%s is not in recovery, reading from primary", r.name)
+		case wasUsable && !usable && !known:
+			log.Printf("%s is not streaming from primary and its lag is unknown, reading from primary", r.name)
+		case wasUsable && !usable && !st.streaming:
+			log.Printf("%s is not streaming from primary and lags %v, reading from primary", r.name, lag)
+		case wasUsable && !usable:
+			log.Printf("%s lags %v behind primary, reading from primary", r.name, lag)
+		case !wasUsable && usable:
+			log.Printf("%s is back in rotation", r.name)
+		}
+	}
+}
+
+// replicaState - состояние реплики. Сервер не в режиме восстановления - не реплика
+// (например, после переключения), читать с него нельзя.
+func replicaState(db *sql.DB) (replicaStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var st replicaStatus
+	err := db.QueryRowContext(ctx, replicaStatusQuery).Scan(&st.inRecovery, &st.streaming, &st.caughtUp, &st.replayAge)
+	return st, err
+}
+
+func (set *replicaSet) close() {
+	close(set.stop)
+	for _, r := range set.replicas {
+		r.db.Close()
+	}
+}
+
+// readOnlyKey - ключ контекста запроса, который только читает.
+type readOnlyKey struct{}
+
+// withReadOnly - помечает запрос как только читающий: его чтения могут идти на реплики.
+func withReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+func isReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
@@ -0,0 +1,65 @@
+package gotest
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestReplicaSetSkipsLaggingAndDownReplicas(t *testing.T) {
+	set := &replicaSet{maxLag: time.Second, replicas: []*replica{
+		{name: "replica 1", healthy: true},
+		{name: "replica 2", healthy: true, lag: 2 * time.Second},
+		{name: "replica 3", healthy: false},
+		{name: "replica 4", healthy: true, lag: time.Second},
+	}}
+	seen := map[string]int{}
+	for i := 0; i < 8; i++ {
+		seen[set.pick().name]++
+	}
+	if len(seen) != 2 || seen["replica 1"] != 4 || seen["replica 4"] != 4 {
+		t.Errorf("reads spread as %v, want replicas 1 and 4 in turn", seen)
+	}
+
+	set.markDown(set.replicas[0])
+	set.markDown(set.replicas[3])
+	if r := set.pick(); r != nil {
+		t.Errorf("picked %s with no usable replicas", r.name)
+	}
+}
+
+func TestReadFromReplicasOnlyWithReplicas(t *testing.T) {
+	db := &SingletonDB{}
+	if db.ReadFromReplicas().(*SingletonDB).usesReplicas() {
+		t.Error("reads routed to replicas that are not configured")
+	}
+	db.replicas = &replicaSet{}
+	if db.usesReplicas() {
+		t.Error("primary connection reads from replicas")
+	}
+	if !db.ReadFromReplicas().(*SingletonDB).usesReplicas() {
+		t.Error("read-only connection does not read from replicas")
+	}
+}
+
+func TestReplicaStatusLag(t *testing.T) {
+	age := func(seconds float64) sql.NullFloat64 { return sql.NullFloat64{Float64: seconds, Valid: true} }
+	tests := []struct {
+		name      string
+		status    replicaStatus
+		wantLag   time.Duration
+		wantKnown bool
+	}{
+		{"streaming and caught up on idle primary", replicaStatus{streaming: true, caughtUp: true, replayAge: age(600)}, 0, true},
+		{"streaming and replaying", replicaStatus{streaming: true, replayAge: age(2)}, 2 * time.Second, true},
+		{"streaming before first transaction", replicaStatus{streaming: true}, 0, true},
+		{"disconnected but caught up", replicaStatus{caughtUp: true, replayAge: age(600)}, 600 * time.Second, true},
+		{"disconnected before first transaction", replicaStatus{caughtUp: true}, 0, false},
+	}
+	for _, tt := range tests {
+		lag, known := tt.status.lag()
+		if lag != tt.wantLag || known != tt.wantKnown {
+			t.Errorf("%s: lag = %v, %v, want %v, %v", tt.name, lag, known, tt.wantLag, tt.wantKnown)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// retry.go
+package gotest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PoolConfig - настройки пула соединений с Postgres. Нулевое значение поля
+// означает "без ограничения", как в database/sql.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// DefaultPoolConfig - 20 соединений, из них 10 простаивающих; соединение живет
+// не дольше 30 минут и закрывается после 5 минут простоя, чтобы пул
+// не держал соединения, оборванные балансировщиком или перезапуском Postgres.
+var DefaultPoolConfig = PoolConfig{
+	MaxOpenConns:    20,
+	MaxIdleConns:    10,
+	ConnMaxLifetime: 30 * time.Minute,
+	ConnMaxIdleTime: 5 * time.Minute,
+}
+
+// ParsePoolConfig - настройки пула из строк, например переменных окружения.
+// Пустая строка оставляет значение из DefaultPoolConfig.
+func ParsePoolConfig(maxOpen, maxIdle, maxLifetime, maxIdleTime string) (PoolConfig, error) {
+	config := DefaultPoolConfig
+	for _, v := range []struct {
+		value  string
+		target *int
+	}{{maxOpen, &config.MaxOpenConns}, {maxIdle, &config.MaxIdleConns}} {
+		if v.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v.value)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid connection count %q", v.value)
+		}
+		*v.target = n
+	}
+	for _, v := range []struct {
+		value  string
+		target *time.Duration
+	}{{maxLifetime, &config.ConnMaxLifetime}, {maxIdleTime, &config.ConnMaxIdleTime}} {
+		if v.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v.value)
+		if err != nil || d < 0 {
+			return config, fmt.Errorf("invalid connection lifetime %q", v.value)
+		}
+		*v.target = d
+	}
+	return config, nil
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
+
+// ConfigurePool - применяет настройки пула к primary и репликам.
+func (s *SingletonDB) ConfigurePool(config PoolConfig) {
+	s.pool = config
+	if s.db != nil {
+		config.apply(s.db)
+	}
+	if s.replicas != nil {
+		for _, r := range s.replicas.replicas {
+			config.apply(r.db)
+		}
+	}
+}
+
+// retryableCodes - коды ошибок Postgres, после которых транзакцию можно повторить
+// целиком: она откачена, а причина временная.
+var retryableCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// IsRetryable - временная ли ошибка: конфликт сериализации, взаимная блокировка,
+// перезапуск сервера или обрыв соединения. Остальные ошибки (нарушение ограничений,
+// неверные данные, синтаксис) постоянные - повтор даст тот же результат.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Класс 08 - ошибки соединения
+		return retryableCodes[pqErr.Code] || pqErr.Code.Class() == "08"
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// RetryPolicy - сколько раз и с какими паузами повторять транзакцию.
+type RetryPolicy struct {
+	MaxAttempts int
+	// Пауза растет от MinBackoff вдвое с каждой попыткой до MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy - до 4 попыток с паузами 20ms, 40ms, 80ms (со случайным разбросом).
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, MinBackoff: 20 * time.Millisecond, MaxBackoff: time.Second}
+
+// backoff - пауза после attempt неудачных попыток, со случайным разбросом от половины до полной.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MinBackoff << uint(attempt)
+	if delay <= 0 || delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// errCommitUnknown - коммит не подтвержден из-за обрыва соединения: транзакция
+// могла как примениться, так и нет, поэтому ее нельзя повторять.
+var errCommitUnknown = errors.New("commit outcome unknown")
+
+// retryTx - выполняет fn в транзакции организации и коммитит ее. Если транзакция
+// не удалась из-за временной ошибки (см. IsRetryable), она повторяется целиком
+// с паузой по политике s.retry, поэтому fn не должна иметь побочных эффектов вне tx.
+func (s *SingletonDB) retryTx(fn func(tx *sql.Tx) error) error {
+	policy := s.retry
+	if policy.MaxAttempts <= 0 {
+		policy = DefaultRetryPolicy
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(fn)
+		if err == nil || attempt >= policy.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+		delay := policy.backoff(attempt - 1)
+		log.Printf("Retrying transaction in %v (attempt %d/%d): %v", delay, attempt+1, policy.MaxAttempts, err)
+		time.Sleep(delay)
+	}
+}
+
+func (s *SingletonDB) runTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			// Сервер ответил ошибкой и откатил транзакцию
+			return fmt.Errorf("error committing transaction: %w", err)
+		}
+		return fmt.Errorf("error committing transaction: %v (%w)", err, errCommitUnknown)
+	}
+	return nil
+}
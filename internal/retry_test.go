@@ -0,0 +1,65 @@
+package gotest
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{fmt.Errorf("error updating goods: %w", &pq.Error{Code: "40001"}), true},
+		{fmt.Errorf("error beginning transaction: %w", driver.ErrBadConn), true},
+		{fmt.Errorf("error inserting goods: %w", io.ErrUnexpectedEOF), true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{&pq.Error{Code: "23505"}, false},
+		{&pq.Error{Code: "22P02"}, false},
+		{&FieldError{Field: "color", Message: "is required"}, false},
+		{fmt.Errorf("error committing transaction: %v (%w)", io.EOF, errCommitUnknown), false},
+		{errors.New("boom"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestParsePoolConfig(t *testing.T) {
+	config, err := ParsePoolConfig("50", "", "1h", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultPoolConfig
+	want.MaxOpenConns, want.ConnMaxLifetime, want.ConnMaxIdleTime = 50, time.Hour, 0
+	if config != want {
+		t.Errorf("ParsePoolConfig = %+v, want %+v", config, want)
+	}
+	for _, bad := range [][4]string{{"-1", "", "", ""}, {"", "x", "", ""}, {"", "", "soon", ""}} {
+		if _, err := ParsePoolConfig(bad[0], bad[1], bad[2], bad[3]); err == nil {
+			t.Errorf("ParsePoolConfig(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	for attempt := 0; attempt < 64; attempt++ {
+		if delay := DefaultRetryPolicy.backoff(attempt); delay <= 0 || delay > DefaultRetryPolicy.MaxBackoff {
+			t.Fatalf("backoff(%d) = %v", attempt, delay)
+		}
+	}
+}
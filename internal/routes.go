@@ -0,0 +1,70 @@
+// routes.go
+package gotest
+
+import "net/http"
+
+// Route - маршрут API. Scope - требуемое право; пустой Scope - маршрут открыт
+// для RequireScope (страницы интерфейса проверяют сессию сами, см. uiSession).
+type Route struct {
+	Path    string
+	Method  string
+	Scope   string
+	Handler http.HandlerFunc
+}
+
+// Routes - все маршруты приложения. По этому списку регистрируются хендлеры
+// в cmd/web и сверяется спецификация OpenAPI.
+func (h *Handler) Routes() []Route {
+	return []Route{
+		{"/", http.MethodGet, "", h.Main},
+		{"/ui/login", http.MethodGet, "", h.UILogin},
+		{"/ui/session", http.MethodPost, "", h.UISession},
+		{"/ui/logout", http.MethodPost, "", h.UILogout},
+		{"/ui/goods", http.MethodGet, "", h.UIGoods},
+		{"/ui/good/new", http.MethodGet, "", h.UINewGood},
+		{"/ui/good/create", http.MethodPost, "", h.UICreateGood},
+		{"/ui/good/edit", http.MethodGet, "", h.UIEditGood},
+		{"/ui/good/save", http.MethodPost, "", h.UISaveGood},
+		{"/ui/good/remove", http.MethodPost, "", h.UIRemoveGood},
+		{"/ui/good/priority", http.MethodPost, "", h.UIGoodPriority},
+		{"/static/", http.MethodGet, "", h.Static},
+		{"/openapi.json", http.MethodGet, "", h.OpenAPI},
+		{"/docs", http.MethodGet, "", h.Docs},
+		{"/good/get", http.MethodGet, ScopeRead, h.GET},
+		{"/good/create", http.MethodPost, ScopeWrite, h.POST},
+		{"/good/update", http.MethodPatch, ScopeWrite, h.PATCH},
+		{"/good/remove", http.MethodDelete, ScopeWrite, h.DELETE},
+		{"/good/import", http.MethodPost, ScopeWrite, h.Import},
+		{"/good/export", http.MethodGet, ScopeRead, h.Export},
+		{"/good/search", http.MethodGet, ScopeRead, h.Search},
+		{"/good/stream", http.MethodGet, ScopeRead, h.Stream},
+		{"/good/history", http.MethodGet, ScopeRead, h.History},
+		{"/good/revert", http.MethodPost, ScopeWrite, h.Revert},
+		{"/good/tag", http.MethodPost, ScopeWrite, h.TagGood},
+		{"/good/untag", http.MethodDelete, ScopeWrite, h.UntagGood},
+		{"/field/list", http.MethodGet, ScopeRead, h.ListFields},
+		{"/field/set", http.MethodPut, ScopeAdmin, h.SetFields},
+		{"/tag/create", http.MethodPost, ScopeWrite, h.CreateTag},
+		{"/tag/list", http.MethodGet, ScopeRead, h.ListTags},
+		{"/tag/update", http.MethodPatch, ScopeWrite, h.UpdateTag},
+		{"/tag/remove", http.MethodDelete, ScopeWrite, h.DeleteTag},
+		{"/graphql", http.MethodPost, ScopeRead, h.GraphQL},
+		{"/project/create", http.MethodPost, ScopeAdmin, h.CreateProject},
+		{"/project/update", http.MethodPatch, ScopeAdmin, h.UpdateProject},
+		{"/project/remove", http.MethodDelete, ScopeAdmin, h.DeleteProject},
+		{"/project/export", http.MethodGet, ScopeRead, h.ExportProjects},
+		{"/apikey/create", http.MethodPost, ScopeAdmin, h.CreateAPIKey},
+		{"/apikey/list", http.MethodGet, ScopeAdmin, h.ListAPIKeys},
+		{"/apikey/revoke", http.MethodDelete, ScopeAdmin, h.RevokeAPIKey},
+		{"/webhook/create", http.MethodPost, ScopeAdmin, h.CreateWebhook},
+		{"/webhook/list", http.MethodGet, ScopeAdmin, h.ListWebhooks},
+		{"/webhook/remove", http.MethodDelete, ScopeAdmin, h.DeleteWebhook},
+		{"/webhook/deliveries", http.MethodGet, ScopeAdmin, h.WebhookDeliveries},
+		{"/webhook/redeliver", http.MethodPost, ScopeAdmin, h.RedeliverWebhook},
+		{"/job/get", http.MethodGet, ScopeRead, h.GetJob},
+		{"/job/result", http.MethodGet, ScopeRead, h.JobResult},
+		{"/search/reindex", http.MethodPost, ScopeAdmin, h.ReindexSearch},
+		{"/org/create", http.MethodPost, ScopeAdmin, h.CreateOrganisation},
+		{"/org/list", http.MethodGet, ScopeAdmin, h.ListOrganisations},
+	}
+}
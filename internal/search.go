@@ -0,0 +1,250 @@
+// search.go
+package gotest
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// DefaultSearchConfig - конфигурация полнотекстового поиска по умолчанию.
+// В russian русские слова приводятся к основе русским стеммером, а латинские -
+// английским, поэтому она подходит для данных на обоих языках.
+const DefaultSearchConfig = "russian"
+
+// Ограничения страницы результатов /good/search.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Маркеры подсветки в ts_headline. Текст экранируется уже после Postgres,
+// поэтому маркеры не должны быть похожи на HTML.
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+// SearchFilter - параметры полнотекстового поиска товаров.
+type SearchFilter struct {
+	Query     string
+	ProjectID int
+	// ProjectIDs - если не nil, ищется только в этих проектах.
+	ProjectIDs []int
+	Limit      int
+	Offset     int
+}
+
+// SearchHit - найденный товар. Snippet - HTML с найденными словами в <mark>.
+type SearchHit struct {
+	Good    Good    `json:"good"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchResult - страница результатов и общее число найденных товаров.
+type SearchResult struct {
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// CreateSearchIndex - добавляет в goods генерируемую колонку search (tsvector по name и description)
+// и GIN-индекс по ней. Если колонка построена с другой конфигурацией, она пересоздается.
+func (s *SingletonDB) CreateSearchIndex(config string) error {
+	if config == "" {
+		config = DefaultSearchConfig
+	}
+	// Конфигурация подставляется в DDL, поэтому сначала проверяем, что она существует
+	var name string
+	if err := s.db.QueryRow("SELECT $1::regconfig::text", config).Scan(&name); err != nil {
+		return fmt.Errorf("unknown text search config %q: %v", config, err)
+	}
+
+	var expression sql.NullString
+	err := s.db.QueryRow(`
+	SELECT pg_get_expr(d.adbin, d.adrelid)
+	FROM pg_attribute a JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE a.attrelid = 'goods'::regclass AND a.attname = 'search' AND NOT a.attisdropped`).Scan(&expression)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error checking search column: %v", err)
+	}
+	literal := pq.QuoteLiteral(name)
+	if expression.Valid && !strings.Contains(expression.String, literal+"::regconfig") {
+		log.Printf("Text search config changed to %s, rebuilding goods.search", name)
+		if _, err := s.db.Exec("ALTER TABLE goods DROP COLUMN search"); err != nil {
+			return fmt.Errorf("error dropping search column: %v", err)
+		}
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf(`
+	ALTER TABLE goods ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector(%[1]s::regconfig, coalesce(name, '')), 'A') ||
+		setweight(to_tsvector(%[1]s::regconfig, coalesce(description, '')), 'B')
+	) STORED;
+	CREATE INDEX IF NOT EXISTS goods_search_index ON goods USING GIN (search);
+	`, literal))
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании поискового индекса: %v", err)
+	}
+	s.searchConfig = name
+	return nil
+}
+
+// ReindexSearch - перестраивает поисковый индекс, не блокируя запись в goods.
+// Индекс общий для всех организаций, поэтому запрос идет от владельца таблиц.
+func (s *SingletonDB) ReindexSearch() error {
+	if _, err := s.db.Exec("REINDEX INDEX CONCURRENTLY goods_search_index"); err != nil {
+		return fmt.Errorf("error reindexing search: %v", err)
+	}
+	return nil
+}
+
+// SearchGoods - ищет товары по словам из name и description, лучшие совпадения первыми.
+// Запрос понимает синтаксис websearch: "точная фраза", OR, -исключение.
+func (s *SingletonDB) SearchGoods(filter SearchFilter) (*SearchResult, error) {
+	config := s.searchConfig
+	if config == "" {
+		config = DefaultSearchConfig
+	}
+	where := "g.search @@ q.query"
+	args := []interface{}{config, filter.Query}
+	if filter.ProjectID != 0 {
+		args = append(args, filter.ProjectID)
+		where += fmt.Sprintf(" AND g.project_id = $%d", len(args))
+	}
+	if filter.ProjectIDs != nil {
+		args = append(args, pq.Array(filter.ProjectIDs))
+		where += fmt.Sprintf(" AND g.project_id = ANY($%d)", len(args))
+	}
+	from := "FROM goods g, websearch_to_tsquery($1::regconfig, $2) AS q(query) WHERE " + where
+
+	tx, err := s.readTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &SearchResult{Hits: []SearchHit{}}
+	if err := tx.QueryRow("SELECT count(*) "+from, args...).Scan(&result.Total); err != nil {
+		return nil, fmt.Errorf("error counting search results: %v", err)
+	}
+	if result.Total == 0 || filter.Offset >= result.Total {
+		return result, nil
+	}
+
+	options := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxWords=30, MinWords=10, MaxFragments=2`, headlineStart, headlineStop)
+	args = append(args, options, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+	SELECT g.id, g.project_id, g.name, g.description, g.priority, g.removed, g.created_at, g.fields, %s,
+		ts_rank_cd(g.search, q.query),
+		ts_headline($1::regconfig, g.name || ' — ' || g.description, q.query, $%d)
+	%s
+	ORDER BY 10 DESC, g.id
+	LIMIT $%d OFFSET $%d`, goodTagsColumn("g"), len(args)-2, from, len(args)-1, len(args))
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching goods: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hit SearchHit
+		var snippet string
+		err := rows.Scan(&hit.Good.ID, &hit.Good.ProjectID, &hit.Good.Name, &hit.Good.Description,
+			&hit.Good.Priority, &hit.Good.Removed, &hit.Good.CreatedAt, &hit.Good.Fields, pq.Array(&hit.Good.Tags), &hit.Rank, &snippet)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning search result: %v", err)
+		}
+		hit.Snippet = highlight(snippet)
+		result.Hits = append(result.Hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading search results: %v", err)
+	}
+	return result, nil
+}
+
+// highlight - экранирует фрагмент и заменяет маркеры ts_headline на <mark>.
+// Маркеры могут встретиться и в тексте товара, поэтому теги всегда парные:
+// лишние маркеры отбрасываются, незакрытый <mark> закрывается в конце.
+func highlight(snippet string) string {
+	var b strings.Builder
+	open := false
+	for _, part := range strings.SplitAfter(snippet, headlineStart) {
+		start := strings.HasSuffix(part, headlineStart)
+		part = strings.TrimSuffix(part, headlineStart)
+		for i, text := range strings.Split(part, headlineStop) {
+			if i > 0 && open {
+				b.WriteString("</mark>")
+				open = false
+			}
+			b.WriteString(html.EscapeString(text))
+		}
+		if start && !open {
+			b.WriteString("<mark>")
+			open = true
+		}
+	}
+	if open {
+		b.WriteString("</mark>")
+	}
+	return b.String()
+}
+
+// Search - GET /good/search?q=...&project_id=1&limit=20&offset=0
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	filter := SearchFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Limit: defaultSearchLimit,
+	}
+	if filter.Query == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if p := query.Get("project_id"); p != "" {
+		if filter.ProjectID, err = strconv.Atoi(p); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > maxSearchLimit {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		filter.ProjectIDs = principal.ProjectIDs(ScopeRead)
+	}
+
+	result, err := h.store(r.Context()).SearchGoods(filter)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"query":  filter.Query,
+		"total":  result.Total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"hits":   result.Hits,
+	})
+}
@@ -0,0 +1,88 @@
+package gotest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		want    string
+	}{
+		{"plain", "red apple", "red apple"},
+		{"markers", "red \x02apple\x03 and \x02pear\x03", "red <mark>apple</mark> and <mark>pear</mark>"},
+		{"html escaped", "<b>\x02apple\x03</b> & \"pear\"", "&lt;b&gt;<mark>apple</mark>&lt;/b&gt; &amp; &#34;pear&#34;"},
+		{"script in match", "\x02<script>alert(1)</script>\x03", "<mark>&lt;script&gt;alert(1)&lt;/script&gt;</mark>"},
+		{"stray stop", "a\x03b \x02c\x03", "ab <mark>c</mark>"},
+		{"nested start", "\x02a\x02b\x03", "<mark>ab</mark>"},
+		{"unclosed", "\x02apple", "<mark>apple</mark>"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.snippet); got != tt.want {
+			t.Errorf("%s: highlight(%q) = %q, want %q", tt.name, tt.snippet, got, tt.want)
+		}
+	}
+}
+
+// searchDB - запоминает фильтр последнего поиска.
+type searchDB struct {
+	DBHandler
+	filter *SearchFilter
+}
+
+func (d *searchDB) ForTenant(tenantID int) DBHandler { return d }
+func (d *searchDB) ReadFromReplicas() DBHandler      { return d }
+
+func (d *searchDB) SearchGoods(filter SearchFilter) (*SearchResult, error) {
+	d.filter = &filter
+	return &SearchResult{Hits: []SearchHit{}}, nil
+}
+
+func TestSearchValidatesParameters(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   int
+		limit  int
+		offset int
+	}{
+		{"", http.StatusBadRequest, 0, 0},
+		{"q=", http.StatusBadRequest, 0, 0},
+		{"q=%20%20", http.StatusBadRequest, 0, 0},
+		{"q=apple", http.StatusOK, defaultSearchLimit, 0},
+		{"q=apple&limit=1&offset=40", http.StatusOK, 1, 40},
+		{"q=apple&limit=100", http.StatusOK, maxSearchLimit, 0},
+		{"q=apple&limit=0", http.StatusBadRequest, 0, 0},
+		{"q=apple&limit=101", http.StatusBadRequest, 0, 0},
+		{"q=apple&limit=x", http.StatusBadRequest, 0, 0},
+		{"q=apple&offset=-1", http.StatusBadRequest, 0, 0},
+		{"q=apple&offset=x", http.StatusBadRequest, 0, 0},
+		{"q=apple&project_id=x", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		db := &searchDB{}
+		rec := httptest.NewRecorder()
+		NewHandler(db).Search(rec, httptest.NewRequest(http.MethodGet, "/good/search?"+tt.query, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%q: status = %d, want %d", tt.query, rec.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			if db.filter != nil {
+				t.Errorf("%q: searched with invalid parameters", tt.query)
+			}
+			continue
+		}
+		if db.filter.Limit != tt.limit || db.filter.Offset != tt.offset {
+			t.Errorf("%q: limit %d offset %d, want %d and %d", tt.query, db.filter.Limit, db.filter.Offset, tt.limit, tt.offset)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewHandler(&searchDB{}).Search(rec, httptest.NewRequest(http.MethodPost, "/good/search?q=apple", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,89 @@
+// startup.go
+package gotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultStartupTimeout - сколько по умолчанию ждать Postgres и Redis при запуске.
+const DefaultStartupTimeout = time.Minute
+
+// Паузы между проверками зависимости при запуске: от startupMinBackoff вдвое до startupMaxBackoff.
+const (
+	startupMinBackoff = 250 * time.Millisecond
+	startupMaxBackoff = 5 * time.Second
+)
+
+// Dependency - внешний сервис, без которого приложение не запускается.
+// Check проверяет его на уже созданном клиенте, чтобы после ожидания
+// приложение работало с тем же подключением.
+type Dependency struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Dependencies - Postgres и Redis по адресам, переданным в InitDB.
+func (s *SingletonDB) Dependencies() []Dependency {
+	return []Dependency{
+		{Name: "Postgres", Check: func(ctx context.Context) error {
+			return s.db.PingContext(ctx)
+		}},
+		{Name: "Redis", Check: func(ctx context.Context) error {
+			return s.redisClient.WithContext(ctx).Ping().Err()
+		}},
+	}
+}
+
+// WaitForDependencies - дожидается всех зависимостей, проверяя каждую с растущей
+// паузой, пока не истечет ctx. Зависимости ожидаются одновременно; ошибка
+// перечисляет все, которые так и не ответили.
+func WaitForDependencies(ctx context.Context, deps ...Dependency) error {
+	errs := make([]error, len(deps))
+	var wg sync.WaitGroup
+	for i, dep := range deps {
+		wg.Add(1)
+		go func(i int, dep Dependency) {
+			defer wg.Done()
+			errs[i] = waitFor(ctx, dep)
+		}(i, dep)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// waitFor - проверяет dep, пока она не ответит или не истечет ctx, и пишет ход ожидания в лог.
+func waitFor(ctx context.Context, dep Dependency) error {
+	start := time.Now()
+	delay := startupMinBackoff
+	for attempt := 1; ; attempt++ {
+		// Проверка не должна занять все оставшееся время: зависшее подключение - тоже повод повторить
+		checkCtx, cancel := context.WithTimeout(ctx, startupMaxBackoff)
+		err := dep.Check(checkCtx)
+		cancel()
+		if err == nil {
+			log.Printf("%s доступен (попытка %d, %v)", dep.Name, attempt, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		// Случайный разброс, чтобы перезапущенные вместе экземпляры не стучались одновременно
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return fmt.Errorf("%s недоступен после %d попыток за %v: %v", dep.Name, attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+		log.Printf("Ожидание %s: попытка %d не удалась (%v), повтор через %v", dep.Name, attempt, err, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s недоступен после %d попыток: %v", dep.Name, attempt, err)
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > startupMaxBackoff {
+			delay = startupMaxBackoff
+		}
+	}
+}
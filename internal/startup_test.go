@@ -0,0 +1,46 @@
+package gotest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForDependenciesRetries(t *testing.T) {
+	var calls int32
+	dep := Dependency{Name: "Postgres", Check: func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := WaitForDependencies(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("Check called %d times, want 3", calls)
+	}
+}
+
+func TestWaitForDependenciesDeadline(t *testing.T) {
+	ok := Dependency{Name: "Postgres", Check: func(ctx context.Context) error { return nil }}
+	down := Dependency{Name: "Redis", Check: func(ctx context.Context) error { return errors.New("connection refused") }}
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := WaitForDependencies(ctx, ok, down)
+	if err == nil {
+		t.Fatal("expected an error for Redis")
+	}
+	if !strings.Contains(err.Error(), "Redis") || strings.Contains(err.Error(), "Postgres") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("waited %v past a 600ms deadline", elapsed)
+	}
+}
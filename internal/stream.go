@@ -0,0 +1,141 @@
+// stream.go
+package gotest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// streamHeartbeat - как часто отправлять комментарий, чтобы прокси не закрыли простаивающее соединение.
+const streamHeartbeat = 15 * time.Second
+
+// streamRetry - через сколько миллисекунд браузер переподключается после обрыва.
+const streamRetry = 3000
+
+// Stream - GET /good/stream?project_id=1, поток Server-Sent Events с изменениями товаров.
+// После переподключения браузер присылает Last-Event-ID, и пропущенные события досылаются из журнала.
+// Если их в журнале уже нет, вместо них приходит событие reset: клиент должен перечитать товары.
+func (h *Handler) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := 0
+	if value := r.URL.Query().Get("project_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		projectID = id
+	}
+	var lastID int64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Подписываемся до чтения журнала, чтобы не пропустить события между ними
+	ctx := r.Context()
+	events, err := h.store(r.Context()).SubscribeGoodEvents(ctx)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	var missed []GoodEvent
+	expired := false
+	if lastID > 0 {
+		missed, err = h.store(r.Context()).GoodEventsSince(lastID)
+		if errors.Is(err, ErrEventsExpired) {
+			expired, err = true, nil
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+
+	send := func(event GoodEvent) error {
+		// Событие из журнала могло прийти и по подписке
+		if event.ID <= lastID {
+			return nil
+		}
+		lastID = event.ID
+		if projectID != 0 && event.Good.ProjectID != projectID {
+			return nil
+		}
+		if !visibleProject(ctx, event.Good.ProjectID) {
+			return nil
+		}
+		data, err := json.Marshal(event.Good)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		return err
+	}
+
+	if expired {
+		// Досылать неполную историю нельзя - клиент решил бы, что ничего не пропустил.
+		// Продолжаем с последнего события журнала, его ID становится новым Last-Event-ID
+		if len(missed) > 0 {
+			lastID = missed[len(missed)-1].ID
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", lastID); err != nil {
+			return
+		}
+		missed = nil
+	}
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				log.Println("Error sending good event:", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package gotest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// eventLog - журнал событий в памяти; подписка сразу закрывается, чтобы Stream
+// вернулся после досылки.
+type eventLog struct {
+	DBHandler
+	events  []GoodEvent
+	expired bool
+}
+
+func (l *eventLog) ForTenant(tenantID int) DBHandler {
+	return l
+}
+
+func (l *eventLog) SubscribeGoodEvents(ctx context.Context) (<-chan GoodEvent, error) {
+	events := make(chan GoodEvent)
+	close(events)
+	return events, nil
+}
+
+func (l *eventLog) GoodEventsSince(lastID int64) ([]GoodEvent, error) {
+	var events []GoodEvent
+	for _, event := range l.events {
+		if event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+	if l.expired {
+		return events, ErrEventsExpired
+	}
+	return events, nil
+}
+
+func streamAfter(t *testing.T, log *eventLog, lastEventID string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/good/stream?project_id=1", nil)
+	req.Header.Set("Last-Event-ID", lastEventID)
+	rec := httptest.NewRecorder()
+	NewHandler(log).Stream(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestStreamReplaysMissedEvents(t *testing.T) {
+	log := &eventLog{events: []GoodEvent{
+		{ID: 4, Type: GoodCreated, Good: Good{ID: 1, ProjectID: 1}},
+		{ID: 5, Type: GoodCreated, Good: Good{ID: 2, ProjectID: 2}},
+		{ID: 6, Type: GoodUpdated, Good: Good{ID: 1, ProjectID: 1}},
+	}}
+	body := streamAfter(t, log, "4")
+	if strings.Contains(body, "id: 4\n") || strings.Contains(body, "id: 5\n") || !strings.Contains(body, "id: 6\nevent: updated\n") {
+		t.Errorf("stream:\n%s", body)
+	}
+	if strings.Contains(body, "event: reset") {
+		t.Errorf("complete history produced a reset:\n%s", body)
+	}
+}
+
+func TestStreamResetsWhenHistoryExpired(t *testing.T) {
+	log := &eventLog{expired: true, events: []GoodEvent{
+		{ID: 900, Type: GoodCreated, Good: Good{ID: 1, ProjectID: 1}},
+		{ID: 950, Type: GoodUpdated, Good: Good{ID: 1, ProjectID: 1}},
+	}}
+	body := streamAfter(t, log, "3")
+	if !strings.Contains(body, "id: 950\nevent: reset\n") {
+		t.Errorf("no reset event after expired history:\n%s", body)
+	}
+	if strings.Contains(body, "event: created") || strings.Contains(body, "event: updated") {
+		t.Errorf("partial history replayed after a reset:\n%s", body)
+	}
+}
@@ -0,0 +1,524 @@
+// tags.go
+package gotest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// maxTagLength - длина колонки tags.name.
+const maxTagLength = 64
+
+// ErrTagExists - в проекте уже есть тег с таким именем.
+var ErrTagExists = errors.New("tag already exists")
+
+// ErrTagNotFound - в проекте нет тега с таким именем.
+var ErrTagNotFound = errors.New("tag not found")
+
+// Tag - метка товаров проекта. Имя уникально в пределах проекта.
+type Tag struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"project_id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// goodTagsColumn - выражение со списком имен тегов товара по алфавиту,
+// для SELECT и RETURNING. table - имя или псевдоним таблицы goods в запросе.
+func goodTagsColumn(table string) string {
+	return "COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM good_tags gt JOIN tags t ON t.id = gt.tag_id WHERE gt.good_id = " + table + ".id), '{}')"
+}
+
+// CreateTagsTable - создает таблицы тегов и связей товаров с тегами.
+func (s *SingletonDB) CreateTagsTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		project_id INTEGER NOT NULL REFERENCES projects(id),
+		name VARCHAR(64) NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE (project_id, name)
+	);
+	CREATE TABLE IF NOT EXISTS good_tags (
+		good_id INTEGER NOT NULL REFERENCES goods(id) ON DELETE CASCADE,
+		tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (good_id, tag_id)
+	);
+	CREATE INDEX IF NOT EXISTS good_tags_tag_id_index ON good_tags (tag_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы tags: %v", err)
+	}
+	log.Println("Таблица tags успешно создана")
+	return nil
+}
+
+// isUniqueViolation - ошибка нарушения уникальности в Postgres.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// CreateTag - создает тег в проекте. Если имя занято, возвращает ErrTagExists.
+func (s *SingletonDB) CreateTag(projectID int, name string) (*Tag, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var tag Tag
+	err = tx.QueryRow("INSERT INTO tags (project_id, name) VALUES ($1, $2) RETURNING id, project_id, name, created_at",
+		projectID, name).Scan(&tag.ID, &tag.ProjectID, &tag.Name, &tag.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrTagExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error inserting tag: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return &tag, nil
+}
+
+// ListTags - теги проекта по имени.
+func (s *SingletonDB) ListTags(projectID int) ([]Tag, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, project_id, name, created_at FROM tags WHERE project_id = $1 ORDER BY name", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %v", err)
+	}
+	defer rows.Close()
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.ProjectID, &tag.Name, &tag.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning tag: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// UpdateTag - переименовывает тег. Возвращает nil, если тега нет, и ErrTagExists, если имя занято.
+func (s *SingletonDB) UpdateTag(projectID int, id int, name string) (*Tag, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var tag Tag
+	err = tx.QueryRow("UPDATE tags SET name = $1 WHERE id = $2 AND project_id = $3 RETURNING id, project_id, name, created_at",
+		name, id, projectID).Scan(&tag.ID, &tag.ProjectID, &tag.Name, &tag.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrTagExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating tag: %v", err)
+	}
+	// Новое имя тега меняет все товары с ним
+	rows, err := tx.Query("SELECT good_id FROM good_tags WHERE tag_id = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tagged goods: %v", err)
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tagged goods: %v", err)
+	}
+	if err := recordGoodEvents(tx, GoodUpdated, ids); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	// Имена тегов хранятся в кеше товаров
+	if err := s.updateGoodsCache(); err != nil {
+		fmt.Println("Error updating goods cache:", err)
+	}
+	return &tag, nil
+}
+
+// DeleteTag - удаляет тег и снимает его со всех товаров. false - тега не было.
+func (s *SingletonDB) DeleteTag(projectID int, id int) (bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Связи удалятся каскадом вместе с тегом, поэтому товары с ним читаем заранее
+	rows, err := tx.Query("SELECT gt.good_id FROM good_tags gt JOIN tags t ON t.id = gt.tag_id WHERE t.id = $1 AND t.project_id = $2", id, projectID)
+	if err != nil {
+		return false, fmt.Errorf("error reading tagged goods: %v", err)
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return false, fmt.Errorf("error reading tagged goods: %v", err)
+	}
+	result, err := tx.Exec("DELETE FROM tags WHERE id = $1 AND project_id = $2", id, projectID)
+	if err != nil {
+		return false, fmt.Errorf("error deleting tag: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if err := recordGoodEvents(tx, GoodUpdated, ids); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	if err := s.updateGoodsCache(); err != nil {
+		fmt.Println("Error updating goods cache:", err)
+	}
+	return true, nil
+}
+
+// TagGood - вешает на товар тег проекта с именем tag. Повторная привязка ничего не меняет.
+// Если тега нет, возвращает ErrTagNotFound.
+func (s *SingletonDB) TagGood(projectID int, id int, tag string) (*Good, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT INTO good_tags (good_id, tag_id)
+	SELECT g.id, t.id FROM goods g JOIN tags t ON t.project_id = g.project_id
+	WHERE g.id = $1 AND g.project_id = $2 AND t.name = $3
+	ON CONFLICT DO NOTHING`, id, projectID, tag)
+	if err != nil {
+		return nil, fmt.Errorf("error tagging good: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		// Либо тег уже висит на товаре, либо такого тега нет
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tags WHERE project_id = $1 AND name = $2)", projectID, tag).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("error checking tag: %v", err)
+		}
+		if !exists {
+			return nil, ErrTagNotFound
+		}
+	}
+	return s.refreshGood(tx, projectID, id)
+}
+
+// UntagGood - снимает с товара тег с именем tag.
+func (s *SingletonDB) UntagGood(projectID int, id int, tag string) (*Good, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	DELETE FROM good_tags gt USING tags t
+	WHERE gt.tag_id = t.id AND gt.good_id = $1 AND t.project_id = $2 AND t.name = $3`, id, projectID, tag)
+	if err != nil {
+		return nil, fmt.Errorf("error untagging good: %v", err)
+	}
+	return s.refreshGood(tx, projectID, id)
+}
+
+// refreshGood - перечитывает товар после изменения тегов, записывает событие
+// и коммитит tx, затем обновляет кеш.
+func (s *SingletonDB) refreshGood(tx *sql.Tx, projectID int, id int) (*Good, error) {
+	good, err := getGood(tx, projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	if good == nil {
+		return nil, nil
+	}
+	if err := recordGoodEvent(tx, GoodUpdated, *good); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	s.cacheGood(*good)
+	return good, nil
+}
+
+// validTagName - имя тега без пробелов по краям, не пустое и не длиннее колонки.
+func validTagName(name string) bool {
+	return name != "" && name == strings.TrimSpace(name) && utf8.RuneCountInString(name) <= maxTagLength
+}
+
+// tagRequest - тело запросов /tag/* и /good/tag, /good/untag.
+type tagRequest struct {
+	ID        string `json:"id"`
+	ProjectID string `json:"projectId"`
+	Name      string `json:"name,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	Removed   bool   `json:"removed,omitempty"`
+}
+
+// decodeTagRequest - разбирает тело; id нужен не всем запросам, поэтому проверяется отдельно.
+func decodeTagRequest(w http.ResponseWriter, r *http.Request, needID bool) (request tagRequest, projectID, id int, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return request, 0, 0, false
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return request, 0, 0, false
+	}
+	if needID {
+		if id, err = strconv.Atoi(request.ID); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return request, 0, 0, false
+		}
+	}
+	return request, projectID, id, true
+}
+
+// CreateTag - POST /tag/create {"projectId": "1", "name": "sale"}
+func (h *Handler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, projectID, _, ok := decodeTagRequest(w, r, false)
+	if !ok {
+		return
+	}
+	if !validTagName(request.Name) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	tag, err := h.store(r.Context()).CreateTag(projectID, request.Name)
+	if err == ErrTagExists {
+		http.Error(w, "Tag already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "tag.create", projectID, tag.ID)
+	writeJSON(w, http.StatusCreated, tag)
+}
+
+// ListTags - GET /tag/list?project_id=1
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, err := strconv.Atoi(r.URL.Query().Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	tags, err := h.store(r.Context()).ListTags(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// UpdateTag - PATCH /tag/update {"projectId": "1", "id": "2", "name": "new"}
+// Новое имя сразу видно на всех товарах с этим тегом.
+func (h *Handler) UpdateTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, projectID, id, ok := decodeTagRequest(w, r, true)
+	if !ok {
+		return
+	}
+	if !validTagName(request.Name) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	tag, err := h.store(r.Context()).UpdateTag(projectID, id, request.Name)
+	if err == ErrTagExists {
+		http.Error(w, "Tag already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if tag == nil {
+		http.NotFound(w, r)
+		return
+	}
+	audit(r.Context(), "tag.update", projectID, id)
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// DeleteTag - DELETE /tag/remove {"projectId": "1", "id": "2"}
+func (h *Handler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, projectID, id, ok := decodeTagRequest(w, r, true)
+	if !ok {
+		return
+	}
+	deleted, err := h.store(r.Context()).DeleteTag(projectID, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	audit(r.Context(), "tag.remove", projectID, id)
+	request.Removed = true
+	writeJSON(w, http.StatusOK, request)
+}
+
+// TagGood - POST /good/tag {"projectId": "1", "id": "2", "tag": "sale"}, отвечает товаром.
+func (h *Handler) TagGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.changeGoodTag(w, r, "good.tag", h.store(r.Context()).TagGood)
+}
+
+// UntagGood - DELETE /good/untag {"projectId": "1", "id": "2", "tag": "sale"}, отвечает товаром.
+func (h *Handler) UntagGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.changeGoodTag(w, r, "good.untag", h.store(r.Context()).UntagGood)
+}
+
+func (h *Handler) changeGoodTag(w http.ResponseWriter, r *http.Request, action string, change func(projectID, id int, tag string) (*Good, error)) {
+	request, projectID, id, ok := decodeTagRequest(w, r, true)
+	if !ok {
+		return
+	}
+	if !validTagName(request.Tag) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	good, err := change(projectID, id, request.Tag)
+	if err == ErrTagNotFound {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if good == nil {
+		http.NotFound(w, r)
+		return
+	}
+	audit(r.Context(), action, projectID, id)
+	writeJSON(w, http.StatusOK, good)
+}
+
+// goodTagFilter - отбор товаров /good/get по тегам: ?tags=a,b&match=any|all.
+type goodTagFilter struct {
+	tags []string
+	all  bool
+}
+
+func parseGoodTagFilter(r *http.Request) (*goodTagFilter, error) {
+	query := r.URL.Query()
+	value := query.Get("tags")
+	if value == "" {
+		return nil, nil
+	}
+	filter := &goodTagFilter{}
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.tags = append(filter.tags, tag)
+		}
+	}
+	if len(filter.tags) == 0 {
+		return nil, errors.New("tags must name at least one tag")
+	}
+	switch query.Get("match") {
+	case "", "any":
+	case "all":
+		filter.all = true
+	default:
+		return nil, errors.New("match must be any or all")
+	}
+	return filter, nil
+}
+
+// matches - есть ли у товара хотя бы один (any) или все (all) теги фильтра.
+func (f *goodTagFilter) matches(good Good) bool {
+	if f == nil {
+		return true
+	}
+	has := make(map[string]bool, len(good.Tags))
+	for _, tag := range good.Tags {
+		has[tag] = true
+	}
+	for _, tag := range f.tags {
+		if has[tag] && !f.all {
+			return true
+		}
+		if !has[tag] && f.all {
+			return false
+		}
+	}
+	return f.all
+}
@@ -0,0 +1,76 @@
+package gotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// tagGoods - проставляет товарам теги напрямую в memoryDB.
+func tagGoods(m *memoryHandler, tags map[int][]string) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	for id, names := range tags {
+		good := m.store.goods[id]
+		good.Tags = names
+		m.store.goods[id] = good
+	}
+}
+
+func TestGetGoodsTagFilter(t *testing.T) {
+	db := newMemoryHandler()
+	project := mustCreateProject(t, db, "tags")
+	apple := mustCreateGood(t, db, project.ID, "apple")
+	pear := mustCreateGood(t, db, project.ID, "pear")
+	plain := mustCreateGood(t, db, project.ID, "plain")
+	tagGoods(db, map[int][]string{
+		apple.ID: {"fruit", "red"},
+		pear.ID:  {"fruit", "green"},
+	})
+	h := NewHandler(db)
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{apple.ID, pear.ID, plain.ID}},
+		{"tags=fruit", []int{apple.ID, pear.ID}},
+		{"tags=red,green", []int{apple.ID, pear.ID}},
+		{"tags=red,%20green%20&match=any", []int{apple.ID, pear.ID}},
+		{"tags=fruit,red&match=all", []int{apple.ID}},
+		{"tags=red,green&match=all", nil},
+		{"tags=unknown", nil},
+		{"tags=red,unknown", []int{apple.ID}},
+		{"tags=red,unknown&match=all", nil},
+		{"match=all", []int{apple.ID, pear.ID, plain.ID}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.GET(rec, httptest.NewRequest(http.MethodGet, "/good/get?"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: status = %d, want 200", tt.query, rec.Code)
+			continue
+		}
+		var data struct{ Goods []Good }
+		if err := json.Unmarshal(rec.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, good := range data.Goods {
+			ids = append(ids, good.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%q: goods %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	for _, query := range []string{"tags=fruit&match=some", "tags=fruit&match=ALL", "tags=,", "tags=%20"} {
+		rec := httptest.NewRecorder()
+		h.GET(rec, httptest.NewRequest(http.MethodGet, "/good/get?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// templates.go
+package gotest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// layoutTemplate - общий макет страниц интерфейса. Остальные шаблоны
+// разбираются поверх него, docs.html самостоятельный и макет не использует.
+const layoutTemplate = "layout.html"
+
+// staticAsset - статический файл, отдаваемый по имени с хешем содержимого.
+type staticAsset struct {
+	content     []byte
+	contentType string
+	etag        string
+}
+
+// uiBundle - разобранные шаблоны и статические файлы одной загрузки.
+type uiBundle struct {
+	pages  map[string]*template.Template
+	assets map[string]staticAsset // имя с хешем -> файл
+	hashed map[string]string      // имя файла -> имя с хешем
+}
+
+// Templates - шаблоны из templates/ и файлы из static/ в fsys.
+// Разбираются один раз при загрузке; с reload - заново на каждый запрос,
+// чтобы при разработке правки на диске были видны без перезапуска.
+type Templates struct {
+	fsys   fs.FS
+	reload bool
+
+	mu     sync.RWMutex
+	bundle *uiBundle
+}
+
+// LoadTemplates - загружает шаблоны и статические файлы из fsys
+// (встроенного ui.Files или os.DirFS("ui")).
+func LoadTemplates(fsys fs.FS, reload bool) (*Templates, error) {
+	t := &Templates{fsys: fsys, reload: reload}
+	bundle, err := loadUIBundle(fsys)
+	if err != nil {
+		return nil, err
+	}
+	t.bundle = bundle
+	return t, nil
+}
+
+func loadUIBundle(fsys fs.FS) (*uiBundle, error) {
+	b := &uiBundle{
+		pages:  make(map[string]*template.Template),
+		assets: make(map[string]staticAsset),
+		hashed: make(map[string]string),
+	}
+
+	// Сначала статика: шаблонам нужны имена с хешами
+	files, err := fs.ReadDir(fsys, "static")
+	if err != nil {
+		return nil, fmt.Errorf("error reading static files: %v", err)
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, "static/"+file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading static file %s: %v", file.Name(), err)
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])[:12]
+		ext := path.Ext(file.Name())
+		name := strings.TrimSuffix(file.Name(), ext) + "." + hash + ext
+		contentType := mime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = http.DetectContentType(content)
+		}
+		b.hashed[file.Name()] = name
+		b.assets[name] = staticAsset{content: content, contentType: contentType, etag: `"` + hash + `"`}
+	}
+
+	funcs := template.FuncMap{
+		// static - адрес статического файла с хешем содержимого
+		"static": func(name string) (string, error) {
+			hashed, ok := b.hashed[name]
+			if !ok {
+				return "", fmt.Errorf("unknown static file %q", name)
+			}
+			return "/static/" + hashed, nil
+		},
+	}
+	layout, err := template.New(layoutTemplate).Funcs(funcs).ParseFS(fsys, "templates/"+layoutTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", layoutTemplate, err)
+	}
+	names, err := fs.Glob(fsys, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("error listing templates: %v", err)
+	}
+	for _, name := range names {
+		page := path.Base(name)
+		if page == layoutTemplate {
+			continue
+		}
+		clone, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+		b.pages[page], err = clone.ParseFS(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", page, err)
+		}
+	}
+	return b, nil
+}
+
+// current - разобранные шаблоны; с reload перечитывает их с диска.
+func (t *Templates) current() (*uiBundle, error) {
+	if t.reload {
+		bundle, err := loadUIBundle(t.fsys)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.bundle = bundle
+		t.mu.Unlock()
+		return bundle, nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.bundle, nil
+}
+
+// Execute - выполняет шаблон name страницы page. Результат собирается в буфер,
+// чтобы при ошибке шаблона клиент не получил половину страницы.
+func (t *Templates) Execute(w io.Writer, page, name string, data interface{}) error {
+	bundle, err := t.current()
+	if err != nil {
+		return err
+	}
+	temp, ok := bundle.pages[page]
+	if !ok {
+		return fmt.Errorf("unknown template %q", page)
+	}
+	var buf bytes.Buffer
+	if err := temp.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// SetTemplates - заменяет встроенные шаблоны, например на читаемые с диска при разработке.
+func (h *Handler) SetTemplates(t *Templates) {
+	h.templates = t
+}
+
+// Static - GET /static/{имя.хеш.расширение}. Имя меняется вместе с содержимым,
+// поэтому файлы можно кешировать навсегда.
+func (h *Handler) Static(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bundle, err := h.templates.current()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	asset, ok := bundle.assets[strings.TrimPrefix(r.URL.Path, "/static/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", asset.etag)
+	if r.Header.Get("If-None-Match") == asset.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(asset.content)
+}
@@ -0,0 +1,103 @@
+package gotest
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gotest/ui"
+)
+
+func TestLoadTemplatesParsesEveryPage(t *testing.T) {
+	templates, err := LoadTemplates(ui.Files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := fs.Glob(ui.Files, "templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		page := path.Base(name)
+		if page == layoutTemplate {
+			continue
+		}
+		if templates.bundle.pages[page] == nil {
+			t.Errorf("page %s is not parsed", page)
+		}
+	}
+	if len(templates.bundle.pages) != len(names)-1 {
+		t.Errorf("parsed %d pages, want %d", len(templates.bundle.pages), len(names)-1)
+	}
+}
+
+func TestLoadTemplatesRejectsBrokenTemplates(t *testing.T) {
+	base := fstest.MapFS{
+		"static/app.css":        {Data: []byte("body{}")},
+		"templates/layout.html": {Data: []byte(`{{define "layout"}}<link href="{{static "app.css"}}">{{template "content" .}}{{end}}`)},
+		"templates/page.html":   {Data: []byte(`{{define "content"}}ok{{end}}`)},
+	}
+	if _, err := LoadTemplates(base, false); err != nil {
+		t.Fatalf("valid bundle: %v", err)
+	}
+	broken := fstest.MapFS{}
+	for name, file := range base {
+		broken[name] = file
+	}
+	broken["templates/page.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{if}}{{end}}`)}
+	if _, err := LoadTemplates(broken, false); err == nil {
+		t.Error("broken template loaded")
+	}
+}
+
+func TestStaticServesHashedAssets(t *testing.T) {
+	h := NewHandler(nil)
+	bundle := h.templates.bundle
+	want := map[string]string{"app.css": "text/css; charset=utf-8", "app.js": "text/javascript; charset=utf-8"}
+	for file, contentType := range want {
+		hashed, ok := bundle.hashed[file]
+		if !ok {
+			t.Fatalf("%s has no hashed name", file)
+		}
+		content, err := fs.ReadFile(ui.Files, "static/"+file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		h.Static(rec, httptest.NewRequest(http.MethodGet, "/static/"+hashed, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != string(content) {
+			t.Fatalf("%s: status = %d, body matches %v", hashed, rec.Code, rec.Body.String() == string(content))
+		}
+		etag := rec.Header().Get("ETag")
+		if got := rec.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", hashed, got, contentType)
+		}
+		if etag == "" || !strings.Contains(hashed, strings.Trim(etag, `"`)) {
+			t.Errorf("%s: ETag = %q, want the content hash", hashed, etag)
+		}
+		if got := rec.Header().Get("Cache-Control"); !strings.Contains(got, "max-age=31536000") || !strings.Contains(got, "immutable") {
+			t.Errorf("%s: Cache-Control = %q", hashed, got)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/static/"+hashed, nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		h.Static(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s with If-None-Match: status = %d, body %d bytes", hashed, rec.Code, rec.Body.Len())
+		}
+	}
+
+	for _, p := range []string{"/static/app.css", "/static/app.000000000000.css", "/static/", "/static/../ui.go"} {
+		rec := httptest.NewRecorder()
+		h.Static(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", p, rec.Code)
+		}
+	}
+}
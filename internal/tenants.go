@@ -0,0 +1,302 @@
+// tenants.go
+package gotest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultTenantID - организация, которой принадлежат проекты, созданные до появления
+// организаций. Ей же принадлежат запросы без явно указанной организации.
+const DefaultTenantID = 1
+
+// tenantRole - роль без права входа, на которую переключается транзакция организации.
+// Таблицами владеет пользователь приложения, а на владельца политики RLS
+// не действуют, поэтому изоляция держится на смене роли.
+const tenantRole = "goods_tenant"
+
+// tenantSetting - параметр сессии Postgres с ID организации, его читают политики RLS.
+const tenantSetting = "app.tenant_id"
+
+// currentTenant - ID организации текущей транзакции; NULL вне транзакции организации.
+const currentTenant = "NULLIF(current_setting('" + tenantSetting + "', true), '')::int"
+
+// Organisation - организация, владеющая проектами.
+type Organisation struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CreateOrganisationsTable - создает таблицу organisations и добавляет tenant_id
+// в projects и goods. Существующие проекты и товары, как и проекты, созданные
+// без организации (например, при создании таблиц), достаются DefaultTenantID.
+func (s *SingletonDB) CreateOrganisationsTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS organisations (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+	INSERT INTO organisations (id, name) VALUES (%[1]d, 'default') ON CONFLICT (id) DO NOTHING;
+	SELECT setval('organisations_id_seq', GREATEST((SELECT MAX(id) FROM organisations), 1));
+
+	ALTER TABLE projects ADD COLUMN IF NOT EXISTS tenant_id INTEGER REFERENCES organisations(id);
+	UPDATE projects SET tenant_id = %[1]d WHERE tenant_id IS NULL;
+	ALTER TABLE projects ALTER COLUMN tenant_id SET DEFAULT COALESCE(%[2]s, %[1]d);
+	ALTER TABLE projects ALTER COLUMN tenant_id SET NOT NULL;
+
+	ALTER TABLE goods ADD COLUMN IF NOT EXISTS tenant_id INTEGER;
+	UPDATE goods g SET tenant_id = p.tenant_id FROM projects p WHERE p.id = g.project_id AND g.tenant_id IS NULL;
+	ALTER TABLE goods ALTER COLUMN tenant_id SET DEFAULT %[2]s;
+	ALTER TABLE goods ALTER COLUMN tenant_id SET NOT NULL;
+
+	-- Товар не может оказаться в проекте чужой организации
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'projects_id_tenant_key') THEN
+			ALTER TABLE projects ADD CONSTRAINT projects_id_tenant_key UNIQUE (id, tenant_id);
+		END IF;
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'goods_project_tenant_fkey') THEN
+			ALTER TABLE goods ADD CONSTRAINT goods_project_tenant_fkey
+				FOREIGN KEY (project_id, tenant_id) REFERENCES projects (id, tenant_id);
+		END IF;
+	END $$;
+
+	CREATE INDEX IF NOT EXISTS projects_tenant_id_index ON projects (tenant_id);
+	CREATE INDEX IF NOT EXISTS goods_tenant_id_index ON goods (tenant_id);
+	`, DefaultTenantID, currentTenant))
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы organisations: %v", err)
+	}
+	log.Println("Таблица organisations успешно создана")
+	return nil
+}
+
+// tenantPolicies - условия политик RLS по таблицам. projects и goods проверяют
+// tenant_id напрямую, остальные таблицы видны через свой проект или товар.
+var tenantPolicies = []struct {
+	table     string
+	condition string
+}{
+	{"projects", "tenant_id = " + currentTenant},
+	{"goods", "tenant_id = " + currentTenant},
+	{"api_keys", "EXISTS (SELECT 1 FROM projects p WHERE p.id = project_id)"},
+	{"tags", "EXISTS (SELECT 1 FROM projects p WHERE p.id = project_id)"},
+	{"project_fields", "EXISTS (SELECT 1 FROM projects p WHERE p.id = project_id)"},
+	{"good_tags", "EXISTS (SELECT 1 FROM goods g WHERE g.id = good_id)"},
+	{"goods_versions", "EXISTS (SELECT 1 FROM goods g WHERE g.id = good_id)"},
+	{"webhooks", "EXISTS (SELECT 1 FROM projects p WHERE p.id = project_id)"},
+	{"webhook_deliveries", "EXISTS (SELECT 1 FROM webhooks w WHERE w.id = webhook_id)"},
+	{"outbox", "tenant_id = " + currentTenant},
+}
+
+// EnableTenantIsolation - создает роль организаций и включает политики RLS.
+// Вызывается после создания всех таблиц.
+func (s *SingletonDB) EnableTenantIsolation() error {
+	statements := []string{fmt.Sprintf(`
+	DO $$
+	BEGIN
+		CREATE ROLE %[1]s NOLOGIN;
+	EXCEPTION WHEN duplicate_object THEN NULL;
+	END $$;
+	GRANT %[1]s TO CURRENT_USER;
+	GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO %[1]s;
+	GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO %[1]s;
+	`, tenantRole)}
+	for _, policy := range tenantPolicies {
+		statements = append(statements, fmt.Sprintf(`
+	ALTER TABLE %[1]s ENABLE ROW LEVEL SECURITY;
+	DROP POLICY IF EXISTS tenant_isolation ON %[1]s;
+	CREATE POLICY tenant_isolation ON %[1]s USING (%[2]s) WITH CHECK (%[2]s);
+	`, policy.table, policy.condition))
+	}
+	if _, err := s.db.Exec(strings.Join(statements, "")); err != nil {
+		return fmt.Errorf("Ошибка при включении изоляции организаций: %v", err)
+	}
+	log.Println("Изоляция организаций включена")
+	return nil
+}
+
+// ForTenant - копия подключения, все запросы которой выполняются от имени
+// организации tenantID: под политиками RLS и со своим пространством ключей Redis.
+func (s *SingletonDB) ForTenant(tenantID int) DBHandler {
+	scoped := *s
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// begin - транзакция от имени организации s.tenantID. Без организации
+// (подключение при запуске) транзакция выполняется от владельца таблиц, без RLS.
+func (s *SingletonDB) begin() (*sql.Tx, error) {
+	return s.beginTx(context.Background(), nil)
+}
+
+func (s *SingletonDB) beginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return s.beginOn(ctx, s.db, opts)
+}
+
+// beginOn - транзакция организации на подключении db: primary или реплике.
+func (s *SingletonDB) beginOn(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if s.tenantID == 0 {
+		return tx, nil
+	}
+	// SET не принимает параметры; tenantID - число, поэтому подставляем его в текст
+	_, err = tx.Exec(fmt.Sprintf("SET LOCAL ROLE %s; SET LOCAL %s = '%d'", tenantRole, tenantSetting, s.tenantID))
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error setting tenant: %w", err)
+	}
+	return tx, nil
+}
+
+// cacheKey - ключ Redis в пространстве организации, например tenant:1:good:5.
+func (s *SingletonDB) cacheKey(format string, args ...interface{}) string {
+	return fmt.Sprintf("tenant:%d:", s.tenantID) + fmt.Sprintf(format, args...)
+}
+
+// CreateOrganisation - создает организацию.
+func (s *SingletonDB) CreateOrganisation(name string) (*Organisation, error) {
+	var org Organisation
+	err := s.db.QueryRow("INSERT INTO organisations (name) VALUES ($1) RETURNING id, name, created_at", name).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting organisation: %v", err)
+	}
+	return &org, nil
+}
+
+// ListOrganisations - все организации.
+func (s *SingletonDB) ListOrganisations() ([]Organisation, error) {
+	rows, err := s.db.Query("SELECT id, name, created_at FROM organisations ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organisation{}
+	for rows.Next() {
+		var org Organisation
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func (s *SingletonDB) CheckIfOrganisationExists(id int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM organisations WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// TenantFromContext - организация субъекта из контекста; DefaultTenantID,
+// если субъекта нет (маршрут без авторизации).
+func TenantFromContext(ctx context.Context) int {
+	if p := PrincipalFromContext(ctx); p != nil && p.TenantID != 0 {
+		return p.TenantID
+	}
+	return DefaultTenantID
+}
+
+// store - подключение от имени организации субъекта запроса. Запросы только
+// на чтение (см. withReadOnly) читают с реплик, остальные - целиком с primary,
+// чтобы проверки перед записью и чтение после нее видели свежие данные.
+func (h *Handler) store(ctx context.Context) DBHandler {
+	db := h.db.ForTenant(TenantFromContext(ctx))
+	if isReadOnly(ctx) {
+		return db.ReadFromReplicas()
+	}
+	return db
+}
+
+// parseTenantID - ID организации из заголовка X-Tenant-ID мастер-ключа.
+// Пустое значение означает DefaultTenantID.
+func (h *Handler) parseTenantID(value string) (int, error) {
+	if value == "" {
+		return DefaultTenantID, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return 0, errUnauthenticated
+	}
+	exists, err := h.db.CheckIfOrganisationExists(id)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, errUnauthenticated
+	}
+	return id, nil
+}
+
+// requireSuperAdmin - организациями управляет только мастер-ключ.
+func requireSuperAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if p := PrincipalFromContext(r.Context()); p == nil || !p.superAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// CreateOrganisation - POST /org/create {"name": "acme"}
+func (h *Handler) CreateOrganisation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireSuperAdmin(w, r) {
+		return
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	org, err := h.db.CreateOrganisation(request.Name)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "org.create", 0, org.ID)
+	writeJSON(w, http.StatusCreated, org)
+}
+
+// ListOrganisations - GET /org/list
+func (h *Handler) ListOrganisations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireSuperAdmin(w, r) {
+		return
+	}
+	orgs, err := h.db.ListOrganisations()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, orgs)
+}
@@ -0,0 +1,124 @@
+package gotest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForTenantScopesConnection(t *testing.T) {
+	root := &SingletonDB{}
+	first := root.ForTenant(3).(*SingletonDB)
+	second := first.ForTenant(4).(*SingletonDB)
+	if root.tenantID != 0 || first.tenantID != 3 || second.tenantID != 4 {
+		t.Errorf("tenants = %d, %d, %d; want 0, 3, 4", root.tenantID, first.tenantID, second.tenantID)
+	}
+	if got := first.cacheKey("goods"); got != "tenant:3:goods" {
+		t.Errorf("cacheKey = %q, want tenant:3:goods", got)
+	}
+	if got := second.cacheKey("good:%d", 7); got != "tenant:4:good:7" {
+		t.Errorf("cacheKey = %q, want tenant:4:good:7", got)
+	}
+	if first.cacheKey("goods") == second.cacheKey("goods") {
+		t.Error("tenants share a cache key")
+	}
+}
+
+// tenantDB - запоминает, для какой организации и с каких подключений читал хендлер.
+type tenantDB struct {
+	DBHandler
+	orgs     map[int]bool
+	tenant   int
+	replicas bool
+}
+
+func (d *tenantDB) ForTenant(tenantID int) DBHandler {
+	return &tenantDB{orgs: d.orgs, tenant: tenantID}
+}
+
+func (d *tenantDB) ReadFromReplicas() DBHandler {
+	scoped := *d
+	scoped.replicas = true
+	return &scoped
+}
+
+func (d *tenantDB) CheckIfOrganisationExists(id int) (bool, error) {
+	return d.orgs[id], nil
+}
+
+func TestStoreUsesPrincipalTenant(t *testing.T) {
+	h := NewHandler(&tenantDB{})
+	tests := []struct {
+		name         string
+		principal    *Principal
+		readOnly     bool
+		wantTenant   int
+		wantReplicas bool
+	}{
+		{"no principal", nil, false, DefaultTenantID, false},
+		{"principal tenant", &Principal{TenantID: 5}, false, 5, false},
+		{"principal without tenant", &Principal{}, false, DefaultTenantID, false},
+		{"read only", &Principal{TenantID: 5}, true, 5, true},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.principal != nil {
+			ctx = context.WithValue(ctx, principalKey{}, tt.principal)
+		}
+		if tt.readOnly {
+			ctx = withReadOnly(ctx)
+		}
+		db := h.store(ctx).(*tenantDB)
+		if db.tenant != tt.wantTenant || db.replicas != tt.wantReplicas {
+			t.Errorf("%s: tenant %d, replicas %v; want %d, %v", tt.name, db.tenant, db.replicas, tt.wantTenant, tt.wantReplicas)
+		}
+	}
+}
+
+func TestParseTenantID(t *testing.T) {
+	h := NewHandler(&tenantDB{orgs: map[int]bool{1: true, 2: true}})
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", DefaultTenantID, false},
+		{"2", 2, false},
+		{"99", 0, true},
+		{"0", 0, true},
+		{"-2", 0, true},
+		{"two", 0, true},
+		{"2 ", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := h.parseTenantID(tt.value)
+		if tt.wantErr {
+			if err != errUnauthenticated {
+				t.Errorf("parseTenantID(%q): err = %v, want %v", tt.value, err, errUnauthenticated)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseTenantID(%q) = %d, %v; want %d", tt.value, got, err, tt.want)
+		}
+	}
+
+	// Мастер-ключ с неизвестной организацией не проходит аутентификацию
+	h.SetAdminKey("master")
+	for value, want := range map[string]int{"2": http.StatusOK, "99": http.StatusUnauthorized, "x": http.StatusUnauthorized} {
+		var tenant int
+		next := func(w http.ResponseWriter, r *http.Request) { tenant = TenantFromContext(r.Context()) }
+		req := httptest.NewRequest(http.MethodGet, "/org/list", nil)
+		req.Header.Set("X-API-Key", "master")
+		req.Header.Set("X-Tenant-ID", value)
+		rec := httptest.NewRecorder()
+		h.RequireScope(ScopeAdmin, next)(rec, req)
+		if rec.Code != want {
+			t.Errorf("X-Tenant-ID %q: status = %d, want %d", value, rec.Code, want)
+		}
+		if want == http.StatusOK && tenant != 2 {
+			t.Errorf("X-Tenant-ID %q: tenant = %d, want 2", value, tenant)
+		}
+	}
+}
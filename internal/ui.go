@@ -0,0 +1,695 @@
+// ui.go
+package gotest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// sessionCookie - cookie, в которой браузер хранит API-ключ пользователя интерфейса.
+// Заголовок X-API-Key при обычной навигации не отправить, поэтому интерфейс
+// входит по ключу один раз и дальше аутентифицируется cookie.
+const sessionCookie = "goods_session"
+
+// maxGoodNameLength - длина колонки goods.name.
+const maxGoodNameLength = 255
+
+// uiNotices - сообщения, которые показываются после перенаправления (?notice=...).
+var uiNotices = map[string]string{
+	"created":      "Good created.",
+	"updated":      "Good updated.",
+	"removed":      "Good removed.",
+	"priority":     "Priority updated.",
+	"bad_priority": "Priority must be a whole number from 1 to 2147483647.",
+	"logged_out":   "You have been logged out.",
+}
+
+// csrfToken - токен для форм, производный от сессии. Его нельзя узнать с чужого сайта,
+// поэтому POST без него отклоняется, даже если браузер приложил cookie.
+func csrfToken(session string) string {
+	sum := sha256.Sum256([]byte("csrf:" + session))
+	return hex.EncodeToString(sum[:16])
+}
+
+// uiSession - аутентифицирует запрос интерфейса по cookie и проверяет право scope
+// во всех projectIDs. Без сессии перенаправляет на страницу входа.
+// Возвращает запрос с субъектом в контексте, как RequireScope.
+func (h *Handler) uiSession(w http.ResponseWriter, r *http.Request, scope string, projectIDs ...int) (*http.Request, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+		return nil, false
+	}
+	principal, err := h.authenticateCredentials(cookie.Value, "", "")
+	if err == errUnauthenticated {
+		// Ключ отозван или неверен
+		clearSession(w)
+		http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+		return nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return nil, false
+	}
+	if r.Method == http.MethodPost {
+		token := r.PostFormValue("csrf")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(csrfToken(cookie.Value))) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return nil, false
+		}
+	}
+	if !principal.allowsAll(projectIDs, scope) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	ctx := context.WithValue(r.Context(), principalKey{}, principal)
+	ctx = context.WithValue(ctx, csrfKey{}, csrfToken(cookie.Value))
+	return r.WithContext(ctx), true
+}
+
+type csrfKey struct{}
+
+func setSession(w http.ResponseWriter, r *http.Request, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// renderUI - выводит страницу name внутри общего макета layout.html.
+func (h *Handler) renderUI(w http.ResponseWriter, r *http.Request, status int, name string, data map[string]interface{}) {
+	data["CSRF"], _ = r.Context().Value(csrfKey{}).(string)
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		data["Actor"] = p.Actor
+	}
+	if notice, ok := uiNotices[r.URL.Query().Get("notice")]; ok {
+		data["Notice"] = notice
+	}
+	var page bytes.Buffer
+	if err := h.templates.Execute(&page, name, "layout", data); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	page.WriteTo(w)
+}
+
+// Main - GET /, список доступных проектов.
+func (h *Handler) Main(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// "/" совпадает со всеми незарегистрированными путями
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	r, ok := h.uiSession(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	projects, err := h.store(r.Context()).GetProjects()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	goods, err := h.store(r.Context()).GetGoods()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	counts := make(map[int]int)
+	for _, good := range goods {
+		counts[good.ProjectID]++
+	}
+	type projectRow struct {
+		Project
+		Goods int
+	}
+	var rows []projectRow
+	for _, project := range projects {
+		if visibleProject(r.Context(), project.ID) {
+			rows = append(rows, projectRow{Project: project, Goods: counts[project.ID]})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	h.renderUI(w, r, http.StatusOK, "projects.html", map[string]interface{}{
+		"Title":    "Projects",
+		"Projects": rows,
+	})
+}
+
+// UILogin - GET /ui/login, форма входа по API-ключу.
+func (h *Handler) UILogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderUI(w, r, http.StatusOK, "login.html", map[string]interface{}{"Title": "Log in"})
+}
+
+// UISession - POST /ui/session, проверяет ключ и открывает сессию.
+func (h *Handler) UISession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimSpace(r.PostFormValue("api_key"))
+	if key == "" {
+		h.renderUI(w, r, http.StatusUnprocessableEntity, "login.html", map[string]interface{}{
+			"Title": "Log in",
+			"Error": "Enter an API key.",
+		})
+		return
+	}
+	_, err := h.authenticateCredentials(key, "", "")
+	if err == errUnauthenticated {
+		h.renderUI(w, r, http.StatusUnauthorized, "login.html", map[string]interface{}{
+			"Title": "Log in",
+			"Error": "The API key is invalid or revoked.",
+		})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	setSession(w, r, key)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// UILogout - POST /ui/logout
+func (h *Handler) UILogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.uiSession(w, r, ""); !ok {
+		return
+	}
+	clearSession(w)
+	http.Redirect(w, r, "/ui/login?notice=logged_out", http.StatusSeeOther)
+}
+
+// goodsSorts - колонки, по которым можно сортировать таблицу товаров.
+var goodsSorts = map[string]func(a, b Good) bool{
+	"id":         func(a, b Good) bool { return a.ID < b.ID },
+	"name":       func(a, b Good) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"priority":   func(a, b Good) bool { return a.Priority < b.Priority },
+	"created_at": func(a, b Good) bool { return a.CreatedAt < b.CreatedAt },
+}
+
+// uiProjectID - project_id из формы или query; при ошибке отвечает 400.
+func uiProjectID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.FormValue(name))
+	if err != nil || id <= 0 {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// UIGoods - GET /ui/goods?project_id=1&q=...&sort=name&order=desc, таблица товаров проекта.
+func (h *Handler) UIGoods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	r, ok = h.uiSession(w, r, ScopeRead, projectID)
+	if !ok {
+		return
+	}
+	project, err := h.store(r.Context()).GetProject(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if project == nil {
+		http.NotFound(w, r)
+		return
+	}
+	goods, err := h.store(r.Context()).GetGoods()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+
+	query := r.URL.Query()
+	search := strings.TrimSpace(query.Get("q"))
+	sortBy := query.Get("sort")
+	less, ok := goodsSorts[sortBy]
+	if !ok {
+		sortBy, less = "priority", goodsSorts["priority"]
+	}
+	desc := query.Get("order") == "desc"
+
+	rows := []Good{}
+	for _, good := range goods {
+		if good.ProjectID != projectID {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(good.Name+" "+good.Description), strings.ToLower(search)) {
+			continue
+		}
+		rows = append(rows, good)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if desc {
+			return less(rows[j], rows[i])
+		}
+		return less(rows[i], rows[j])
+	})
+
+	// Ссылки заголовков: повторный клик по текущей колонке меняет порядок
+	sortLinks := make(map[string]string)
+	for column := range goodsSorts {
+		params := url.Values{"project_id": {strconv.Itoa(projectID)}, "sort": {column}}
+		if search != "" {
+			params.Set("q", search)
+		}
+		if column == sortBy && !desc {
+			params.Set("order", "desc")
+		}
+		sortLinks[column] = "/ui/goods?" + params.Encode()
+	}
+	principal := PrincipalFromContext(r.Context())
+	h.renderUI(w, r, http.StatusOK, "goods.html", map[string]interface{}{
+		"Title":     project.Name,
+		"Project":   project,
+		"Goods":     rows,
+		"Search":    search,
+		"Sort":      sortBy,
+		"Desc":      desc,
+		"SortLinks": sortLinks,
+		"CanWrite":  principal.Allows(projectID, ScopeWrite),
+		"ReturnTo":  r.URL.RequestURI(),
+	})
+}
+
+// goodForm - значения формы товара и ошибки по полям.
+// Values - введенные значения пользовательских полей проекта по схеме Schema,
+// Errors для них хранятся под ключами "field.имя".
+type goodForm struct {
+	ProjectID   int
+	ID          int
+	Name        string
+	Description string
+	Schema      []FieldDef
+	Values      map[string]string
+	Fields      GoodFields
+	Errors      map[string]string
+}
+
+func (f *goodForm) validate() bool {
+	f.Errors = make(map[string]string)
+	f.Name = strings.TrimSpace(f.Name)
+	switch {
+	case f.Name == "":
+		f.Errors["name"] = "Name is required."
+	case utf8.RuneCountInString(f.Name) > maxGoodNameLength:
+		f.Errors["name"] = "Name must be at most 255 characters."
+	}
+	f.Fields = GoodFields{}
+	for _, def := range f.Schema {
+		raw := strings.TrimSpace(f.Values[def.Name])
+		if def.Type == FieldBoolean {
+			// Неотмеченный чекбокс не отправляется вовсе
+			checked := raw == "on" || raw == "true"
+			f.Fields[def.Name] = checked
+			f.Values[def.Name] = strconv.FormatBool(checked)
+			continue
+		}
+		if raw == "" {
+			if def.Required {
+				f.Errors["field."+def.Name] = def.Name + " is required."
+			}
+			continue
+		}
+		switch def.Type {
+		case FieldNumber:
+			number, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				f.Errors["field."+def.Name] = def.Name + " must be a number."
+				continue
+			}
+			f.Fields[def.Name] = number
+		case FieldEnum:
+			if !containsString(def.Enum, raw) {
+				f.Errors["field."+def.Name] = def.Name + " must be one of " + strings.Join(def.Enum, ", ") + "."
+				continue
+			}
+			f.Fields[def.Name] = raw
+		default:
+			f.Fields[def.Name] = raw
+		}
+	}
+	return len(f.Errors) == 0
+}
+
+// fieldError - ошибка полей от базы (схему могли поменять после открытия формы) в форму.
+func (f *goodForm) fieldError(err error) bool {
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		return false
+	}
+	f.Errors["field."+fieldErr.Field] = fieldErr.Field + " " + fieldErr.Message + "."
+	return true
+}
+
+// uiFieldSchema - схема полей проекта для формы товара.
+func (h *Handler) uiFieldSchema(w http.ResponseWriter, r *http.Request, projectID int) ([]FieldDef, bool) {
+	defs, err := h.store(r.Context()).GetFieldSchema(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return nil, false
+	}
+	return defs, true
+}
+
+// postedFieldValues - значения пользовательских полей из отправленной формы.
+func postedFieldValues(r *http.Request, defs []FieldDef) map[string]string {
+	values := make(map[string]string, len(defs))
+	for _, def := range defs {
+		values[def.Name] = r.PostFormValue("field." + def.Name)
+	}
+	return values
+}
+
+func (h *Handler) renderGoodForm(w http.ResponseWriter, r *http.Request, status int, project *Project, form goodForm) {
+	title, action := "New good", "/ui/good/create"
+	if form.ID != 0 {
+		title, action = "Edit good", "/ui/good/save"
+	}
+	h.renderUI(w, r, status, "good_form.html", map[string]interface{}{
+		"Title":   title,
+		"Action":  action,
+		"Project": project,
+		"Form":    form,
+	})
+}
+
+// uiProject - проект для форм; если его нет, отвечает 404.
+func (h *Handler) uiProject(w http.ResponseWriter, r *http.Request, projectID int) (*Project, bool) {
+	project, err := h.store(r.Context()).GetProject(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return nil, false
+	}
+	if project == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return project, true
+}
+
+// UINewGood - GET /ui/good/new?project_id=1
+func (h *Handler) UINewGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	if r, ok = h.uiSession(w, r, ScopeWrite, projectID); !ok {
+		return
+	}
+	project, ok := h.uiProject(w, r, projectID)
+	if !ok {
+		return
+	}
+	defs, ok := h.uiFieldSchema(w, r, projectID)
+	if !ok {
+		return
+	}
+	h.renderGoodForm(w, r, http.StatusOK, project, goodForm{ProjectID: projectID, Schema: defs})
+}
+
+// UICreateGood - POST /ui/good/create
+func (h *Handler) UICreateGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	if r, ok = h.uiSession(w, r, ScopeWrite, projectID); !ok {
+		return
+	}
+	project, ok := h.uiProject(w, r, projectID)
+	if !ok {
+		return
+	}
+	defs, ok := h.uiFieldSchema(w, r, projectID)
+	if !ok {
+		return
+	}
+	form := goodForm{
+		ProjectID: projectID,
+		Name:      r.PostFormValue("name"),
+		Schema:    defs,
+		Values:    postedFieldValues(r, defs),
+	}
+	if !form.validate() {
+		h.renderGoodForm(w, r, http.StatusUnprocessableEntity, project, form)
+		return
+	}
+	good, err := h.store(r.Context()).CreateGoods(projectID, form.Name, form.Fields)
+	if form.fieldError(err) {
+		h.renderGoodForm(w, r, http.StatusUnprocessableEntity, project, form)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "good.create", projectID, good.ID)
+	http.Redirect(w, r, "/ui/goods?project_id="+strconv.Itoa(projectID)+"&notice=created", http.StatusSeeOther)
+}
+
+// uiGood - товар из формы или query; если его нет, отвечает 404.
+func (h *Handler) uiGood(w http.ResponseWriter, r *http.Request, projectID int) (*Good, bool) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil, false
+	}
+	good, err := h.store(r.Context()).GetGood(projectID, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return nil, false
+	}
+	if good == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return good, true
+}
+
+// UIEditGood - GET /ui/good/edit?project_id=1&id=2
+func (h *Handler) UIEditGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	if r, ok = h.uiSession(w, r, ScopeWrite, projectID); !ok {
+		return
+	}
+	project, ok := h.uiProject(w, r, projectID)
+	if !ok {
+		return
+	}
+	good, ok := h.uiGood(w, r, projectID)
+	if !ok {
+		return
+	}
+	defs, ok := h.uiFieldSchema(w, r, projectID)
+	if !ok {
+		return
+	}
+	values := make(map[string]string, len(good.Fields))
+	for name, value := range good.Fields {
+		values[name] = fieldString(value)
+	}
+	h.renderGoodForm(w, r, http.StatusOK, project, goodForm{
+		ProjectID:   projectID,
+		ID:          good.ID,
+		Name:        good.Name,
+		Description: good.Description,
+		Schema:      defs,
+		Values:      values,
+	})
+}
+
+// UISaveGood - POST /ui/good/save
+func (h *Handler) UISaveGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	if r, ok = h.uiSession(w, r, ScopeWrite, projectID); !ok {
+		return
+	}
+	project, ok := h.uiProject(w, r, projectID)
+	if !ok {
+		return
+	}
+	good, ok := h.uiGood(w, r, projectID)
+	if !ok {
+		return
+	}
+	defs, ok := h.uiFieldSchema(w, r, projectID)
+	if !ok {
+		return
+	}
+	form := goodForm{
+		ProjectID:   projectID,
+		ID:          good.ID,
+		Name:        r.PostFormValue("name"),
+		Description: r.PostFormValue("description"),
+		Schema:      defs,
+		Values:      postedFieldValues(r, defs),
+	}
+	if !form.validate() {
+		h.renderGoodForm(w, r, http.StatusUnprocessableEntity, project, form)
+		return
+	}
+	_, err := h.store(r.Context()).UpdateGoods(projectID, good.ID, form.Name, form.Description, form.Fields)
+	if form.fieldError(err) {
+		h.renderGoodForm(w, r, http.StatusUnprocessableEntity, project, form)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "good.update", projectID, good.ID)
+	http.Redirect(w, r, "/ui/goods?project_id="+strconv.Itoa(projectID)+"&notice=updated", http.StatusSeeOther)
+}
+
+// UIRemoveGood - POST /ui/good/remove, подтверждение спрашивает браузер.
+func (h *Handler) UIRemoveGood(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	if r, ok = h.uiSession(w, r, ScopeWrite, projectID); !ok {
+		return
+	}
+	good, ok := h.uiGood(w, r, projectID)
+	if !ok {
+		return
+	}
+	if err := h.store(r.Context()).DeleteGoods(projectID, good.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "good.remove", projectID, good.ID)
+	http.Redirect(w, r, "/ui/goods?project_id="+strconv.Itoa(projectID)+"&notice=removed", http.StatusSeeOther)
+}
+
+// UIGoodPriority - POST /ui/good/priority, правка приоритета прямо в таблице.
+func (h *Handler) UIGoodPriority(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, ok := uiProjectID(w, r, "project_id")
+	if !ok {
+		return
+	}
+	if r, ok = h.uiSession(w, r, ScopeWrite, projectID); !ok {
+		return
+	}
+	good, ok := h.uiGood(w, r, projectID)
+	if !ok {
+		return
+	}
+	back := "/ui/goods?project_id=" + strconv.Itoa(projectID)
+	if returnTo := r.PostFormValue("return_to"); strings.HasPrefix(returnTo, "/ui/goods?") {
+		back = returnTo
+	}
+	priority, err := strconv.ParseInt(r.PostFormValue("priority"), 10, 32)
+	if err != nil || priority < 1 {
+		http.Redirect(w, r, withNotice(back, "bad_priority"), http.StatusSeeOther)
+		return
+	}
+	if _, err := h.store(r.Context()).UpdateGoodPriority(projectID, good.ID, int(priority)); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "good.priority", projectID, good.ID)
+	http.Redirect(w, r, withNotice(back, "priority"), http.StatusSeeOther)
+}
+
+// withNotice - добавляет к адресу страницы интерфейса сообщение notice.
+func withNotice(target, notice string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	query := u.Query()
+	query.Set("notice", notice)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,112 @@
+package gotest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// uiStore - grpcStore со схемой полей проекта для форм товара.
+type uiStore struct {
+	*grpcStore
+}
+
+func (s *uiStore) ForTenant(tenantID int) DBHandler { return s }
+func (s *uiStore) ReadFromReplicas() DBHandler      { return s }
+
+func (s *uiStore) GetFieldSchema(projectID int) ([]FieldDef, error) {
+	return []FieldDef{{Name: "color", Type: FieldString}, {Name: "size", Type: FieldEnum, Enum: []string{"s", "m"}}}, nil
+}
+
+// uiRequest - запрос интерфейса с сессией key (пустой - без сессии).
+func uiRequest(method, target, key string, form url.Values) *http.Request {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if key != "" {
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: key})
+	}
+	return r
+}
+
+func TestUIPagesRender(t *testing.T) {
+	db := &uiStore{newGRPCStore(t)}
+	h := NewHandler(db)
+	db.memoryHandler.UpdateGoods(1, 3, "first <good>", "", GoodFields{"color": "red"})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		key     string
+		want    []string
+	}{
+		{"login", h.UILogin, "/ui/login?notice=logged_out", "", []string{"<form", `name="api_key"`, "You have been logged out."}},
+		{"projects", h.Main, "/", "reader", []string{"first", `/ui/goods?project_id=1`}},
+		{"goods", h.UIGoods, "/ui/goods?project_id=1&sort=name", "reader", []string{"first &lt;good&gt;", "<table"}},
+		{"goods for writer", h.UIGoods, "/ui/goods?project_id=1", "writer", []string{"New good", `name="csrf" value="` + csrfToken("writer") + `"`}},
+		{"new good", h.UINewGood, "/ui/good/new?project_id=1", "writer", []string{"New good", `action="/ui/good/create"`, `name="field.color"`}},
+		{"edit good", h.UIEditGood, "/ui/good/edit?project_id=1&id=3", "writer", []string{"Edit good", `value="first &lt;good&gt;"`, `value="red"`}},
+		{"docs", h.Docs, "/docs", "", []string{"/good/get"}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, uiRequest(http.MethodGet, tt.target, tt.key, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d: %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+			t.Errorf("%s: Content-Type = %q", tt.name, got)
+		}
+		body := rec.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: page does not contain %q", tt.name, want)
+			}
+		}
+		if strings.Contains(body, "<good>") {
+			t.Errorf("%s: good name is not escaped", tt.name)
+		}
+	}
+}
+
+func TestUIRequiresSession(t *testing.T) {
+	db := &uiStore{newGRPCStore(t)}
+	h := NewHandler(db)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		key     string
+		form    url.Values
+		want    int
+	}{
+		{"no session", h.Main, http.MethodGet, "/", "", nil, http.StatusSeeOther},
+		{"revoked key", h.UIGoods, http.MethodGet, "/ui/goods?project_id=1", "nobody", nil, http.StatusSeeOther},
+		{"other project", h.UIGoods, http.MethodGet, "/ui/goods?project_id=2", "reader", nil, http.StatusForbidden},
+		{"reader edits", h.UINewGood, http.MethodGet, "/ui/good/new?project_id=1", "reader", nil, http.StatusForbidden},
+		{"missing good", h.UIEditGood, http.MethodGet, "/ui/good/edit?project_id=1&id=99", "writer", nil, http.StatusNotFound},
+		{"unknown path", h.Main, http.MethodGet, "/missing", "reader", nil, http.StatusNotFound},
+		{"post without csrf", h.UICreateGood, http.MethodPost, "/ui/good/create", "writer", url.Values{"project_id": {"1"}, "name": {"x"}}, http.StatusForbidden},
+		{"empty name", h.UICreateGood, http.MethodPost, "/ui/good/create", "writer", url.Values{"project_id": {"1"}, "name": {""}, "csrf": {csrfToken("writer")}}, http.StatusUnprocessableEntity},
+		{"create", h.UICreateGood, http.MethodPost, "/ui/good/create", "writer", url.Values{"project_id": {"1"}, "name": {"x"}, "csrf": {csrfToken("writer")}}, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, uiRequest(tt.method, tt.target, tt.key, tt.form))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusSeeOther && tt.key != "writer" && rec.Header().Get("Location") != "/ui/login" {
+			t.Errorf("%s: redirected to %q, want /ui/login", tt.name, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// versions.go
+package gotest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// ErrVersionNotFound - у товара нет версии с таким номером.
+var ErrVersionNotFound = errors.New("version not found")
+
+// GoodVersion - состояние товара после одного изменения. Changes - отличия
+// от предыдущей версии; у первой версии from у всех полей null.
+type GoodVersion struct {
+	Version     int           `json:"version"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Priority    int           `json:"priority"`
+	Fields      GoodFields    `json:"fields"`
+	CreatedAt   string        `json:"created_at"`
+	Changes     []FieldChange `json:"changes"`
+}
+
+// FieldChange - изменение одного поля между версиями. Пользовательские поля
+// называются "fields.имя".
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// CreateGoodsVersionsTable - создает таблицу версий и заводит первую версию
+// товарам, у которых ее еще нет (созданным до появления истории).
+func (s *SingletonDB) CreateGoodsVersionsTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS goods_versions (
+		good_id INTEGER NOT NULL REFERENCES goods(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL,
+		priority INTEGER NOT NULL,
+		fields JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (good_id, version)
+	);
+	INSERT INTO goods_versions (good_id, version, name, description, priority, fields)
+	SELECT g.id, 1, g.name, g.description, g.priority, g.fields FROM goods g
+	WHERE NOT EXISTS (SELECT 1 FROM goods_versions v WHERE v.good_id = g.id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы goods_versions: %v", err)
+	}
+	log.Println("Таблица goods_versions успешно создана")
+	return nil
+}
+
+// recordGoodVersion - записывает текущее состояние товара новой версией.
+// Вызывается в той же транзакции, что и изменение: строка goods уже заблокирована
+// UPDATE, поэтому номера версий одного товара не пересекаются.
+func recordGoodVersion(tx *sql.Tx, good Good) error {
+	_, err := tx.Exec(`
+	INSERT INTO goods_versions (good_id, version, name, description, priority, fields)
+	SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5 FROM goods_versions WHERE good_id = $1`,
+		good.ID, good.Name, good.Description, good.Priority, good.Fields)
+	if err != nil {
+		return fmt.Errorf("error recording good version: %w", err)
+	}
+	return nil
+}
+
+// GoodHistory - версии товара от новой к старой с отличиями от предыдущей версии.
+func (s *SingletonDB) GoodHistory(projectID int, id int) ([]GoodVersion, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT v.version, v.name, v.description, v.priority, v.fields, v.created_at
+	FROM goods_versions v JOIN goods g ON g.id = v.good_id
+	WHERE v.good_id = $1 AND g.project_id = $2
+	ORDER BY v.version`, id, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading good history: %v", err)
+	}
+	defer rows.Close()
+
+	versions := []GoodVersion{}
+	for rows.Next() {
+		var v GoodVersion
+		if err := rows.Scan(&v.Version, &v.Name, &v.Description, &v.Priority, &v.Fields, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning good version: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var prev *GoodVersion
+	for i := range versions {
+		versions[i].Changes = diffVersions(prev, &versions[i])
+		prev = &versions[i]
+	}
+	// Новые версии первыми
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
+
+// diffVersions - отличия cur от prev; prev == nil для первой версии.
+func diffVersions(prev, cur *GoodVersion) []FieldChange {
+	changes := []FieldChange{}
+	var from GoodVersion
+	if prev != nil {
+		from = *prev
+	}
+	add := func(field string, before, after interface{}, changed bool) {
+		if prev == nil {
+			before = nil
+		}
+		if prev == nil || changed {
+			changes = append(changes, FieldChange{Field: field, From: before, To: after})
+		}
+	}
+	add("name", from.Name, cur.Name, from.Name != cur.Name)
+	add("description", from.Description, cur.Description, from.Description != cur.Description)
+	add("priority", from.Priority, cur.Priority, from.Priority != cur.Priority)
+
+	names := make([]string, 0, len(from.Fields)+len(cur.Fields))
+	for name := range from.Fields {
+		names = append(names, name)
+	}
+	for name := range cur.Fields {
+		if _, ok := from.Fields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		before, after := from.Fields[name], cur.Fields[name]
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, FieldChange{Field: "fields." + name, From: before, To: after})
+		}
+	}
+	return changes
+}
+
+// RevertGood - возвращает товару имя, описание, приоритет и пользовательские поля
+// из версии version. Откат записывается новой версией. Поля проверяются по текущей
+// схеме проекта: если она с тех пор изменилась, возвращается *FieldError.
+func (s *SingletonDB) RevertGood(projectID int, id int, version int) (*Good, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+
+	var v GoodVersion
+	err = tx.QueryRow(`
+	SELECT v.name, v.description, v.priority, v.fields
+	FROM goods_versions v JOIN goods g ON g.id = v.good_id
+	WHERE v.good_id = $1 AND g.project_id = $2 AND v.version = $3`, id, projectID, version).
+		Scan(&v.Name, &v.Description, &v.Priority, &v.Fields)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error reading good version: %v", err)
+	}
+	defs, err := fieldSchema(tx, projectID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := validateFields(defs, v.Fields); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query := "UPDATE goods SET name = $1, description = $2, priority = $3, fields = $4 WHERE id = $5 AND project_id = $6 RETURNING id, project_id, name, description, priority, removed, created_at, fields, " + goodTagsColumn("goods")
+	var good Good
+	err = tx.QueryRow(query, v.Name, v.Description, v.Priority, v.Fields, id, projectID).Scan(&good.ID, &good.ProjectID, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt, &good.Fields, pq.Array(&good.Tags))
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error reverting good: %v", err)
+	}
+	if err := recordGoodVersion(tx, good); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := recordGoodEvent(tx, GoodUpdated, good); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Коммитим транзакцию
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	s.cacheGood(good)
+	return &good, nil
+}
+
+// History - GET /good/history?project_id=1&id=2
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	projectID, err := strconv.Atoi(query.Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	versions, err := h.store(r.Context()).GoodHistory(projectID, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"project_id": projectID,
+		"id":         id,
+		"versions":   versions,
+	})
+}
+
+// revertRequest - тело POST /good/revert.
+type revertRequest struct {
+	ID        string `json:"id"`
+	ProjectID string `json:"projectId"`
+	Version   int    `json:"version"`
+}
+
+// Revert - POST /good/revert {"projectId": "1", "id": "2", "version": 3}, отвечает товаром.
+func (h *Handler) Revert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request revertRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(request.ID)
+	if err != nil || request.Version < 1 {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	exists, err := h.store(r.Context()).CheckIfGoodExists(id, projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	good, err := h.store(r.Context()).RevertGood(projectID, id, request.Version)
+	if err == ErrVersionNotFound {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	if writeFieldError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "good.revert", projectID, id)
+	writeJSON(w, http.StatusOK, good)
+}
@@ -0,0 +1,111 @@
+package gotest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffVersions(t *testing.T) {
+	base := GoodVersion{Name: "apple", Description: "red", Priority: 1, Fields: GoodFields{"color": "red", "weight": 1.5}}
+	with := func(mutate func(*GoodVersion)) *GoodVersion {
+		v := base
+		v.Fields = GoodFields{}
+		for name, value := range base.Fields {
+			v.Fields[name] = value
+		}
+		mutate(&v)
+		return &v
+	}
+
+	tests := []struct {
+		name string
+		prev *GoodVersion
+		cur  *GoodVersion
+		want []FieldChange
+	}{
+		{
+			name: "first version",
+			cur:  &base,
+			want: []FieldChange{
+				{Field: "name", From: nil, To: "apple"},
+				{Field: "description", From: nil, To: "red"},
+				{Field: "priority", From: nil, To: 1},
+				{Field: "fields.color", From: nil, To: "red"},
+				{Field: "fields.weight", From: nil, To: 1.5},
+			},
+		},
+		{
+			name: "first version without fields",
+			cur:  &GoodVersion{Name: "apple", Priority: 1},
+			want: []FieldChange{
+				{Field: "name", From: nil, To: "apple"},
+				{Field: "description", From: nil, To: ""},
+				{Field: "priority", From: nil, To: 1},
+			},
+		},
+		{
+			name: "unchanged",
+			prev: &base,
+			cur:  with(func(v *GoodVersion) {}),
+			want: []FieldChange{},
+		},
+		{
+			name: "name, description and priority",
+			prev: &base,
+			cur: with(func(v *GoodVersion) {
+				v.Name, v.Description, v.Priority = "pear", "", 3
+			}),
+			want: []FieldChange{
+				{Field: "name", From: "apple", To: "pear"},
+				{Field: "description", From: "red", To: ""},
+				{Field: "priority", From: 1, To: 3},
+			},
+		},
+		{
+			name: "custom field added",
+			prev: &base,
+			cur:  with(func(v *GoodVersion) { v.Fields["organic"] = true }),
+			want: []FieldChange{{Field: "fields.organic", From: nil, To: true}},
+		},
+		{
+			name: "custom field removed",
+			prev: &base,
+			cur:  with(func(v *GoodVersion) { delete(v.Fields, "color") }),
+			want: []FieldChange{{Field: "fields.color", From: "red", To: nil}},
+		},
+		{
+			name: "custom field changed",
+			prev: &base,
+			cur:  with(func(v *GoodVersion) { v.Fields["weight"] = 2.0 }),
+			want: []FieldChange{{Field: "fields.weight", From: 1.5, To: 2.0}},
+		},
+		{
+			name: "custom fields in name order after built-in fields",
+			prev: &base,
+			cur: with(func(v *GoodVersion) {
+				v.Priority = 2
+				v.Fields["zone"] = "b"
+				v.Fields["amount"] = 3.0
+				delete(v.Fields, "weight")
+				v.Fields["color"] = "green"
+			}),
+			want: []FieldChange{
+				{Field: "priority", From: 1, To: 2},
+				{Field: "fields.amount", From: nil, To: 3.0},
+				{Field: "fields.color", From: "red", To: "green"},
+				{Field: "fields.weight", From: 1.5, To: nil},
+				{Field: "fields.zone", From: nil, To: "b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Порядок не должен зависеть от обхода map: повторяем сравнение несколько раз
+			for i := 0; i < 10; i++ {
+				if got := diffVersions(tt.prev, tt.cur); !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("diffVersions = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,839 @@
+// webhooks.go
+package gotest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// webhookSecretPrefix - префикс секретов подписи, чтобы их было легко узнать в конфигах.
+const webhookSecretPrefix = "whsec_"
+
+// Состояния доставки: pending ждет отправки или повтора, delivered - получатель
+// ответил 2xx, dead - попытки исчерпаны, доставку можно повторить только вручную.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Заголовки запроса вебхука.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Webhook - адрес проекта, на который отправляются события товаров.
+// Пустой Events - все события.
+type Webhook struct {
+	ID        int      `json:"id"`
+	ProjectID int      `json:"project_id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"created_at"`
+	// Secret - ключ подписи, возвращается только один раз при создании.
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookDelivery - отправка одного события на один вебхук.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *string         `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      string          `json:"created_at"`
+	DeliveredAt    *string         `json:"delivered_at"`
+}
+
+// DueDelivery - доставка, взятая в работу диспетчером, с адресом и секретом вебхука.
+type DueDelivery struct {
+	Delivery WebhookDelivery
+	URL      string
+	Secret   string
+}
+
+// WebhookAttempt - итог попытки доставки. RetryIn задает паузу до следующей
+// попытки и учитывается только для DeliveryPending.
+type WebhookAttempt struct {
+	Status     string
+	StatusCode int
+	Error      string
+	RetryIn    time.Duration
+}
+
+// CreateWebhooksTable - создает таблицы вебхуков и журнала доставок.
+func (s *SingletonDB) CreateWebhooksTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id SERIAL PRIMARY KEY,
+		project_id INTEGER NOT NULL REFERENCES projects(id),
+		url TEXT NOT NULL,
+		events TEXT[] NOT NULL DEFAULT '{}',
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS webhooks_project_id_index ON webhooks (project_id);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event_id BIGINT NOT NULL,
+		event_type VARCHAR(16) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP DEFAULT NOW(),
+		last_status_code INTEGER,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW(),
+		delivered_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due_index ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_index ON webhook_deliveries (webhook_id, id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Ошибка при создании таблицы webhooks: %v", err)
+	}
+	log.Println("Таблица webhooks успешно создана")
+	return nil
+}
+
+const webhookColumns = "id, project_id, url, events, created_at"
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var hook Webhook
+	if err := row.Scan(&hook.ID, &hook.ProjectID, &hook.URL, pq.Array(&hook.Events), &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	return &hook, nil
+}
+
+const deliveryColumns = "d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at"
+
+func scanDelivery(row rowScanner, extra ...interface{}) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	var nextAttemptAt, deliveredAt sql.NullString
+	var statusCode sql.NullInt64
+	dest := append([]interface{}{&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&nextAttemptAt, &statusCode, &d.LastError, &d.CreatedAt, &deliveredAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.String
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.String
+	}
+	if statusCode.Valid {
+		code := int(statusCode.Int64)
+		d.LastStatusCode = &code
+	}
+	return &d, nil
+}
+
+// CreateWebhook - регистрирует адрес проекта.
+func (s *SingletonDB) CreateWebhook(projectID int, endpoint string, events []string, secret string) (*Webhook, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if events == nil {
+		events = []string{}
+	}
+	hook, err := scanWebhook(tx.QueryRow("INSERT INTO webhooks (project_id, url, events, secret) VALUES ($1, $2, $3, $4) RETURNING "+webhookColumns,
+		projectID, endpoint, pq.Array(events), secret))
+	if err != nil {
+		return nil, fmt.Errorf("error inserting webhook: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return hook, nil
+}
+
+// ListWebhooks - вебхуки проекта без секретов.
+func (s *SingletonDB) ListWebhooks(projectID int) ([]Webhook, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT "+webhookColumns+" FROM webhooks WHERE project_id = $1 ORDER BY id", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %v", err)
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook: %v", err)
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook - удаляет вебхук вместе с журналом доставок. false - вебхука не было.
+func (s *SingletonDB) DeleteWebhook(projectID int, id int) (bool, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM webhooks WHERE id = $1 AND project_id = $2", id, projectID)
+	if err != nil {
+		return false, fmt.Errorf("error deleting webhook: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return n > 0, nil
+}
+
+// ListWebhookDeliveries - последние limit доставок проекта, новые первыми.
+// webhookID и status необязательны (0 и "").
+func (s *SingletonDB) ListWebhookDeliveries(projectID int, webhookID int, status string, limit int) ([]WebhookDelivery, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT `+deliveryColumns+` FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE w.project_id = $1 AND ($2 = 0 OR d.webhook_id = $2) AND ($3::text = '' OR d.status = $3::text)
+	ORDER BY d.id DESC LIMIT $4`, projectID, webhookID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %v", err)
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RedeliverWebhook - ставит доставку проекта в очередь заново с полным запасом попыток.
+// nil - доставки нет.
+func (s *SingletonDB) RedeliverWebhook(projectID int, id int64) (*WebhookDelivery, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	d, err := scanDelivery(tx.QueryRow(`
+	UPDATE webhook_deliveries d SET status = 'pending', attempts = 0, next_attempt_at = NOW(), delivered_at = NULL
+	FROM webhooks w WHERE w.id = d.webhook_id AND d.id = $1 AND w.project_id = $2
+	RETURNING `+deliveryColumns, id, projectID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error requeueing webhook delivery: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return d, nil
+}
+
+// enqueueWebhooks - ставит событие в очередь для всех вебхуков проекта, которые на него
+// подписаны. Вызывается из RelayOutbox в транзакции, отмечающей событие разосланным.
+func enqueueWebhooks(tx *sql.Tx, event GoodEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook payload: %v", err)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+	SELECT id, $2::bigint, $3::text, $4::jsonb FROM webhooks
+	WHERE project_id = $1 AND (cardinality(events) = 0 OR $3::text = ANY(events))`,
+		event.Good.ProjectID, event.ID, event.Type, string(payload))
+	if err != nil {
+		return fmt.Errorf("error enqueueing webhooks: %v", err)
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries - забирает до limit доставок, время которых подошло, во всех
+// организациях. Следующая попытка сдвигается на lease: если экземпляр упадет посреди
+// отправки, доставку подхватит другой. SKIP LOCKED не дает двум экземплярам взять одну доставку.
+func (s *SingletonDB) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]DueDelivery, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $2::bigint * INTERVAL '1 millisecond'
+	FROM webhooks w
+	WHERE w.id = d.webhook_id AND d.id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING `+deliveryColumns+`, w.url, w.secret`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+	var due []DueDelivery
+	for rows.Next() {
+		var item DueDelivery
+		d, err := scanDelivery(rows, &item.URL, &item.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %v", err)
+		}
+		item.Delivery = *d
+		due = append(due, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return due, nil
+}
+
+// RecordWebhookAttempt - сохраняет итог попытки доставки id.
+func (s *SingletonDB) RecordWebhookAttempt(id int64, attempt WebhookAttempt) error {
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE webhook_deliveries SET
+		status = $2::text,
+		attempts = attempts + 1,
+		last_status_code = NULLIF($3::int, 0),
+		last_error = $4,
+		next_attempt_at = CASE WHEN $2::text = 'pending' THEN NOW() + $5::bigint * INTERVAL '1 millisecond' END,
+		delivered_at = CASE WHEN $2::text = 'delivered' THEN NOW() END
+	WHERE id = $1`, id, attempt.Status, attempt.StatusCode, attempt.Error, attempt.RetryIn.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("error recording webhook attempt: %v", err)
+	}
+	return tx.Commit()
+}
+
+// SignWebhook - подпись тела запроса вебхука: "t=<unix-время>,v1=<hex HMAC-SHA256>",
+// где HMAC считается от "<unix-время>.<тело>". Время в подписи не дает повторить
+// перехваченный запрос позже.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + webhookMAC(secret, t, body)
+}
+
+// VerifyWebhook - проверка подписи на стороне получателя. Подпись старше tolerance отвергается.
+func VerifyWebhook(secret, signature string, body []byte, tolerance time.Duration) bool {
+	var t, mac string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			mac = value
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || mac == "" {
+		return false
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(mac), []byte(webhookMAC(secret, t, body))) == 1
+}
+
+func webhookMAC(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// generateWebhookSecret - новый секрет вида whsec_<64 hex-символа>.
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(buf), nil
+}
+
+// errNonPublicAddress - адрес вебхука ведет во внутреннюю сеть.
+var errNonPublicAddress = errors.New("webhook address is not public")
+
+// nonPublicNetworks - сети, которые IP.IsPrivate и соседние методы не покрывают:
+// "этот" хост, общий адрес провайдера (CGNAT) и 6to4-ретрансляторы.
+var nonPublicNetworks = mustParseCIDRs("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// publicIP - адрес доступен из интернета: не loopback, не частная, не link-local
+// (в том числе 169.254.169.254 - метаданные облака) и не служебная сеть.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly - net.Dialer.Control, отказывающий в соединении с непубличными
+// адресами. Проверяется адрес, к которому действительно идет соединение, после
+// разрешения имени, поэтому DNS-запись, указывающая внутрь, не поможет.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errNonPublicAddress, host)
+	}
+	return nil
+}
+
+// newWebhookClient - HTTP-клиент доставки: соединяется только с публичными адресами,
+// не ходит через прокси из окружения и не следует редиректам (ответ 3xx - неудачная
+// попытка), чтобы вебхук нельзя было направить во внутреннюю сеть.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: dialPublicOnly}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// WebhookDispatcher - фоновая отправка вебхуков. Работает с подключением без
+// организации: доставки всех организаций обслуживает один диспетчер.
+// Поля можно менять после NewWebhookDispatcher, но до Run.
+type WebhookDispatcher struct {
+	db DBHandler
+	// Client - клиент доставки; по умолчанию соединяется только с публичными адресами.
+	Client *http.Client
+	// MaxAttempts - после стольких неудачных попыток доставка становится dead.
+	MaxAttempts int
+	// Пауза перед повтором растет от MinBackoff вдвое с каждой попыткой до MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BatchSize - сколько доставок забирается и отправляется одновременно,
+	// PollInterval - пауза, когда отправлять нечего.
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+// NewWebhookDispatcher - диспетчер с настройками по умолчанию: 8 попыток
+// с паузами от 10 секунд до часа.
+func NewWebhookDispatcher(db DBHandler) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:           db,
+		Client:       newWebhookClient(10 * time.Second),
+		MaxAttempts:  8,
+		MinBackoff:   10 * time.Second,
+		MaxBackoff:   time.Hour,
+		BatchSize:    20,
+		PollInterval: time.Second,
+	}
+}
+
+// Run - отправляет доставки, пока не отменен ctx.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	for {
+		n, err := d.DeliverDue(ctx)
+		if err != nil {
+			log.Println("Error delivering webhooks:", err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+// DeliverDue - отправляет одну пачку доставок, время которых подошло, параллельно,
+// чтобы медленный получатель не задерживал остальных. Возвращает, сколько доставок было взято.
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) (int, error) {
+	// Пока доставка у нас, другие экземпляры ее не берут; запас - на запись результата
+	lease := d.Client.Timeout + time.Minute
+	due, err := d.db.ClaimWebhookDeliveries(d.BatchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(due))
+	for i, item := range due {
+		wg.Add(1)
+		go func(i int, item DueDelivery) {
+			defer wg.Done()
+			attempt := d.deliver(ctx, item)
+			errs[i] = d.db.RecordWebhookAttempt(item.Delivery.ID, attempt)
+		}(i, item)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return len(due), err
+		}
+	}
+	return len(due), nil
+}
+
+// deliver - одна попытка отправки. Успех - любой ответ 2xx.
+func (d *WebhookDispatcher) deliver(ctx context.Context, item DueDelivery) WebhookAttempt {
+	statusCode, err := d.post(ctx, item)
+	if err == nil {
+		return WebhookAttempt{Status: DeliveryDelivered, StatusCode: statusCode}
+	}
+	attempt := WebhookAttempt{Status: DeliveryPending, StatusCode: statusCode, Error: err.Error()}
+	// attempts в доставке - число прошлых попыток, эта еще не учтена
+	if item.Delivery.Attempts+1 >= d.MaxAttempts {
+		attempt.Status = DeliveryDead
+	} else {
+		attempt.RetryIn = d.backoff(item.Delivery.Attempts)
+	}
+	return attempt
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, item DueDelivery) (int, error) {
+	body := []byte(item.Delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, item.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "goods-webhooks")
+	req.Header.Set(WebhookEventHeader, item.Delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(item.Delivery.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(item.Secret, time.Now(), body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Тело ответа не нужно, но дочитываем его, чтобы соединение вернулось в пул
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff - пауза перед повтором после attempt прошлых попыток, со случайным
+// разбросом от половины до полной, чтобы повторы разных доставок не совпадали.
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.MinBackoff << uint(attempt)
+	if delay <= 0 || delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + mathrand.Int63n(half+1))
+}
+
+// validWebhookURL - абсолютный http(s) адрес. Адреса, заданные IP во внутренней
+// сети или localhost, отклоняются сразу; имена окончательно проверяются при
+// соединении (см. dialPublicOnly).
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return false
+	}
+	return true
+}
+
+// validWebhookEvents - все события из списка известны.
+func validWebhookEvents(events []string) error {
+	for _, event := range events {
+		if !containsString([]string{GoodCreated, GoodUpdated, GoodRemoved}, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// webhookRequest - тело запросов /webhook/*.
+type webhookRequest struct {
+	ProjectID string   `json:"projectId"`
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+}
+
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (*webhookRequest, int, bool) {
+	var request webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	projectID, err := strconv.Atoi(request.ProjectID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	return &request, projectID, true
+}
+
+// CreateWebhook - POST /webhook/create {"projectId": "1", "url": "https://...", "events": ["created"]}
+// Отвечает вебхуком с секретом подписи; секрет больше нигде не возвращается.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, projectID, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	if !validWebhookURL(request.URL) {
+		http.Error(w, "url must be an absolute http or https URL of a public host", http.StatusBadRequest)
+		return
+	}
+	if err := validWebhookEvents(request.Events); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	db := h.store(r.Context())
+	exists, err := db.CheckIfProjectExists(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	hook, err := db.CreateWebhook(projectID, request.URL, request.Events, secret)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	audit(r.Context(), "webhook.create", projectID, hook.ID)
+	hook.Secret = secret
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// ListWebhooks - GET /webhook/list?project_id=1
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, err := strconv.Atoi(r.URL.Query().Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	hooks, err := h.store(r.Context()).ListWebhooks(projectID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// DeleteWebhook - DELETE /webhook/remove {"projectId": "1", "id": "2"}
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, projectID, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(request.ID)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	deleted, err := h.store(r.Context()).DeleteWebhook(projectID, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+	audit(r.Context(), "webhook.remove", projectID, id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"projectId": request.ProjectID, "id": request.ID, "removed": true})
+}
+
+// Сколько доставок отдает /webhook/deliveries по умолчанию и максимум.
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// WebhookDeliveries - GET /webhook/deliveries?project_id=1&webhook_id=2&status=dead&limit=50
+func (h *Handler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	projectID, err := strconv.Atoi(query.Get("project_id"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	var webhookID int
+	if v := query.Get("webhook_id"); v != "" {
+		if webhookID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	status := query.Get("status")
+	if status != "" && !containsString([]string{DeliveryPending, DeliveryDelivered, DeliveryDead}, status) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	limit := defaultDeliveriesLimit
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	deliveries, err := h.store(r.Context()).ListWebhookDeliveries(projectID, webhookID, status, limit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// RedeliverWebhook - POST /webhook/redeliver {"projectId": "1", "id": "15"}, где id - ID доставки.
+// Доставка отправляется заново с тем же телом и полным запасом попыток.
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, projectID, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(request.ID, 10, 64)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	delivery, err := h.store(r.Context()).RedeliverWebhook(projectID, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if delivery == nil {
+		http.NotFound(w, r)
+		return
+	}
+	audit(r.Context(), "webhook.redeliver", projectID, int(id))
+	writeJSON(w, http.StatusOK, delivery)
+}
@@ -0,0 +1,243 @@
+package gotest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookQueue - очередь доставок в памяти вместо Postgres.
+type webhookQueue struct {
+	DBHandler
+	due      []DueDelivery
+	mu       sync.Mutex
+	attempts map[int64]WebhookAttempt
+}
+
+func (q *webhookQueue) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]DueDelivery, error) {
+	due := q.due
+	q.due = nil
+	return due, nil
+}
+
+func (q *webhookQueue) RecordWebhookAttempt(id int64, attempt WebhookAttempt) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.attempts[id] = attempt
+	return nil
+}
+
+func TestWebhookDispatcherSignsAndDelivers(t *testing.T) {
+	const secret = "whsec_test"
+	payload := `{"id":7,"type":"created","good":{"id":1,"project_id":2}}`
+	var received int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !VerifyWebhook(secret, r.Header.Get(WebhookSignatureHeader), body, time.Minute) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if got := r.Header.Get(WebhookEventHeader); got != GoodCreated {
+			t.Errorf("event header = %q, want %q", got, GoodCreated)
+		}
+		if string(body) != payload {
+			t.Errorf("body = %s, want %s", body, payload)
+		}
+		atomic.AddInt32(&received, 1)
+	}))
+	defer receiver.Close()
+
+	queue := &webhookQueue{attempts: map[int64]WebhookAttempt{}}
+	queue.due = []DueDelivery{
+		{Delivery: WebhookDelivery{ID: 1, EventType: GoodCreated, Payload: json.RawMessage(payload)}, URL: receiver.URL, Secret: secret},
+		{Delivery: WebhookDelivery{ID: 2, EventType: GoodCreated, Payload: json.RawMessage(payload)}, URL: receiver.URL, Secret: "wrong"},
+	}
+	dispatcher := NewWebhookDispatcher(queue)
+	// Получатель слушает loopback, куда клиент по умолчанию не соединяется
+	dispatcher.Client = receiver.Client()
+	n, err := dispatcher.DeliverDue(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("DeliverDue = %d, %v", n, err)
+	}
+	if received != 1 {
+		t.Errorf("receiver accepted %d deliveries, want 1", received)
+	}
+	if got := queue.attempts[1]; got.Status != DeliveryDelivered || got.StatusCode != http.StatusOK {
+		t.Errorf("signed delivery: %+v", got)
+	}
+	got := queue.attempts[2]
+	if got.Status != DeliveryPending || got.StatusCode != http.StatusUnauthorized || got.Error == "" {
+		t.Errorf("rejected delivery: %+v", got)
+	}
+	if got.RetryIn < dispatcher.MinBackoff/2 || got.RetryIn > dispatcher.MinBackoff {
+		t.Errorf("first retry in %v, want between %v and %v", got.RetryIn, dispatcher.MinBackoff/2, dispatcher.MinBackoff)
+	}
+}
+
+func TestWebhookDispatcherDeadLetter(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	queue := &webhookQueue{attempts: map[int64]WebhookAttempt{}}
+	dispatcher := NewWebhookDispatcher(queue)
+	dispatcher.Client = receiver.Client()
+	// Последняя разрешенная попытка
+	queue.due = []DueDelivery{{Delivery: WebhookDelivery{ID: 1, Attempts: dispatcher.MaxAttempts - 1, Payload: json.RawMessage(`{}`)}, URL: receiver.URL}}
+	if _, err := dispatcher.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := queue.attempts[1]; got.Status != DeliveryDead || got.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("exhausted delivery: %+v", got)
+	}
+}
+
+func TestWebhookDispatcherDeliversInParallel(t *testing.T) {
+	const batch = 5
+	var arrived sync.WaitGroup
+	arrived.Add(batch)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		<-release
+	}))
+	defer receiver.Close()
+
+	queue := &webhookQueue{attempts: map[int64]WebhookAttempt{}}
+	for i := 1; i <= batch; i++ {
+		queue.due = append(queue.due, DueDelivery{Delivery: WebhookDelivery{ID: int64(i), Payload: json.RawMessage(`{}`)}, URL: receiver.URL})
+	}
+	dispatcher := NewWebhookDispatcher(queue)
+	dispatcher.Client = receiver.Client()
+	done := make(chan error, 1)
+	go func() {
+		_, err := dispatcher.DeliverDue(context.Background())
+		done <- err
+	}()
+
+	// Все запросы должны прийти, пока ни один еще не получил ответ
+	waited := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliveries were sent one after another")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= batch; i++ {
+		if got := queue.attempts[int64(i)]; got.Status != DeliveryDelivered {
+			t.Errorf("delivery %d: %+v", i, got)
+		}
+	}
+}
+
+func TestWebhookDispatcherRefusesInternalAddresses(t *testing.T) {
+	var received int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+	}))
+	defer receiver.Close()
+
+	queue := &webhookQueue{attempts: map[int64]WebhookAttempt{}}
+	queue.due = []DueDelivery{{Delivery: WebhookDelivery{ID: 1, Payload: json.RawMessage(`{}`)}, URL: receiver.URL}}
+	if _, err := NewWebhookDispatcher(queue).DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if received != 0 {
+		t.Error("default client delivered to a loopback address")
+	}
+	if got := queue.attempts[1]; got.Status != DeliveryPending || !strings.Contains(got.Error, errNonPublicAddress.Error()) {
+		t.Errorf("delivery to loopback: %+v", got)
+	}
+}
+
+func TestWebhookDispatcherDoesNotFollowRedirects(t *testing.T) {
+	var followed int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&followed, 1)
+	}))
+	defer internal.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	queue := &webhookQueue{attempts: map[int64]WebhookAttempt{}}
+	queue.due = []DueDelivery{{Delivery: WebhookDelivery{ID: 1, Payload: json.RawMessage(`{}`)}, URL: receiver.URL}}
+	dispatcher := NewWebhookDispatcher(queue)
+	// Разрешаем loopback, но оставляем политику редиректов клиента по умолчанию
+	client := *receiver.Client()
+	client.CheckRedirect = dispatcher.Client.CheckRedirect
+	dispatcher.Client = &client
+	if _, err := dispatcher.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if followed != 0 {
+		t.Error("redirect was followed")
+	}
+	if got := queue.attempts[1]; got.Status != DeliveryPending || got.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("redirected delivery: %+v", got)
+	}
+}
+
+func TestValidWebhookURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://partner.example/hooks":            true,
+		"http://93.184.216.34:8080/hook":           true,
+		"https://[2606:4700::1111]/hook":           true,
+		"ftp://partner.example/hooks":              false,
+		"/hooks":                                   false,
+		"http://localhost:8080/hook":               false,
+		"http://api.localhost/hook":                false,
+		"http://127.0.0.1/hook":                    false,
+		"http://[::1]/hook":                        false,
+		"http://10.0.0.5/hook":                     false,
+		"http://172.16.0.1/hook":                   false,
+		"http://192.168.1.1/hook":                  false,
+		"http://169.254.169.254/latest/meta-data/": false,
+		"http://100.64.0.1/hook":                   false,
+		"http://0.0.0.0/hook":                      false,
+		"http://[fd00::1]/hook":                    false,
+		"http://[fe80::1]/hook":                    false,
+		"http://[::ffff:127.0.0.1]/hook":           false,
+	}
+	for raw, want := range tests {
+		if got := validWebhookURL(raw); got != want {
+			t.Errorf("validWebhookURL(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestWebhookBackoffIsCapped(t *testing.T) {
+	dispatcher := NewWebhookDispatcher(nil)
+	for attempt := 0; attempt < 64; attempt++ {
+		if delay := dispatcher.backoff(attempt); delay <= 0 || delay > dispatcher.MaxBackoff {
+			t.Fatalf("backoff(%d) = %v", attempt, delay)
+		}
+	}
+}
+
+func TestVerifyWebhookRejectsStaleSignature(t *testing.T) {
+	body := []byte(`{}`)
+	signature := SignWebhook("secret", time.Now().Add(-10*time.Minute), body)
+	if VerifyWebhook("secret", signature, body, 5*time.Minute) {
+		t.Error("stale signature accepted")
+	}
+	if !VerifyWebhook("secret", SignWebhook("secret", time.Now(), body), body, 5*time.Minute) {
+		t.Error("fresh signature rejected")
+	}
+}
@@ -0,0 +1,14 @@
+// Правка приоритета в таблице: форма отправляется сразу после изменения поля.
+document.addEventListener('change', function (event) {
+    if (event.target.matches('[data-autosubmit]')) {
+        event.target.form.submit();
+    }
+});
+
+// Формы с data-confirm отправляются только после подтверждения.
+document.addEventListener('submit', function (event) {
+    var message = event.target.dataset.confirm;
+    if (message && !confirm(message)) {
+        event.preventDefault();
+    }
+});
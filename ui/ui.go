@@ -0,0 +1,9 @@
+// Package ui - шаблоны и статические файлы интерфейса, встроенные в бинарник.
+package ui
+
+import "embed"
+
+// Files - каталоги templates и static.
+//
+//go:embed templates static
+var Files embed.FS